@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/TeamTenuki/twiddler/db"
 	"github.com/TeamTenuki/twiddler/messenger"
@@ -18,34 +19,75 @@ type StreamingState interface {
 	Live() []stream.Stream
 }
 
+// Options of the command handler.
+type Options struct {
+	// Templates are the global templates of announcements by kind, previewed
+	// unless overridden by room settings.
+	Templates map[string]string
+
+	// ConfiguredPlatforms are platforms of rooms added by configuration rather than commands,
+	// e.g. webhooks, which receive no commands. Their rooms are managed from any platform,
+	// but can't be forgotten.
+	ConfiguredPlatforms []string
+}
+
 type Handler struct {
-	commands map[string]Command
-	state    StreamingState
+	commands  map[string]Command
+	state     StreamingState
+	templates map[string]string
+	// configured are the ConfiguredPlatforms.
+	configured map[string]bool
+
+	// verbatim maps commands to the number of their arguments, the last of which
+	// is the rest of the message taken verbatim, e.g. a multiline template.
+	verbatim map[string]int
 }
 
-func NewHandler(state StreamingState) *Handler {
-	h := &Handler{state: state}
+func NewHandler(state StreamingState, opts Options) *Handler {
+	h := &Handler{state: state, templates: opts.Templates, configured: make(map[string]bool)}
+	for _, platform := range opts.ConfiguredPlatforms {
+		h.configured[platform] = true
+	}
 
 	h.commands = map[string]Command{
-		"list":   h.listCommand,
-		"spam":   h.spamCommand,
-		"forget": h.forgetCommand,
-		"help":   h.helpCommand,
+		"list":     h.listCommand,
+		"spam":     h.spamCommand,
+		"forget":   h.forgetCommand,
+		"set":      h.setCommand,
+		"unset":    h.unsetCommand,
+		"settings": h.settingsCommand,
+		"filter":   h.filterCommand,
+		"follow":   h.followCommand,
+		"unfollow": h.unfollowCommand,
+		"follows":  h.followsCommand,
+		"mention":  h.mentionCommand,
+		"preview":  h.previewCommand,
+		"help":     h.helpCommand,
+	}
+
+	h.verbatim = map[string]int{
+		"set": 3,
 	}
 
 	return h
 }
 
-var commandRegex = regexp.MustCompile(`^<@\d+>\s+(\w+)(\s*[\w<>#]+)*$`)
+// commandRegex matches commands, optionally preceded by a mention of the bot,
+// which messengers without such mentions strip beforehand.
+var commandRegex = regexp.MustCompile(`(?s)^(?:<@!?\w+>\s+)?(\w+)(.*)$`)
 
 func (h *Handler) Handle(c context.Context, sourceID, message string, m messenger.Messenger) error {
-	groups := commandRegex.FindAllStringSubmatch(message, -1)
+	groups := commandRegex.FindAllStringSubmatch(strings.TrimSpace(message), -1)
 	if groups == nil {
 		return nil
 	}
 
 	command := strings.TrimSpace(groups[0][1])
-	args := groups[0][2:]
+
+	args := strings.Fields(groups[0][2])
+	if n, exists := h.verbatim[command]; exists {
+		args = splitArgs(groups[0][2], n)
+	}
 
 	if handler, exists := h.commands[command]; exists {
 		return handler(c, sourceID, args, m)
@@ -54,6 +96,29 @@ func (h *Handler) Handle(c context.Context, sourceID, message string, m messenge
 	return nil
 }
 
+// splitArgs splits s into at most n fields separated by whitespace. The last field is
+// the rest of s, with only the whitespace around it trimmed.
+func splitArgs(s string, n int) []string {
+	args := make([]string, 0, n)
+	s = strings.TrimSpace(s)
+
+	for s != "" && len(args) < n-1 {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			break
+		}
+
+		args = append(args, s[:i])
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	}
+
+	if s != "" {
+		args = append(args, s)
+	}
+
+	return args
+}
+
 func (h *Handler) listCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
 	streams := h.state.Live()
 
@@ -69,21 +134,22 @@ func (h *Handler) spamCommand(c context.Context, sourceID string, args []string,
 		return m.MessageText(c, sourceID, "Command `spam` requires an argument - channel where it will spam")
 	}
 
-	roomID, err := parseRoomID(args[0])
+	roomID, err := h.parseRoomID(c, args[0], m)
 	if err != nil {
 		return m.MessageText(c, sourceID, err.Error())
 	}
 
 	db := db.FromContext(c)
-	if _, err := db.ExecContext(c, `INSERT INTO [rooms] ([room_id]) VALUES (?)`, roomID); err != nil {
+	platform := messenger.PlatformFromContext(c)
+	if _, err := db.ExecContext(c, `INSERT INTO [rooms] ([room_id], [platform]) VALUES (?, ?)`, roomID, platform); err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
 			return m.MessageText(c, sourceID,
-				fmt.Sprintf("Failed to add channel <#%s>: it is already added.", roomID))
+				fmt.Sprintf("Failed to add channel %s: it is already added.", roomRef(m, roomID)))
 		}
 		return nil
 	}
 
-	m.MessageText(c, sourceID, fmt.Sprintf("Successfully added room <#%s>", roomID))
+	m.MessageText(c, sourceID, fmt.Sprintf("Successfully added room %s", roomRef(m, roomID)))
 
 	return nil
 }
@@ -93,25 +159,80 @@ func (h *Handler) forgetCommand(c context.Context, sourceID string, args []strin
 		return m.MessageText(c, sourceID, "Command `forget` requires an argument - channel which to exclude from spamming")
 	}
 
-	roomID, err := parseRoomID(args[0])
+	roomID, err := h.parseRoomID(c, args[0], m)
 	if err != nil {
 		return m.MessageText(c, sourceID, err.Error())
 	}
 
-	db := db.FromContext(c)
-	if _, err := db.ExecContext(c, `DELETE FROM [rooms] WHERE [room_id] = ?`, roomID); err != nil {
-		m.MessageText(c, sourceID, fmt.Sprintf("Failed to remove room <#%s> :pensive:", roomID))
+	// Configured rooms would be added again on the next start.
+	room, _, err := db.RoomFor(c, roomID)
+	if err != nil {
+		return err
+	}
+
+	if h.configured[room.Platform] {
+		return m.MessageText(c, sourceID,
+			fmt.Sprintf("Failed to remove room %s: it is configured, remove it from the config instead.", roomRef(m, roomID)))
+	}
+
+	conn := db.FromContext(c)
+	if _, err := conn.ExecContext(c, `DELETE FROM [rooms] WHERE [room_id] = ?`, roomID); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to remove room %s :pensive:", roomRef(m, roomID)))
+	}
+
+	if err := db.RoomSettingsDelete(c, roomID); err != nil {
+		return err
+	}
+
+	if err := db.FiltersDelete(c, roomID); err != nil {
+		return err
+	}
+
+	if err := db.FollowsDelete(c, roomID); err != nil {
+		return err
+	}
+
+	if err := db.MentionsDelete(c, roomID); err != nil {
+		return err
 	}
 
-	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully removed room <#%s>", roomID))
+	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully removed room %s", roomRef(m, roomID)))
 }
 
 func (h *Handler) helpCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
-	return m.MessageText(c, sourceID, "```\nUSAGE\n\tspam - Add channel to list of spammable channels\n\tforget - Remove channel from list of spammable channels\n\tlist - List currently live streamers\n\thelp - Display this message```")
+	return m.MessageText(c, sourceID, "```\nUSAGE\n\tspam - Add channel to list of spammable channels\n\tforget - Remove channel from list of spammable channels\n\tlist - List currently live streamers\n\tset - Change a setting of a channel\n\tunset - Restore the default of a channel setting\n\tsettings - Display settings of a channel\n\tfilter - Add, remove or list filters of streams reported to a channel\n\tfollow - Report streams of a Twitch streamer to a channel regardless of category\n\tunfollow - Stop following a Twitch streamer in a channel\n\tfollows - List streamers followed in a channel\n\tmention - Set, remove or list roles and users mentioned with announcements in a channel\n\tpreview - Render announcement templates of a channel against currently live streams\n\thelp - Display this message```")
+}
+
+// parseRoomID parses a reference to a room in a command, which is a Discord-like <#id>
+// unless the messenger resolves references to its rooms itself.
+//
+// Rooms of other platforms are refused, they are managed through their own messengers,
+// unless they are configured ones.
+func (h *Handler) parseRoomID(c context.Context, s string, m messenger.Messenger) (string, error) {
+	roomID, err := resolveRoomID(c, s, m)
+	if err != nil {
+		return "", err
+	}
+
+	room, exists, err := db.RoomFor(c, roomID)
+	if err != nil {
+		return "", err
+	}
+
+	platform := messenger.PlatformFromContext(c)
+	if exists && platform != "" && room.Platform != "" && room.Platform != platform && !h.configured[room.Platform] {
+		return "", fmt.Errorf("Room %s belongs to %s.", roomRef(m, roomID), room.Platform)
+	}
+
+	return roomID, nil
 }
 
-func parseRoomID(s string) (string, error) {
-	var roomRegex = regexp.MustCompile(`<#(\d+)>`)
+func resolveRoomID(c context.Context, s string, m messenger.Messenger) (string, error) {
+	if r, ok := m.(messenger.RoomResolver); ok {
+		return r.ResolveRoom(c, s)
+	}
+
+	var roomRegex = regexp.MustCompile(`<#(\w+)(?:\|[^>]*)?>`)
 	var groups = roomRegex.FindAllStringSubmatch(s, -1)
 	if groups == nil {
 		return "", errors.New("Improper room format")
@@ -121,3 +242,12 @@ func parseRoomID(s string) (string, error) {
 
 	return roomID, nil
 }
+
+// roomRef formats a reference to a room in replies, the way its messenger refers to rooms.
+func roomRef(m messenger.Messenger, roomID string) string {
+	if r, ok := m.(messenger.RoomResolver); ok {
+		return r.FormatRoom(roomID)
+	}
+
+	return fmt.Sprintf("<#%s>", roomID)
+}
@@ -0,0 +1,68 @@
+package commands_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestCommandsReferToRoomsTheWayOfMessenger(t *testing.T) {
+	ch := newChat()
+	m := &aliasMessenger{Messenger: ch.m}
+
+	if err := ch.h.Handle(ch.c, "source", "follow #general sensei", m); err != nil {
+		t.Fatalf("Command failed: %s", err)
+	}
+
+	if reply := lastReply(t, ch.m); reply != "Room #general now follows `sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if follows, _ := db.FollowsForRoom(ch.c, "!general"); len(follows) != 1 {
+		t.Errorf("Expected the follow stored for the resolved room, got %+v", follows)
+	}
+
+	if err := ch.h.Handle(ch.c, "source", "follow <#room1> sensei", m); err != nil {
+		t.Fatalf("Command failed: %s", err)
+	}
+
+	if reply := lastReply(t, ch.m); reply != `Unknown room "<#room1>"` {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
+
+//
+// HELPERS
+//
+
+// aliasMessenger refers to rooms by "#alias", which resolve to "!alias" IDs.
+type aliasMessenger struct {
+	*testutil.Messenger
+}
+
+func (m *aliasMessenger) ResolveRoom(c context.Context, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return "", fmt.Errorf("Unknown room %q", ref)
+	}
+
+	return "!" + strings.TrimPrefix(ref, "#"), nil
+}
+
+func (m *aliasMessenger) FormatRoom(roomID string) string {
+	return "#" + strings.TrimPrefix(roomID, "!")
+}
+
+func lastReply(t *testing.T, m *testutil.Messenger) string {
+	t.Helper()
+
+	messages := m.Room("source").Messages
+	if len(messages) == 0 {
+		t.Fatalf("Expected a reply")
+	}
+
+	return messages[len(messages)-1]
+}
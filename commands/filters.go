@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+)
+
+var filterKinds = map[string]string{
+	db.FilterAllow:    "only report streams of the given streamer logins",
+	db.FilterBlock:    "never report streams of the given streamer login",
+	db.FilterInclude:  "only report streams with one of the given keywords in the title",
+	db.FilterExclude:  "never report streams with the given keyword in the title, e.g. `rerun`",
+	db.FilterLanguage: "only report streams in one of the given languages, e.g. `en`",
+}
+
+const filterUsage = "Usage: `filter add <channel> <kind> <value>`, `filter remove <channel> <kind> <value>`" +
+	" or `filter list <channel>`"
+
+func (h *Handler) filterCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) < 2 {
+		return m.MessageText(c, sourceID, filterUsage+"\n"+filterKindsHelp())
+	}
+
+	roomID, err := h.parseRoomID(c, args[1], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	switch args[0] {
+	case "list":
+		return h.filterList(c, sourceID, roomID, m)
+	case "add", "remove":
+		if len(args) < 4 {
+			return m.MessageText(c, sourceID, filterUsage+"\n"+filterKindsHelp())
+		}
+
+		if _, exists := filterKinds[args[2]]; !exists {
+			return m.MessageText(c, sourceID, fmt.Sprintf("Unknown filter kind `%s`\n%s", args[2], filterKindsHelp()))
+		}
+
+		f := db.Filter{
+			RoomID: roomID,
+			Kind:   args[2],
+			Value:  strings.ToLower(strings.Join(args[3:], " ")),
+		}
+
+		if args[0] == "add" {
+			return h.filterAdd(c, sourceID, f, m)
+		}
+
+		return h.filterRemove(c, sourceID, f, m)
+	}
+
+	return m.MessageText(c, sourceID, filterUsage)
+}
+
+func (h *Handler) filterAdd(c context.Context, sourceID string, f db.Filter, m messenger.Messenger) error {
+	if err := db.FilterStore(c, f); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to add filter to room %s :pensive:", roomRef(m, f.RoomID)))
+		return err
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully added filter `%s %s` to room %s", f.Kind, f.Value, roomRef(m, f.RoomID)))
+}
+
+func (h *Handler) filterRemove(c context.Context, sourceID string, f db.Filter, m messenger.Messenger) error {
+	removed, err := db.FilterDelete(c, f)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to remove filter from room %s :pensive:", roomRef(m, f.RoomID)))
+		return err
+	}
+
+	if !removed {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Room %s has no filter `%s %s`", roomRef(m, f.RoomID), f.Kind, f.Value))
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully removed filter `%s %s` from room %s", f.Kind, f.Value, roomRef(m, f.RoomID)))
+}
+
+func (h *Handler) filterList(c context.Context, sourceID, roomID string, m messenger.Messenger) error {
+	filters, err := db.FiltersForRoom(c, roomID)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to retrieve filters of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	if len(filters) == 0 {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Room %s has no filters", roomRef(m, roomID)))
+	}
+
+	lines := make([]string, len(filters))
+	for i, f := range filters {
+		lines[i] = fmt.Sprintf("`%s %s`", f.Kind, f.Value)
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Filters of room %s:\n%s", roomRef(m, roomID), strings.Join(lines, "\n")))
+}
+
+func filterKindsHelp() string {
+	kinds := []string{db.FilterAllow, db.FilterBlock, db.FilterInclude, db.FilterExclude, db.FilterLanguage}
+
+	lines := make([]string, len(kinds))
+	for i, kind := range kinds {
+		lines[i] = fmt.Sprintf("`%s` - %s", kind, filterKinds[kind])
+	}
+
+	return "Filter kinds:\n" + strings.Join(lines, "\n")
+}
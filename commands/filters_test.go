@@ -0,0 +1,133 @@
+package commands_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/commands"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestFilterAddStoresFilters(t *testing.T) {
+	ch := newChat()
+
+	reply := ch.command(t, "filter add <#1> include Go  Lessons")
+	if reply != "Successfully added filter `include go lessons` to room <#1>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	filters, err := db.FiltersForRoom(ch.c, "1")
+	if err != nil {
+		t.Fatalf("Failed to retrieve filters: %s", err)
+	}
+
+	if len(filters) != 1 || filters[0] != (db.Filter{RoomID: "1", Kind: db.FilterInclude, Value: "go lessons"}) {
+		t.Errorf("Unexpected filters %+v", filters)
+	}
+}
+
+func TestFilterRemoveRemovesFilters(t *testing.T) {
+	ch := newChat()
+	ch.command(t, "filter add <#1> block Sensei")
+
+	if reply := ch.command(t, "filter remove <#1> block sensei"); reply != "Successfully removed filter `block sensei` from room <#1>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if reply := ch.command(t, "filter remove <#1> block sensei"); reply != "Room <#1> has no filter `block sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if filters, _ := db.FiltersForRoom(ch.c, "1"); len(filters) != 0 {
+		t.Errorf("Expected no filters, got %+v", filters)
+	}
+}
+
+func TestFilterListListsFilters(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "filter list <#1>"); reply != "Room <#1> has no filters" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	ch.command(t, "filter add <#1> language en")
+	ch.command(t, "filter add <#1> block sensei")
+	ch.command(t, "filter add <#2> block sensei2")
+
+	if reply := ch.command(t, "filter list <#1>"); reply != "Filters of room <#1>:\n`block sensei`\n`language en`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
+
+func TestFilterRejectsInvalidArguments(t *testing.T) {
+	ch := newChat()
+
+	replies := map[string]string{
+		"filter":                         "Usage: `filter add",
+		"filter add <#1> include":        "Usage: `filter add",
+		"filter purge <#1>":              "Usage: `filter add",
+		"filter add <#1> viewers 10":     "Unknown filter kind `viewers`",
+		"filter add room1 include go":    "Improper room format",
+		"<@123> filter add <#1> include": "Usage: `filter add",
+	}
+
+	for message, expected := range replies {
+		if reply := ch.command(t, message); !strings.HasPrefix(reply, expected) {
+			t.Errorf("Expected reply to %q to start with %q, got %q", message, expected, reply)
+		}
+	}
+
+	if filters, _ := db.FiltersForRoom(ch.c, "1"); len(filters) != 0 {
+		t.Errorf("Expected no filters, got %+v", filters)
+	}
+}
+
+//
+// HELPERS
+//
+
+// liveT is a fixed list of live streams.
+type liveT []stream.Stream
+
+func (l liveT) Live() []stream.Stream {
+	return l
+}
+
+// chatT sends commands to a handler from room "source", and reads the replies to them.
+type chatT struct {
+	c context.Context
+	h *commands.Handler
+	m *testutil.Messenger
+}
+
+func newChat(live ...stream.Stream) *chatT {
+	return newChatWithOptions(commands.Options{}, live...)
+}
+
+func newChatWithOptions(opts commands.Options, live ...stream.Stream) *chatT {
+	return &chatT{
+		c: testutil.SetupDB(),
+		h: commands.NewHandler(liveT(live), opts),
+		m: testutil.NewMessenger(),
+	}
+}
+
+// command handles a message, and yields the only reply to it.
+func (ch *chatT) command(t *testing.T, message string) string {
+	t.Helper()
+
+	before := len(ch.m.Room("source").Messages)
+	if err := ch.h.Handle(ch.c, "source", message, ch.m); err != nil {
+		t.Fatalf("Command %q failed: %s", message, err)
+	}
+
+	replies := ch.m.Room("source").Messages[before:]
+	if len(replies) != 1 {
+		t.Fatalf("Expected a reply to %q, got %q", message, replies)
+	}
+
+	return replies[0]
+}
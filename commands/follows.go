@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/stream/twitch"
+)
+
+var loginRegex = regexp.MustCompile(`^\w{1,25}$`)
+
+func (h *Handler) followCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	f, err := h.parseFollow(c, "follow", args, m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	if err := db.FollowStore(c, f); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to follow `%s` in room %s :pensive:", f.Login, roomRef(m, f.RoomID)))
+		return err
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Room %s now follows `%s`", roomRef(m, f.RoomID), f.Login))
+}
+
+func (h *Handler) unfollowCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	f, err := h.parseFollow(c, "unfollow", args, m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	removed, err := db.FollowDelete(c, f)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to unfollow `%s` in room %s :pensive:", f.Login, roomRef(m, f.RoomID)))
+		return err
+	}
+
+	if !removed {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Room %s doesn't follow `%s`", roomRef(m, f.RoomID), f.Login))
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Room %s no longer follows `%s`", roomRef(m, f.RoomID), f.Login))
+}
+
+func (h *Handler) followsCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) == 0 {
+		return m.MessageText(c, sourceID, "Command `follows` requires an argument - channel which follows to show")
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	follows, err := db.FollowsForRoom(c, roomID)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to retrieve follows of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	if len(follows) == 0 {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Room %s doesn't follow anyone", roomRef(m, roomID)))
+	}
+
+	logins := make([]string, len(follows))
+	for i, f := range follows {
+		logins[i] = "`" + f.Login + "`"
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Room %s follows %s", roomRef(m, roomID), strings.Join(logins, ", ")))
+}
+
+// parseFollow parses arguments of a command, which are a room and a Twitch login.
+func (h *Handler) parseFollow(c context.Context, command string, args []string, m messenger.Messenger) (db.Follow, error) {
+	if len(args) < 2 {
+		return db.Follow{}, fmt.Errorf("Command `%s` requires arguments - channel and Twitch login of a streamer", command)
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return db.Follow{}, err
+	}
+
+	if !loginRegex.MatchString(args[1]) {
+		return db.Follow{}, fmt.Errorf("Improper Twitch login `%s`", args[1])
+	}
+
+	return db.Follow{
+		RoomID:  roomID,
+		Service: twitch.ServiceName,
+		Login:   strings.ToLower(args[1]),
+	}, nil
+}
@@ -0,0 +1,82 @@
+package commands_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream/twitch"
+)
+
+func TestFollowStoresFollows(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "follow <#1> Sensei"); reply != "Room <#1> now follows `sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	follows, err := db.FollowsForRoom(ch.c, "1")
+	if err != nil {
+		t.Fatalf("Failed to retrieve follows: %s", err)
+	}
+
+	if len(follows) != 1 || follows[0] != (db.Follow{RoomID: "1", Service: twitch.ServiceName, Login: "sensei"}) {
+		t.Errorf("Unexpected follows %+v", follows)
+	}
+}
+
+func TestUnfollowRemovesFollows(t *testing.T) {
+	ch := newChat()
+	ch.command(t, "follow <#1> sensei")
+
+	if reply := ch.command(t, "unfollow <#1> SENSEI"); reply != "Room <#1> no longer follows `sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if reply := ch.command(t, "unfollow <#1> sensei"); reply != "Room <#1> doesn't follow `sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if follows, _ := db.FollowsForRoom(ch.c, "1"); len(follows) != 0 {
+		t.Errorf("Expected no follows, got %+v", follows)
+	}
+}
+
+func TestFollowsListsFollows(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "follows <#1>"); reply != "Room <#1> doesn't follow anyone" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	ch.command(t, "follow <#1> sensei")
+	ch.command(t, "follow <#1> gopher")
+	ch.command(t, "follow <#2> rustacean")
+
+	if reply := ch.command(t, "follows <#1>"); reply != "Room <#1> follows `gopher`, `sensei`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
+
+func TestFollowRejectsInvalidArguments(t *testing.T) {
+	ch := newChat()
+
+	replies := map[string]string{
+		"follow <#1>":         "Command `follow` requires arguments - channel and Twitch login of a streamer",
+		"unfollow":            "Command `unfollow` requires arguments - channel and Twitch login of a streamer",
+		"follows":             "Command `follows` requires an argument - channel which follows to show",
+		"follow <#1> sen-sei": "Improper Twitch login `sen-sei`",
+		"follow room1 sensei": "Improper room format",
+		"follows #room1":      "Improper room format",
+	}
+
+	for message, expected := range replies {
+		if reply := ch.command(t, message); !strings.HasPrefix(reply, expected) {
+			t.Errorf("Expected reply to %q to start with %q, got %q", message, expected, reply)
+		}
+	}
+
+	if follows, _ := db.FollowsForRoom(ch.c, "1"); len(follows) != 0 {
+		t.Errorf("Expected no follows, got %+v", follows)
+	}
+}
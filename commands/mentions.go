@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+)
+
+// mentionRegex matches mentions of Discord roles and users, Slack users and user groups,
+// Matrix users and Telegram usernames.
+var mentionRegex = regexp.MustCompile(`^(<@[&!]?\w+>|<!subteam\^\w+>|@[a-z0-9._=/-]+:[\w.-]+(:\d+)?|@\w+)$`)
+
+const mentionUsage = "Usage: `mention set <channel> <role or user> [streamer login]`," +
+	" `mention remove <channel> [streamer login]` or `mention list <channel>`"
+
+func (h *Handler) mentionCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) < 2 {
+		return m.MessageText(c, sourceID, mentionUsage)
+	}
+
+	roomID, err := h.parseRoomID(c, args[1], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) < 3 || !mentionRegex.MatchString(args[2]) {
+			return m.MessageText(c, sourceID, mentionUsage)
+		}
+
+		return h.mentionSet(c, sourceID, db.Mention{
+			RoomID:  roomID,
+			Login:   loginArg(args, 3),
+			Mention: args[2],
+		}, m)
+	case "remove":
+		return h.mentionRemove(c, sourceID, roomID, loginArg(args, 2), m)
+	case "list":
+		return h.mentionList(c, sourceID, roomID, m)
+	}
+
+	return m.MessageText(c, sourceID, mentionUsage)
+}
+
+func (h *Handler) mentionSet(c context.Context, sourceID string, mention db.Mention, m messenger.Messenger) error {
+	if err := db.MentionStore(c, mention); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to set mention of room %s :pensive:", roomRef(m, mention.RoomID)))
+		return err
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Announcements of %s in room %s now mention %s",
+		streamersOf(mention.Login), roomRef(m, mention.RoomID), mention.Mention))
+}
+
+func (h *Handler) mentionRemove(c context.Context, sourceID, roomID, login string, m messenger.Messenger) error {
+	removed, err := db.MentionDelete(c, roomID, login)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to remove mention of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	if !removed {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Announcements of %s in room %s mention nobody",
+			streamersOf(login), roomRef(m, roomID)))
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Announcements of %s in room %s no longer mention anyone",
+		streamersOf(login), roomRef(m, roomID)))
+}
+
+func (h *Handler) mentionList(c context.Context, sourceID, roomID string, m messenger.Messenger) error {
+	mentions, err := db.MentionsForRoom(c, roomID)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to retrieve mentions of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	if len(mentions) == 0 {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Announcements in room %s mention nobody", roomRef(m, roomID)))
+	}
+
+	lines := make([]string, len(mentions))
+	for i, mention := range mentions {
+		lines[i] = fmt.Sprintf("%s - %s", streamersOf(mention.Login), mention.Mention)
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Mentions of room %s:\n%s", roomRef(m, roomID), strings.Join(lines, "\n")))
+}
+
+// loginArg yields a lower-cased streamer login from an optional argument at index i.
+func loginArg(args []string, i int) string {
+	if len(args) <= i {
+		return ""
+	}
+
+	return strings.ToLower(args[i])
+}
+
+func streamersOf(login string) string {
+	if login == "" {
+		return "all streamers"
+	}
+
+	return "`" + login + "`"
+}
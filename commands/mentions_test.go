@@ -0,0 +1,88 @@
+package commands_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/db"
+)
+
+func TestMentionSetStoresMentions(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "mention set <#1> <@&42>"); reply != "Announcements of all streamers in room <#1> now mention <@&42>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if reply := ch.command(t, "mention set <#1> <@7> Sensei"); reply != "Announcements of `sensei` in room <#1> now mention <@7>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if mention, _ := db.MentionFor(ch.c, "1", "sensei"); mention != "<@7>" {
+		t.Errorf("Expected mention of sensei to be %q, got %q", "<@7>", mention)
+	}
+
+	if mention, _ := db.MentionFor(ch.c, "1", "gopher"); mention != "<@&42>" {
+		t.Errorf("Expected mention of other streamers to be %q, got %q", "<@&42>", mention)
+	}
+}
+
+func TestMentionRemoveRemovesMentions(t *testing.T) {
+	ch := newChat()
+	ch.command(t, "mention set <#1> <@&42>")
+	ch.command(t, "mention set <#1> <@7> sensei")
+
+	if reply := ch.command(t, "mention remove <#1> sensei"); reply != "Announcements of `sensei` in room <#1> no longer mention anyone" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if reply := ch.command(t, "mention remove <#1> sensei"); reply != "Announcements of `sensei` in room <#1> mention nobody" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	mentions, err := db.MentionsForRoom(ch.c, "1")
+	if err != nil {
+		t.Fatalf("Failed to retrieve mentions: %s", err)
+	}
+
+	if len(mentions) != 1 || mentions[0] != (db.Mention{RoomID: "1", Mention: "<@&42>"}) {
+		t.Errorf("Unexpected mentions %+v", mentions)
+	}
+}
+
+func TestMentionListListsMentions(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "mention list <#1>"); reply != "Announcements in room <#1> mention nobody" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	ch.command(t, "mention set <#1> <@&42>")
+	ch.command(t, "mention set <#1> <@7> sensei")
+
+	if reply := ch.command(t, "mention list <#1>"); reply != "Mentions of room <#1>:\nall streamers - <@&42>\n`sensei` - <@7>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
+
+func TestMentionRejectsInvalidArguments(t *testing.T) {
+	ch := newChat()
+
+	replies := map[string]string{
+		"mention":                   "Usage: `mention set",
+		"mention set <#1>":          "Usage: `mention set",
+		"mention set <#1> everyone": "Usage: `mention set",
+		"mention clear <#1>":        "Usage: `mention set",
+		"mention set room1 <@7>":    "Improper room format",
+	}
+
+	for message, expected := range replies {
+		if reply := ch.command(t, message); !strings.HasPrefix(reply, expected) {
+			t.Errorf("Expected reply to %q to start with %q, got %q", message, expected, reply)
+		}
+	}
+
+	if mentions, _ := db.MentionsForRoom(ch.c, "1"); len(mentions) != 0 {
+		t.Errorf("Expected no mentions, got %+v", mentions)
+	}
+}
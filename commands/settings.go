@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+)
+
+// settingT is a room setting that can be changed with chat commands.
+type settingT struct {
+	help     string
+	validate func(value string) error
+}
+
+var settings = map[string]settingT{
+	db.SettingRestartWindow: {
+		help:     "period within which a new stream of the same streamer is a restart and isn't announced, e.g. `3h` or `0s`",
+		validate: validateDuration,
+	},
+	db.SettingBackOnline: {
+		help:     "`on` or `off`, whether restarts are mentioned with a short message",
+		validate: validateSwitch,
+	},
+}
+
+// templateHelp describes the settings overriding templates of announcements.
+var templateHelp = map[string]string{
+	templates.Title:       "template of announcement titles, e.g. `{{.User.DisplayName}} is live!`",
+	templates.Description: "template of announcement descriptions, e.g. `[{{.Title}}]({{.User.ChannelURL}})`",
+	templates.Footer:      "template of announcement footers, e.g. `Playing {{.Category}}`",
+	templates.Content:     "template of plain text sent along with announcements, e.g. `Go watch {{.User.Name}}!`",
+	templates.ListTitle:   "template of the title of the live streams list, e.g. `{{len .Streams}} live`",
+}
+
+func init() {
+	for _, kind := range templates.Kinds {
+		kind := kind
+
+		settings[templates.SettingKey(kind)] = settingT{
+			help: templateHelp[kind],
+			validate: func(value string) error {
+				_, err := templates.Parse(kind, value)
+				return err
+			},
+		}
+	}
+}
+
+func (h *Handler) setCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) < 3 {
+		return m.MessageText(c, sourceID, "Command `set` requires arguments - channel, setting and its value\n"+settingsHelp())
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	key, value := args[1], args[2]
+
+	setting, exists := settings[key]
+	if !exists {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Unknown setting `%s`\n%s", key, settingsHelp()))
+	}
+
+	if err := setting.validate(value); err != nil {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Invalid value of `%s`: %s", key, err))
+	}
+
+	if err := db.RoomSettingStore(c, roomID, key, value); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to set `%s` of room %s :pensive:", key, roomRef(m, roomID)))
+		return err
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully set `%s` of room %s to `%s`", key, roomRef(m, roomID), value))
+}
+
+func (h *Handler) unsetCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) < 2 {
+		return m.MessageText(c, sourceID, "Command `unset` requires arguments - channel and setting")
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	key := args[1]
+	if _, exists := settings[key]; !exists {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Unknown setting `%s`\n%s", key, settingsHelp()))
+	}
+
+	if err := db.RoomSettingDelete(c, roomID, key); err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to unset `%s` of room %s :pensive:", key, roomRef(m, roomID)))
+		return err
+	}
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Successfully unset `%s` of room %s", key, roomRef(m, roomID)))
+}
+
+func (h *Handler) settingsCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) == 0 {
+		return m.MessageText(c, sourceID, "Command `settings` requires an argument - channel which settings to show")
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	values, err := db.RoomSettingsAll(c, roomID)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to retrieve settings of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	if len(values) == 0 {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Room %s uses default settings", roomRef(m, roomID)))
+	}
+
+	lines := make([]string, 0, len(values))
+	for key, value := range values {
+		lines = append(lines, fmt.Sprintf("`%s` = `%s`", key, value))
+	}
+	sort.Strings(lines)
+
+	return m.MessageText(c, sourceID, fmt.Sprintf("Settings of room %s:\n%s", roomRef(m, roomID), strings.Join(lines, "\n")))
+}
+
+func settingsHelp() string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("`%s` - %s", key, settings[key].help)
+	}
+
+	return "Available settings:\n" + strings.Join(lines, "\n")
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return errors.New("not a duration")
+	}
+
+	if d < 0 {
+		return errors.New("negative duration")
+	}
+
+	return nil
+}
+
+func validateSwitch(value string) error {
+	if value != "on" && value != "off" {
+		return errors.New("expected `on` or `off`")
+	}
+
+	return nil
+}
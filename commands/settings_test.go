@@ -0,0 +1,90 @@
+package commands_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/db"
+)
+
+func TestSetStoresSettings(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "set <#1> restart-window 3h"); reply != "Successfully set `restart-window` of room <#1> to `3h`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if value, _, _ := db.RoomSetting(ch.c, "1", db.SettingRestartWindow); value != "3h" {
+		t.Errorf("Expected restart window %q, got %q", "3h", value)
+	}
+}
+
+func TestSetTakesValuesVerbatim(t *testing.T) {
+	ch := newChat()
+
+	value := "{{.User.DisplayName}}  is live!\nWatch  {{.User.Name}}"
+	ch.command(t, "set  <#1>  content-template "+value)
+
+	stored, _, err := db.RoomSetting(ch.c, "1", "content-template")
+	if err != nil {
+		t.Fatalf("Failed to retrieve setting: %s", err)
+	}
+
+	if stored != value {
+		t.Errorf("Expected template %q, got %q", value, stored)
+	}
+}
+
+func TestUnsetRemovesSettings(t *testing.T) {
+	ch := newChat()
+	ch.command(t, "set <#1> back-online off")
+
+	if reply := ch.command(t, "unset <#1> back-online"); reply != "Successfully unset `back-online` of room <#1>" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if _, exists, _ := db.RoomSetting(ch.c, "1", db.SettingBackOnline); exists {
+		t.Errorf("Expected back-online to be unset")
+	}
+}
+
+func TestSettingsListsSettings(t *testing.T) {
+	ch := newChat()
+
+	if reply := ch.command(t, "settings <#1>"); reply != "Room <#1> uses default settings" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	ch.command(t, "set <#1> restart-window 0s")
+	ch.command(t, "set <#1> back-online on")
+
+	if reply := ch.command(t, "settings <#1>"); reply != "Settings of room <#1>:\n`back-online` = `on`\n`restart-window` = `0s`" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
+
+func TestSetRejectsInvalidArguments(t *testing.T) {
+	ch := newChat()
+
+	replies := map[string]string{
+		"set <#1> restart-window":              "Command `set` requires arguments - channel, setting and its value",
+		"set <#1> viewers 10":                  "Unknown setting `viewers`",
+		"set <#1> restart-window soon":         "Invalid value of `restart-window`: not a duration",
+		"set <#1> back-online maybe":           "Invalid value of `back-online`",
+		"set <#1> title-template {{.Viewers}}": "Invalid value of `title-template`",
+		"set room1 back-online on":             "Improper room format",
+		"unset <#1>":                           "Command `unset` requires arguments - channel and setting",
+		"unset <#1> viewers":                   "Unknown setting `viewers`",
+		"settings":                             "Command `settings` requires an argument - channel which settings to show",
+	}
+
+	for message, expected := range replies {
+		if reply := ch.command(t, message); !strings.HasPrefix(reply, expected) {
+			t.Errorf("Expected reply to %q to start with %q, got %q", message, expected, reply)
+		}
+	}
+
+	if values, _ := db.RoomSettingsAll(ch.c, "1"); len(values) != 0 {
+		t.Errorf("Expected no settings, got %v", values)
+	}
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+)
+
+// previewLimit is the maximum number of live streams announcements are previewed for.
+const previewLimit = 3
+
+func (h *Handler) previewCommand(c context.Context, sourceID string, args []string, m messenger.Messenger) error {
+	if len(args) == 0 {
+		return m.MessageText(c, sourceID, "Command `preview` requires an argument - channel which templates to preview")
+	}
+
+	roomID, err := h.parseRoomID(c, args[0], m)
+	if err != nil {
+		return m.MessageText(c, sourceID, err.Error())
+	}
+
+	set, err := templates.ForRoom(c, roomID, h.templates)
+	if err != nil {
+		m.MessageText(c, sourceID, fmt.Sprintf("Failed to load templates of room %s :pensive:", roomRef(m, roomID)))
+		return err
+	}
+
+	// Nobody being live shouldn't prevent previewing, so the sample stream stands in.
+	list := templates.NewList(h.state.Live(), clock.NowUTC())
+	if len(list.Streams) == 0 {
+		list.Streams = []templates.Stream{templates.Sample}
+	}
+
+	listTitle, err := set.Render(templates.ListTitle, list)
+	if err != nil {
+		return m.MessageText(c, sourceID, fmt.Sprintf("Failed to render `%s`: %s", templates.ListTitle, err))
+	}
+
+	parts := []string{fmt.Sprintf("Preview of templates of room %s, list title: %s", roomRef(m, roomID), listTitle)}
+
+	for i, s := range list.Streams {
+		if i == previewLimit {
+			break
+		}
+
+		announcement, err := renderAnnouncement(set, s)
+		if err != nil {
+			return m.MessageText(c, sourceID, fmt.Sprintf("Failed to render templates: %s", err))
+		}
+
+		parts = append(parts, announcement)
+	}
+
+	return m.MessageText(c, sourceID, strings.Join(parts, "\n\n"))
+}
+
+// renderAnnouncement renders all the templates of a stream announcement as plain text.
+func renderAnnouncement(set *templates.Set, s templates.Stream) (string, error) {
+	lines := make([]string, 0, 4)
+
+	for _, kind := range []string{templates.Title, templates.Description, templates.Footer, templates.Content} {
+		text, err := set.Render(kind, s)
+		if err != nil {
+			return "", fmt.Errorf("`%s`: %w", kind, err)
+		}
+
+		if text == "" {
+			continue
+		}
+
+		switch kind {
+		case templates.Title:
+			text = "**" + text + "**"
+		case templates.Footer:
+			text = "*" + text + "*"
+		}
+
+		lines = append(lines, text)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,40 @@
+package commands_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/commands"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestPreviewRendersTemplatesOfRoom(t *testing.T) {
+	ch := newChatWithOptions(commands.Options{
+		Templates: map[string]string{
+			templates.ListTitle: `{{len .Streams}} live`,
+			templates.Footer:    `Playing {{.Category}}`,
+		},
+	}, *testutil.NewStream("Go lessons"))
+
+	ch.command(t, "set <#1> title-template {{.User.Name}} is live")
+
+	expected := "Preview of templates of room <#1>, list title: 1 live\n\n" +
+		"**sensei is live**\n[Go lessons](https://www.twitch.tv/sensei)\n*Playing Go*"
+	if reply := ch.command(t, "preview <#1>"); reply != expected {
+		t.Errorf("Expected preview %q, got %q", expected, reply)
+	}
+}
+
+func TestPreviewFallsBackToSample(t *testing.T) {
+	ch := newChat()
+
+	reply := ch.command(t, "preview <#1>")
+	if !strings.Contains(reply, templates.Sample.Title) {
+		t.Errorf("Expected the sample stream previewed, got %q", reply)
+	}
+
+	if reply := ch.command(t, "preview"); reply != "Command `preview` requires an argument - channel which templates to preview" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+}
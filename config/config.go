@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
@@ -17,6 +18,132 @@ type Config struct {
 	TwitchClientID string `json:"twitch-client-id"`
 	TwitchSecret   string `json:"twitch-secret"`
 	DiscordAPI     string `json:"discord-api-key"`
+
+	// DiscordWebhooks are URLs of Discord incoming webhooks to post to, which need no bot.
+	// Each of them is a room with ID "webhook:<webhook ID>". Their tokens are secrets,
+	// which are only kept here, and never stored in the database. Rooms of webhooks are
+	// registered on every start and can't be forgotten, a webhook is removed from here.
+	DiscordWebhooks []string `json:"discord-webhooks"`
+
+	// SlackBotToken enables announcements to Slack, and SlackAppToken commands over Socket Mode.
+	SlackBotToken string `json:"slack-bot-token"`
+	SlackAppToken string `json:"slack-app-token"`
+
+	// TelegramToken enables announcements to and commands from Telegram.
+	TelegramToken string `json:"telegram-token"`
+
+	// MatrixHomeserver enables announcements to and commands from Matrix on behalf of
+	// MatrixUserID, which is looked up with MatrixAccessToken if empty.
+	MatrixHomeserver  string `json:"matrix-homeserver"`
+	MatrixAccessToken string `json:"matrix-access-token"`
+	MatrixUserID      string `json:"matrix-user-id"`
+
+	// IRCAddr enables announcements to and commands from IRC at a "host:port" address,
+	// where IRCChannels are joined in addition to the rooms.
+	IRCAddr         string   `json:"irc-addr"`
+	IRCTLS          bool     `json:"irc-tls"`
+	IRCNick         string   `json:"irc-nick"`
+	IRCPassword     string   `json:"irc-password"`
+	IRCSASLUser     string   `json:"irc-sasl-user"`
+	IRCSASLPassword string   `json:"irc-sasl-password"`
+	IRCChannels     []string `json:"irc-channels"`
+
+	// Webhooks map IDs of rooms to URLs that JSON payloads are posted to, signed with
+	// WebhookSecret, which is required with them. Like rooms of DiscordWebhooks, the rooms
+	// are registered on every start, and are removed from here rather than forgotten.
+	Webhooks      map[string]string `json:"webhooks"`
+	WebhookSecret string            `json:"webhook-secret"`
+
+	// DefaultPlatform is a platform of rooms added before platforms were recorded, i.e.
+	// "discord", "discord-webhook", "slack", "telegram", "matrix", "irc" or "webhook".
+	// It's the first configured one in this order by default.
+	DefaultPlatform string `json:"default-platform"`
+
+	// TwitchGameIDs and TwitchGames list Twitch categories to track, either by ID or by name.
+	// When both are empty, the Go category is tracked.
+	TwitchGameIDs []string `json:"twitch-game-ids"`
+	TwitchGames   []string `json:"twitch-games"`
+
+	// TwitchMaxPages limits how many pages of live streams are followed per poll.
+	TwitchMaxPages int `json:"twitch-max-pages"`
+
+	// TwitchWatchMode selects how live streams are discovered: "poll" (default) polls
+	// Helix for the configured categories, "eventsub" subscribes to EventSub
+	// notifications of TwitchBroadcasters over a WebSocket and "webhook" receives
+	// them at TwitchWebhookCallback.
+	TwitchWatchMode string `json:"twitch-watch-mode"`
+
+	// TwitchBroadcasters is a list of logins of streamers to watch in EventSub modes.
+	TwitchBroadcasters []string `json:"twitch-broadcasters"`
+
+	// TwitchUserToken is a user access token used to create EventSub subscriptions
+	// over a WebSocket, which Twitch doesn't allow with app access tokens.
+	TwitchUserToken string `json:"twitch-user-token"`
+
+	// TwitchReconcileInterval is how often EventSub state is checked against Helix.
+	TwitchReconcileInterval Duration `json:"twitch-reconcile-interval"`
+
+	// TwitchWebhookCallback is a public HTTPS URL of the EventSub webhook receiver,
+	// which listens at TwitchWebhookAddr and verifies messages with TwitchWebhookSecret.
+	TwitchWebhookCallback string `json:"twitch-webhook-callback"`
+	TwitchWebhookAddr     string `json:"twitch-webhook-addr"`
+	TwitchWebhookSecret   string `json:"twitch-webhook-secret"`
+
+	// YouTubeAPIKey enables tracking of YouTube live broadcasts on YouTubeChannelIDs
+	// and found by YouTubeQuery, searching at most once per YouTubeInterval, and no more
+	// often than YouTubeDailyQuota of the API key allows (10000 units by default).
+	YouTubeAPIKey     string   `json:"youtube-api-key"`
+	YouTubeChannelIDs []string `json:"youtube-channel-ids"`
+	YouTubeQuery      string   `json:"youtube-query"`
+	YouTubeInterval   Duration `json:"youtube-interval"`
+	YouTubeDailyQuota int      `json:"youtube-daily-quota"`
+
+	// OfflineGrace is how long a stream has to be missing before it's considered ended.
+	OfflineGrace Duration `json:"offline-grace"`
+
+	// EndedMessages is either "post" (default), "edit" or "silent", and controls
+	// how ended streams are announced.
+	EndedMessages string `json:"ended-messages"`
+
+	// AnnouncementRefresh is a period between edits of announcements of live streams
+	// that refresh their thumbnails, e.g. "10m". Negative disables the refresh.
+	AnnouncementRefresh Duration `json:"announcement-refresh"`
+
+	// RestartWindow is a period within which a new stream of the same streamer is considered
+	// a restart and isn't announced, "1h" by default. Negative announces every restart.
+	// Rooms may override it with the `set` command.
+	RestartWindow Duration `json:"restart-window"`
+
+	// BackOnlineMessages makes restarts mentioned with a short message instead.
+	BackOnlineMessages bool `json:"back-online-messages"`
+
+	// Templates override the default text/templates of announcements by kind, i.e. "title",
+	// "description", "footer", "content" and "list-title". Rooms may override them in turn
+	// with the `set` command.
+	Templates map[string]string `json:"templates"`
+}
+
+// Duration is a time.Duration that is (un)marshaled as a string, e.g. "1h30m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
 }
 
 // Dir returns default config directory. Currently it is a simply "$HOME/.config/twiddler".
@@ -3,37 +3,53 @@ package db
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"strings"
 	"time"
 )
 
 // Room of a messenger that is waiting for reports on new streams.
 type Room struct {
 	// ID of a room in a messenger-specific format.
-	ID string
+	ID string `db:"room_id"`
+	// Platform of the messenger the room belongs to, e.g. "slack".
+	// Empty for rooms added before platforms were recorded, which belong to the default one.
+	Platform string `db:"platform"`
 }
 
 // RoomsAll yields all the rooms from the DB.
 func RoomsAll(c context.Context) ([]Room, error) {
 	db := FromContext(c)
 
-	ids := make([]string, 0)
-	err := db.SelectContext(c, &ids, `SELECT [room_id] FROM [rooms]`)
+	rooms := make([]Room, 0)
+	err := db.SelectContext(c, &rooms, `SELECT [room_id], [platform] FROM [rooms]`)
 	if err != nil {
 		return nil, err
 	}
 
-	rooms := make([]Room, len(ids))
-	for i := range ids {
-		rooms[i] = Room{ID: ids[i]}
+	return rooms, nil
+}
+
+// RoomFor yields a room by its ID, and whether there is one.
+func RoomFor(c context.Context, roomID string) (Room, bool, error) {
+	db := FromContext(c)
+
+	var room Room
+	err := db.GetContext(c, &room, `SELECT [room_id], [platform] FROM [rooms] WHERE [room_id] = ?`, roomID)
+
+	if err == sql.ErrNoRows {
+		return Room{}, false, nil
+	}
+
+	if err != nil {
+		return Room{}, false, err
 	}
 
-	return rooms, err
+	return room, true, nil
 }
 
 // Report is a record of a successful report of a certain stream.
 type Report struct {
+	// Name of the streaming service.
+	Service string
 	// Streamer ID.
 	UserID string
 	// ID of a particular stream.
@@ -42,16 +58,20 @@ type Report struct {
 	StartedAt time.Time
 	// Timestamp of the latest observation of the stream being live by twiddler.
 	ObservedAt time.Time
+	// Whether the stream was considered a restart of a previous one, which isn't announced by default.
+	Restart bool
 }
 
 // RawReport is a Report with unparsed timestamps. Used internally to retrieve rows from the DB.
 //
 // Not meant to be used by the client code.
 type RawReport struct {
+	Service    string `db:"service"`
 	StreamID   string `db:"stream_id"`
 	UserID     string `db:"user_id"`
 	StartedAt  string `db:"started_at"`
 	ObservedAt string `db:"observed_at"`
+	Restart    bool   `db:"restart"`
 }
 
 // Cook converts a RawReport into a Report.
@@ -69,10 +89,12 @@ func (r *RawReport) Cook() (Report, error) {
 	}
 
 	actual := Report{
+		Service:    r.Service,
 		StreamID:   r.StreamID,
 		UserID:     r.UserID,
 		StartedAt:  startedAt,
 		ObservedAt: observedAt,
+		Restart:    r.Restart,
 	}
 
 	return actual, nil
@@ -84,7 +106,7 @@ func ReportsAll(c context.Context) ([]Report, error) {
 	err := db.SelectContext(
 		c,
 		&rawReports,
-		`SELECT [stream_id], [user_id], [started_at], [observed_at] FROM [reports]`,
+		`SELECT [service], [stream_id], [user_id], [started_at], [observed_at], [restart] FROM [reports]`,
 	)
 	if err != nil {
 		return nil, err
@@ -102,17 +124,28 @@ func ReportsAll(c context.Context) ([]Report, error) {
 	return reports, nil
 }
 
-// ReportFor select a report for the given streamID and startedAt.
+// ReportFor select a report for the given service, streamID and startedAt.
 //
 // If there is no such report, sql.ErrNoRows is propagated as a return value.
-func ReportFor(c context.Context, streamID string, startedAt time.Time) (Report, error) {
+func ReportFor(c context.Context, service, streamID string, startedAt time.Time) (Report, error) {
 	db := FromContext(c)
 
 	var raw RawReport
 	err := db.GetContext(
 		c,
 		&raw,
-		`SELECT [stream_id], [user_id], [started_at], [observed_at] FROM [reports] WHERE [stream_id] = ? AND [started_at] = ?`,
+		`SELECT
+			[service]
+			, [stream_id]
+			, [user_id]
+			, [started_at]
+			, [observed_at]
+			, [restart]
+		FROM
+			[reports]
+		WHERE
+			[service] = ? AND [stream_id] = ? AND [started_at] = ?`,
+		service,
 		streamID,
 		startedAt.Format(time.RFC3339),
 	)
@@ -129,44 +162,59 @@ func ReportStore(c context.Context, r Report) error {
 
 	_, err := db.ExecContext(
 		c,
-		`INSERT INTO [reports] ([user_id], [stream_id], [started_at], [observed_at]) VALUES (?, ?, ?, ?)`,
+		`INSERT INTO [reports] ([service], [user_id], [stream_id], [started_at], [observed_at], [restart]) VALUES (?, ?, ?, ?, ?, ?)`,
+		r.Service,
 		r.UserID,
 		r.StreamID,
 		r.StartedAt.Format(time.RFC3339),
 		r.ObservedAt.Format(time.RFC3339),
+		r.Restart,
 	)
 
 	return err
 }
 
+// StreamRef identifies a stream, as IDs of streams are only unique within a service.
+type StreamRef struct {
+	Service  string `db:"service"`
+	StreamID string `db:"stream_id"`
+}
+
 // ReportObserveForStreams will update [observed_at] for every given stream.
-func ReportObserveForStreams(c context.Context, streamIDs []string, at time.Time) error {
+func ReportObserveForStreams(c context.Context, streams []StreamRef, at time.Time) error {
 	db := FromContext(c)
 
-	for i := range streamIDs {
-		streamIDs[i] = "'" + streamIDs[i] + "'"
+	tx, err := db.BeginTxx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range streams {
+		_, err := tx.ExecContext(
+			c,
+			`UPDATE [reports] SET [observed_at] = ? WHERE [service] = ? AND [stream_id] = ?`,
+			at.Format(time.RFC3339),
+			s.Service,
+			s.StreamID,
+		)
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err := db.ExecContext(
-		c,
-		fmt.Sprintf(
-			`UPDATE [reports] SET [observed_at] = ? WHERE [stream_id] IN (%s)`,
-			strings.Join(streamIDs, ", "),
-		),
-		at.Format(time.RFC3339),
-	)
-
-	return err
+	return tx.Commit()
 }
 
-// ReportWasReported answers whether a certain stream was ever successfully reported.
-func ReportWasReported(c context.Context, streamID string) (bool, error) {
+// ReportWasReported answers whether a certain stream of a given service was ever successfully reported.
+func ReportWasReported(c context.Context, service, streamID string) (bool, error) {
 	db := FromContext(c)
 
 	err := db.GetContext(
 		c,
 		new(string),
-		`SELECT [started_at] FROM [reports] WHERE [stream_id] = ? LIMIT 1`,
+		`SELECT [started_at] FROM [reports] WHERE [service] = ? AND [stream_id] = ? LIMIT 1`,
+		service,
 		streamID,
 	)
 
@@ -181,10 +229,10 @@ func ReportWasReported(c context.Context, streamID string) (bool, error) {
 	return true, nil
 }
 
-// ReportLatestByUser yields a latest report for a particular user.
+// ReportLatestByUser yields a latest report for a particular user of a given service.
 //
 // If there is no any reports, sql.ErrNoRows is propagated as a return value.
-func ReportLatestByUser(c context.Context, userID string) (Report, error) {
+func ReportLatestByUser(c context.Context, service, userID string) (Report, error) {
 	db := FromContext(c)
 
 	var raw RawReport
@@ -192,16 +240,19 @@ func ReportLatestByUser(c context.Context, userID string) (Report, error) {
 		c,
 		&raw,
 		`SELECT
-			[user_id]
+			[service]
+			, [user_id]
 			, [stream_id]
 			, [started_at]
 			, [observed_at]
+			, [restart]
 		FROM
 			[reports]
 		WHERE
-			[user_id] = ?
+			[service] = ? AND [user_id] = ?
 		ORDER BY datetime([observed_at]) DESC
 		LIMIT 1`,
+		service,
 		userID,
 	)
 
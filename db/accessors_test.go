@@ -0,0 +1,43 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+)
+
+func TestReportObserveForStreamsKeepsServicesApart(t *testing.T) {
+	c := setupDB()
+	startedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, service := range []string{"twitch", "youtube"} {
+		err := db.ReportStore(c, db.Report{
+			Service:    service,
+			UserID:     "user1",
+			StreamID:   "1",
+			StartedAt:  startedAt,
+			ObservedAt: startedAt,
+		})
+		if err != nil {
+			t.Fatalf("ReportStore failed: %s", err)
+		}
+	}
+
+	observedAt := startedAt.Add(time.Hour)
+	if err := db.ReportObserveForStreams(c, []db.StreamRef{{Service: "twitch", StreamID: "1"}}, observedAt); err != nil {
+		t.Fatalf("ReportObserveForStreams failed: %s", err)
+	}
+
+	expected := map[string]time.Time{"twitch": observedAt, "youtube": startedAt}
+	for service, at := range expected {
+		r, err := db.ReportFor(c, service, "1", startedAt)
+		if err != nil {
+			t.Fatalf("ReportFor failed: %s", err)
+		}
+
+		if !r.ObservedAt.Equal(at) {
+			t.Errorf("Expected %s report observed at %s, got %s", service, at, r.ObservedAt)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+)
+
+// Kinds of room filters.
+const (
+	// FilterAllow is a streamer login. If a room has any, only their streams are reported.
+	FilterAllow = "allow"
+
+	// FilterBlock is a streamer login, whose streams aren't reported.
+	FilterBlock = "block"
+
+	// FilterInclude is a title keyword. If a room has any, only streams with one of them are reported.
+	FilterInclude = "include"
+
+	// FilterExclude is a title keyword, e.g. "rerun". Streams with it aren't reported.
+	FilterExclude = "exclude"
+
+	// FilterLanguage is a language code. If a room has any, only streams in one of them are reported.
+	FilterLanguage = "language"
+)
+
+// Filter restricts streams reported to a room.
+type Filter struct {
+	// ID of a room in a messenger-specific format.
+	RoomID string `db:"room_id"`
+	// Kind of the filter, one of Filter* constants.
+	Kind string `db:"kind"`
+	// Value the filter matches, in lower case.
+	Value string `db:"value"`
+}
+
+// FiltersForRoom yields all the filters of a room.
+func FiltersForRoom(c context.Context, roomID string) ([]Filter, error) {
+	db := FromContext(c)
+
+	filters := make([]Filter, 0)
+	err := db.SelectContext(
+		c,
+		&filters,
+		`SELECT [room_id], [kind], [value] FROM [filters] WHERE [room_id] = ? ORDER BY [kind], [value]`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return filters, nil
+}
+
+// FilterStore adds a filter to a room. Adding an existing filter is a no-op.
+func FilterStore(c context.Context, f Filter) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR IGNORE INTO [filters] ([room_id], [kind], [value]) VALUES (?, ?, ?)`,
+		f.RoomID,
+		f.Kind,
+		f.Value,
+	)
+
+	return err
+}
+
+// FilterDelete removes a filter from a room, and answers whether there was such a filter.
+func FilterDelete(c context.Context, f Filter) (bool, error) {
+	db := FromContext(c)
+
+	res, err := db.ExecContext(
+		c,
+		`DELETE FROM [filters] WHERE [room_id] = ? AND [kind] = ? AND [value] = ?`,
+		f.RoomID,
+		f.Kind,
+		f.Value,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// FiltersDelete removes all the filters of a room.
+func FiltersDelete(c context.Context, roomID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(c, `DELETE FROM [filters] WHERE [room_id] = ?`, roomID)
+
+	return err
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+)
+
+// Follow is a streamer followed by a room regardless of the category they stream in.
+type Follow struct {
+	// ID of a room in a messenger-specific format.
+	RoomID string `db:"room_id"`
+	// Name of the streaming service.
+	Service string `db:"service"`
+	// Login of the streamer, in lower case.
+	Login string `db:"login"`
+}
+
+// FollowStore makes a room follow a streamer. Following a streamer again is a no-op.
+func FollowStore(c context.Context, f Follow) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR IGNORE INTO [follows] ([room_id], [service], [login]) VALUES (?, ?, ?)`,
+		f.RoomID,
+		f.Service,
+		f.Login,
+	)
+
+	return err
+}
+
+// FollowDelete makes a room unfollow a streamer, and answers whether it was following them.
+func FollowDelete(c context.Context, f Follow) (bool, error) {
+	db := FromContext(c)
+
+	res, err := db.ExecContext(
+		c,
+		`DELETE FROM [follows] WHERE [room_id] = ? AND [service] = ? AND [login] = ?`,
+		f.RoomID,
+		f.Service,
+		f.Login,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// FollowsDelete makes a room unfollow all the streamers.
+func FollowsDelete(c context.Context, roomID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(c, `DELETE FROM [follows] WHERE [room_id] = ?`, roomID)
+
+	return err
+}
+
+// FollowsForRoom yields all the streamers followed by a room.
+func FollowsForRoom(c context.Context, roomID string) ([]Follow, error) {
+	db := FromContext(c)
+
+	follows := make([]Follow, 0)
+	err := db.SelectContext(
+		c,
+		&follows,
+		`SELECT [room_id], [service], [login] FROM [follows] WHERE [room_id] = ? ORDER BY [service], [login]`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return follows, nil
+}
+
+// FollowedLogins yields logins of the streamers of a given service followed by any room.
+func FollowedLogins(c context.Context, service string) ([]string, error) {
+	db := FromContext(c)
+
+	logins := make([]string, 0)
+	err := db.SelectContext(c, &logins, `SELECT DISTINCT [login] FROM [follows] WHERE [service] = ?`, service)
+	if err != nil {
+		return nil, err
+	}
+
+	return logins, nil
+}
+
+// FollowExists answers whether a room follows a streamer.
+func FollowExists(c context.Context, f Follow) (bool, error) {
+	db := FromContext(c)
+
+	var n int
+	err := db.GetContext(
+		c,
+		&n,
+		`SELECT COUNT(*) FROM [follows] WHERE [room_id] = ? AND [service] = ? AND [login] = ?`,
+		f.RoomID,
+		f.Service,
+		f.Login,
+	)
+
+	return n > 0, err
+}
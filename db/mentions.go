@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Mention is a user or a group pinged with stream announcements in a room.
+type Mention struct {
+	// ID of a room in a messenger-specific format.
+	RoomID string `db:"room_id"`
+	// Login of the streamer in lower case, which announcements ping. Empty for all the streamers.
+	Login string `db:"login"`
+	// Mention in a messenger-specific format.
+	Mention string `db:"mention"`
+}
+
+// MentionFor yields a mention to ping with an announcement of a given streamer in a room.
+// A mention for the streamer takes precedence over the one for all the streamers.
+//
+// If there is no such mention, an empty string is returned.
+func MentionFor(c context.Context, roomID, login string) (string, error) {
+	db := FromContext(c)
+
+	var mention string
+	err := db.GetContext(
+		c,
+		&mention,
+		`SELECT [mention] FROM [mentions] WHERE [room_id] = ? AND [login] IN ('', ?) ORDER BY [login] DESC LIMIT 1`,
+		roomID,
+		login,
+	)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return mention, err
+}
+
+// MentionStore sets a mention of a room, replacing the previous one for the same streamer.
+func MentionStore(c context.Context, m Mention) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR REPLACE INTO [mentions] ([room_id], [login], [mention]) VALUES (?, ?, ?)`,
+		m.RoomID,
+		m.Login,
+		m.Mention,
+	)
+
+	return err
+}
+
+// MentionDelete removes a mention of a room for a given streamer, or for all the streamers
+// if the login is empty, and answers whether there was one.
+func MentionDelete(c context.Context, roomID, login string) (bool, error) {
+	db := FromContext(c)
+
+	res, err := db.ExecContext(c, `DELETE FROM [mentions] WHERE [room_id] = ? AND [login] = ?`, roomID, login)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+// MentionsDelete removes all the mentions of a room.
+func MentionsDelete(c context.Context, roomID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(c, `DELETE FROM [mentions] WHERE [room_id] = ?`, roomID)
+
+	return err
+}
+
+// MentionsForRoom yields all the mentions of a room.
+func MentionsForRoom(c context.Context, roomID string) ([]Mention, error) {
+	db := FromContext(c)
+
+	mentions := make([]Mention, 0)
+	err := db.SelectContext(
+		c,
+		&mentions,
+		`SELECT [room_id], [login], [mention] FROM [mentions] WHERE [room_id] = ? ORDER BY [login]`,
+		roomID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+)
+
+// Message is a record of a stream announcement posted to a room.
+type Message struct {
+	// ID of a room in a messenger-specific format.
+	RoomID string `db:"room_id"`
+	// Name of the streaming service.
+	Service string `db:"service"`
+	// ID of the announced stream.
+	StreamID string `db:"stream_id"`
+	// ID of the announcement in a messenger-specific format.
+	MessageID string `db:"message_id"`
+}
+
+// MessageStore stores a Message, replacing a previous announcement of the same stream in the same room.
+func MessageStore(c context.Context, m Message) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR REPLACE INTO [messages] ([room_id], [service], [stream_id], [message_id]) VALUES (?, ?, ?, ?)`,
+		m.RoomID,
+		m.Service,
+		m.StreamID,
+		m.MessageID,
+	)
+
+	return err
+}
+
+// MessagesForStream yields announcements of a certain stream of a given service in all the rooms.
+func MessagesForStream(c context.Context, service, streamID string) ([]Message, error) {
+	db := FromContext(c)
+
+	messages := make([]Message, 0)
+	err := db.SelectContext(
+		c,
+		&messages,
+		`SELECT
+			[room_id]
+			, [service]
+			, [stream_id]
+			, [message_id]
+		FROM
+			[messages]
+		WHERE
+			[service] = ? AND [stream_id] = ?`,
+		service,
+		streamID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MessagesDeleteForStream removes announcements of a certain stream of a given service in all the rooms.
+func MessagesDeleteForStream(c context.Context, service, streamID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`DELETE FROM [messages] WHERE [service] = ? AND [stream_id] = ?`,
+		service,
+		streamID,
+	)
+
+	return err
+}
+
+// MessageStreams yields all the streams that have announcements.
+func MessageStreams(c context.Context) ([]StreamRef, error) {
+	db := FromContext(c)
+
+	streams := make([]StreamRef, 0)
+	err := db.SelectContext(
+		c,
+		&streams,
+		`SELECT DISTINCT [service], [stream_id] FROM [messages]`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return streams, nil
+}
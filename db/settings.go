@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Keys of room settings.
+const (
+	// SettingRestartWindow is a duration within which a new stream of the same streamer
+	// is considered a restart of the previous one, and isn't announced.
+	SettingRestartWindow = "restart-window"
+
+	// SettingBackOnline is either "on" or "off", and controls whether suppressed
+	// restarts are mentioned with a short message.
+	SettingBackOnline = "back-online"
+)
+
+// RoomSetting yields a value of a room setting, and whether it's set at all.
+func RoomSetting(c context.Context, roomID, key string) (string, bool, error) {
+	db := FromContext(c)
+
+	var value string
+	err := db.GetContext(
+		c,
+		&value,
+		`SELECT [value] FROM [room_settings] WHERE [room_id] = ? AND [key] = ?`,
+		roomID,
+		key,
+	)
+
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+
+	if err != nil {
+		return "", false, err
+	}
+
+	return value, true, nil
+}
+
+// RoomSettingsAll yields all the settings of a room.
+func RoomSettingsAll(c context.Context, roomID string) (map[string]string, error) {
+	db := FromContext(c)
+
+	rows := make([]struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}, 0)
+	err := db.SelectContext(c, &rows, `SELECT [key], [value] FROM [room_settings] WHERE [room_id] = ?`, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(rows))
+	for _, r := range rows {
+		settings[r.Key] = r.Value
+	}
+
+	return settings, nil
+}
+
+// RoomSettingStore sets a room setting, replacing its previous value.
+func RoomSettingStore(c context.Context, roomID, key, value string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR REPLACE INTO [room_settings] ([room_id], [key], [value]) VALUES (?, ?, ?)`,
+		roomID,
+		key,
+		value,
+	)
+
+	return err
+}
+
+// RoomSettingDelete unsets a room setting, so that the default applies.
+func RoomSettingDelete(c context.Context, roomID, key string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(c, `DELETE FROM [room_settings] WHERE [room_id] = ? AND [key] = ?`, roomID, key)
+
+	return err
+}
+
+// RoomSettingsDelete unsets all the settings of a room.
+func RoomSettingsDelete(c context.Context, roomID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(c, `DELETE FROM [room_settings] WHERE [room_id] = ?`, roomID)
+
+	return err
+}
@@ -9,15 +9,123 @@ func SetupDB(c context.Context) {
 	db := FromContext(c)
 
 	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [rooms] (
-		[room_id] TEXT NOT NULL, UNIQUE ([room_id])
+		[room_id]  TEXT NOT NULL,
+		[platform] TEXT NOT NULL DEFAULT '',
+
+		UNIQUE ([room_id])
+	)`)
+
+	db.MustExecContext(c, reportsTable("reports"))
+
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [users] (
+		[service]           TEXT NOT NULL,
+		[user_id]           TEXT NOT NULL,
+		[name]              TEXT NOT NULL,
+		[display_name]      TEXT NOT NULL,
+		[channel_url]       TEXT NOT NULL,
+		[profile_url]       TEXT NOT NULL,
+		[picture_url]       TEXT NOT NULL,
+		[offline_image_url] TEXT NOT NULL,
+		[expires_at]        TEXT NOT NULL,
+
+		UNIQUE ([service], [user_id])
+	)`)
+
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [room_settings] (
+		[room_id] TEXT NOT NULL,
+		[key]     TEXT NOT NULL,
+		[value]   TEXT NOT NULL,
+
+		UNIQUE ([room_id], [key])
+	)`)
+
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [filters] (
+		[room_id] TEXT NOT NULL,
+		[kind]    TEXT NOT NULL,
+		[value]   TEXT NOT NULL,
+
+		UNIQUE ([room_id], [kind], [value])
+	)`)
+
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [follows] (
+		[room_id] TEXT NOT NULL,
+		[service] TEXT NOT NULL,
+		[login]   TEXT NOT NULL,
+
+		UNIQUE ([room_id], [service], [login])
+	)`)
+
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [mentions] (
+		[room_id] TEXT NOT NULL,
+		[login]   TEXT NOT NULL,
+		[mention] TEXT NOT NULL,
+
+		UNIQUE ([room_id], [login])
 	)`)
 
-	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [reports] (
+	db.MustExecContext(c, `CREATE TABLE IF NOT EXISTS [messages] (
+		[room_id]    TEXT NOT NULL,
+		[service]    TEXT NOT NULL,
+		[stream_id]  TEXT NOT NULL,
+		[message_id] TEXT NOT NULL,
+
+		UNIQUE ([room_id], [service], [stream_id])
+	)`)
+
+	migrate(c)
+}
+
+func reportsTable(name string) string {
+	return `CREATE TABLE IF NOT EXISTS [` + name + `] (
+		[service]     TEXT NOT NULL DEFAULT 'twitch',
 		[user_id]     TEXT NOT NULL,
 		[stream_id]   TEXT NOT NULL,
 		[started_at]  TEXT NOT NULL,
 		[observed_at] TEXT NOT NULL,
+		[restart]     INTEGER NOT NULL DEFAULT 0,
 
-		UNIQUE ([stream_id], [started_at])
-	)`)
+		UNIQUE ([service], [stream_id], [started_at])
+	)`
+}
+
+// migrate brings tables created by older versions up to date.
+// Every migration checks whether it is necessary, so it is safe to run them on every start.
+func migrate(c context.Context) {
+	db := FromContext(c)
+
+	// Reports used to carry no service, and were unique by stream only.
+	if !hasColumn(c, "reports", "service") {
+		tx := db.MustBeginTx(c, nil)
+		tx.MustExecContext(c, reportsTable("reports_migrated"))
+		tx.MustExecContext(c, `INSERT INTO [reports_migrated] ([service], [user_id], [stream_id], [started_at], [observed_at])
+			SELECT 'twitch', [user_id], [stream_id], [started_at], [observed_at] FROM [reports]`)
+		tx.MustExecContext(c, `DROP TABLE [reports]`)
+		tx.MustExecContext(c, `ALTER TABLE [reports_migrated] RENAME TO [reports]`)
+		if err := tx.Commit(); err != nil {
+			panic(err)
+		}
+	}
+
+	// Rooms used to belong to the only messenger, and are left to the default one.
+	if !hasColumn(c, "rooms", "platform") {
+		db.MustExecContext(c, `ALTER TABLE [rooms] ADD COLUMN [platform] TEXT NOT NULL DEFAULT ''`)
+	}
+}
+
+func hasColumn(c context.Context, table, column string) bool {
+	db := FromContext(c)
+
+	var columns []string
+	err := db.SelectContext(c, &columns, `SELECT [name] FROM pragma_table_info(?)`, table)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, name := range columns {
+		if name == column {
+			return true
+		}
+	}
+
+	return false
 }
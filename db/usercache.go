@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+var _ stream.UserCache = &UserCache{}
+
+// UserCache is a stream.UserCache that persists user profiles in the DB,
+// so that they survive restarts.
+type UserCache struct {
+	service string
+	ttl     time.Duration
+}
+
+// NewUserCache returns a UserCache for users of a given streaming service,
+// which keeps profiles for a given duration.
+func NewUserCache(service string, ttl time.Duration) *UserCache {
+	return &UserCache{service: service, ttl: ttl}
+}
+
+// rawUser is a stream.User as it is stored in the DB.
+type rawUser struct {
+	ID              string `db:"user_id"`
+	Name            string `db:"name"`
+	DisplayName     string `db:"display_name"`
+	ChannelURL      string `db:"channel_url"`
+	ProfileURL      string `db:"profile_url"`
+	PictureURL      string `db:"picture_url"`
+	OfflineImageURL string `db:"offline_image_url"`
+	ExpiresAt       string `db:"expires_at"`
+}
+
+func (u *UserCache) User(c context.Context, userID string) (stream.User, bool, error) {
+	db := FromContext(c)
+
+	var raw rawUser
+	err := db.GetContext(
+		c,
+		&raw,
+		`SELECT
+			[user_id]
+			, [name]
+			, [display_name]
+			, [channel_url]
+			, [profile_url]
+			, [picture_url]
+			, [offline_image_url]
+			, [expires_at]
+		FROM
+			[users]
+		WHERE
+			[service] = ? AND [user_id] = ?`,
+		u.service,
+		userID,
+	)
+
+	if err == sql.ErrNoRows {
+		return stream.User{}, false, nil
+	}
+
+	if err != nil {
+		return stream.User{}, false, err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, raw.ExpiresAt)
+	if err != nil {
+		return stream.User{}, false, err
+	}
+
+	if !clock.NowUTC().Before(expiresAt) {
+		return stream.User{}, false, nil
+	}
+
+	user := stream.User{
+		ID:          raw.ID,
+		Service:     u.service,
+		Name:        raw.Name,
+		DisplayName: raw.DisplayName,
+	}
+
+	urls := []struct {
+		dst **url.URL
+		src string
+	}{
+		{&user.ChannelURL, raw.ChannelURL},
+		{&user.ProfileURL, raw.ProfileURL},
+		{&user.PictureURL, raw.PictureURL},
+		{&user.OfflineImageURL, raw.OfflineImageURL},
+	}
+
+	for _, u := range urls {
+		if *u.dst, err = url.Parse(u.src); err != nil {
+			return stream.User{}, false, err
+		}
+	}
+
+	return user, true, nil
+}
+
+func (u *UserCache) StoreUser(c context.Context, user stream.User) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`INSERT OR REPLACE INTO [users] (
+			[service]
+			, [user_id]
+			, [name]
+			, [display_name]
+			, [channel_url]
+			, [profile_url]
+			, [picture_url]
+			, [offline_image_url]
+			, [expires_at]
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.service,
+		user.ID,
+		user.Name,
+		user.DisplayName,
+		urlString(user.ChannelURL),
+		urlString(user.ProfileURL),
+		urlString(user.PictureURL),
+		urlString(user.OfflineImageURL),
+		clock.NowUTC().Add(u.ttl).Format(time.RFC3339),
+	)
+
+	return err
+}
+
+func (u *UserCache) InvalidateUser(c context.Context, userID string) error {
+	db := FromContext(c)
+
+	_, err := db.ExecContext(
+		c,
+		`DELETE FROM [users] WHERE [service] = ? AND [user_id] = ?`,
+		u.service,
+		userID,
+	)
+
+	return err
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	return u.String()
+}
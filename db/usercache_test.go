@@ -0,0 +1,96 @@
+package db_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+func TestUserCacheStoresProfiles(t *testing.T) {
+	c := setupDB()
+	cache := db.NewUserCache("twitch", time.Hour)
+
+	pictureURL, _ := url.Parse("https://example.com/picture.png")
+	user := stream.User{ID: "user1", Name: "streamer", DisplayName: "Streamer", PictureURL: pictureURL}
+
+	if err := cache.StoreUser(c, user); err != nil {
+		t.Fatalf("StoreUser failed: %s", err)
+	}
+
+	cached, exists, err := cache.User(c, "user1")
+	if err != nil || !exists {
+		t.Fatalf("Expected user to be cached, got %v, %v", exists, err)
+	}
+
+	if cached.Name != "streamer" || cached.DisplayName != "Streamer" || cached.Service != "twitch" ||
+		cached.PictureURL.String() != "https://example.com/picture.png" {
+		t.Errorf("Unexpected cached user %+v", cached)
+	}
+
+	if _, exists, _ := cache.User(c, "user2"); exists {
+		t.Errorf("Expected unknown user not to be cached")
+	}
+}
+
+func TestUserCacheExpiresAtTTL(t *testing.T) {
+	c := setupDB()
+	// Expiry is stored with a precision of seconds.
+	fixedClock := clock.OverrideByFixed(time.Now().Truncate(time.Second))
+	defer clock.OverrideClock(nil)
+
+	cache := db.NewUserCache("twitch", time.Hour)
+	cache.StoreUser(c, stream.User{ID: "user1"})
+
+	fixedClock.Add(time.Hour - time.Second)
+
+	if _, exists, _ := cache.User(c, "user1"); !exists {
+		t.Errorf("Expected user to be cached until expiry")
+	}
+
+	fixedClock.Add(time.Second)
+
+	if _, exists, _ := cache.User(c, "user1"); exists {
+		t.Errorf("Expected user to expire exactly at expiry")
+	}
+}
+
+func TestUserCacheIsolatesServices(t *testing.T) {
+	c := setupDB()
+	twitch := db.NewUserCache("twitch", time.Hour)
+	youtube := db.NewUserCache("youtube", time.Hour)
+
+	twitch.StoreUser(c, stream.User{ID: "user1", Name: "twitch-user"})
+	youtube.StoreUser(c, stream.User{ID: "user1", Name: "youtube-user"})
+
+	if user, _, _ := twitch.User(c, "user1"); user.Name != "twitch-user" {
+		t.Errorf("Expected Twitch user, got %+v", user)
+	}
+
+	youtube.InvalidateUser(c, "user1")
+
+	if _, exists, _ := youtube.User(c, "user1"); exists {
+		t.Errorf("Expected invalidated user to be dropped")
+	}
+
+	if _, exists, _ := twitch.User(c, "user1"); !exists {
+		t.Errorf("Expected user of another service to stay cached")
+	}
+}
+
+//
+// HELPERS
+//
+
+func setupDB() context.Context {
+	db.MustInit(":memory:")
+
+	c := db.NewContext(context.Background())
+	db.SetupDB(c)
+
+	return c
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuffer is a default number of events buffered for a subscriber.
+const DefaultBuffer = 16
+
+// Policy controls what a Bus does when a subscriber's buffer is full.
+type Policy int
+
+const (
+	// Block makes the bus wait for the subscriber to catch up, which holds up
+	// the rest of the subscribers as well.
+	Block Policy = iota
+
+	// Drop makes the bus drop the events the subscriber has no room for.
+	Drop
+)
+
+// SubscribeOptions control a Subscription.
+type SubscribeOptions struct {
+	// Name of the subscriber, used in logs.
+	Name string
+
+	// Buffer is a number of events buffered for the subscriber (DefaultBuffer if zero).
+	Buffer int
+
+	// Policy controls what happens to events when the buffer is full.
+	Policy Policy
+}
+
+// Bus publishes events of a Watcher to any number of independent subscribers.
+//
+// Events are shared between subscribers, so they must not be modified.
+type Bus struct {
+	w      Watcher
+	mu     sync.Mutex
+	subs   []*Subscription
+	closed bool
+}
+
+func NewBus(w Watcher) *Bus {
+	return &Bus{w: w}
+}
+
+// Subscribe adds a subscriber to the bus. Subscriptions made after the bus is closed
+// are closed right away.
+func (b *Bus) Subscribe(opts SubscribeOptions) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultBuffer
+	}
+
+	s := &Subscription{
+		opts: opts,
+		c:    make(chan Event, opts.Buffer),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(s.c)
+	} else {
+		b.subs = append(b.subs, s)
+	}
+
+	return s
+}
+
+// Watch starts the underlying watcher and publishes its events, until either its source
+// is closed or the context is cancelled. Either way, all the subscriptions are closed.
+func (b *Bus) Watch(c context.Context) error {
+	go b.w.Watch(c)
+	defer b.close()
+
+	for {
+		select {
+		case e, ok := <-b.w.Source():
+			if !ok {
+				return nil
+			}
+
+			b.publish(c, e)
+		case <-c.Done():
+			// Let the watcher wind down without anyone listening.
+			go func() {
+				for range b.w.Source() {
+				}
+			}()
+
+			return nil
+		}
+	}
+}
+
+func (b *Bus) publish(c context.Context, e Event) {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		switch s.opts.Policy {
+		case Drop:
+			select {
+			case s.c <- e:
+			default:
+				atomic.AddInt64(&s.dropped, 1)
+				log.Printf("Dropped an event for subscriber %q", s.opts.Name)
+			}
+		default:
+			select {
+			case s.c <- e:
+			case <-c.Done():
+				return
+			}
+		}
+	}
+}
+
+func (b *Bus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, s := range b.subs {
+		close(s.c)
+	}
+	b.subs = nil
+}
+
+var _ Watcher = &Subscription{}
+
+// Subscription is a Watcher of the events published on a Bus.
+type Subscription struct {
+	opts    SubscribeOptions
+	c       chan Event
+	dropped int64
+}
+
+// Watch does nothing, as subscriptions are fed by Bus.Watch.
+func (s *Subscription) Watch(c context.Context) error {
+	return nil
+}
+
+func (s *Subscription) Source() <-chan Event {
+	return s.c
+}
+
+// Dropped yields a number of events dropped because the subscriber didn't keep up.
+func (s *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
@@ -0,0 +1,129 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/events"
+)
+
+func TestBusPublishesToAllSubscribers(t *testing.T) {
+	w := newFakeWatcher()
+	bus := events.NewBus(w)
+
+	first := bus.Subscribe(events.SubscribeOptions{Name: "first"})
+	second := bus.Subscribe(events.SubscribeOptions{Name: "second"})
+
+	done := make(chan struct{})
+	go func() {
+		bus.Watch(context.Background())
+		close(done)
+	}()
+
+	w.c <- events.WentLive{}
+	w.c <- events.Snapshot{}
+	close(w.c)
+	<-done
+
+	for _, s := range []*events.Subscription{first, second} {
+		got := collect(s)
+		if len(got) != 2 {
+			t.Errorf("Expected 2 events, got %d", len(got))
+		}
+	}
+}
+
+func TestBusDropsEventsOfSlowSubscribers(t *testing.T) {
+	w := newFakeWatcher()
+	bus := events.NewBus(w)
+
+	slow := bus.Subscribe(events.SubscribeOptions{Name: "slow", Buffer: 1, Policy: events.Drop})
+	fast := bus.Subscribe(events.SubscribeOptions{Name: "fast", Buffer: 3})
+
+	done := make(chan struct{})
+	go func() {
+		bus.Watch(context.Background())
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		w.c <- events.Snapshot{}
+	}
+	close(w.c)
+	<-done
+
+	if got := len(collect(slow)); got != 1 {
+		t.Errorf("Expected 1 event for the slow subscriber, got %d", got)
+	}
+
+	if slow.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped events, got %d", slow.Dropped())
+	}
+
+	if got := len(collect(fast)); got != 3 {
+		t.Errorf("Expected 3 events for the fast subscriber, got %d", got)
+	}
+}
+
+func TestBusClosesSubscriptionsOnCancel(t *testing.T) {
+	w := newFakeWatcher()
+	bus := events.NewBus(w)
+
+	// Nobody reads the blocking subscription, yet cancellation must not hang.
+	blocked := bus.Subscribe(events.SubscribeOptions{Name: "blocked", Buffer: 1})
+
+	c, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bus.Watch(c)
+		close(done)
+	}()
+
+	w.c <- events.Snapshot{}
+	w.c <- events.Snapshot{}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Bus didn't shut down")
+	}
+
+	collect(blocked)
+
+	late := bus.Subscribe(events.SubscribeOptions{})
+	if _, ok := <-late.Source(); ok {
+		t.Errorf("Expected subscription to a closed bus to be closed")
+	}
+}
+
+//
+// HELPERS
+//
+
+type fakeWatcher struct {
+	c chan events.Event
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{c: make(chan events.Event)}
+}
+
+func (w *fakeWatcher) Watch(c context.Context) error {
+	return nil
+}
+
+func (w *fakeWatcher) Source() <-chan events.Event {
+	return w.c
+}
+
+// collect drains a closed subscription.
+func collect(s *events.Subscription) []events.Event {
+	got := make([]events.Event, 0)
+	for e := range s.Source() {
+		got = append(got, e)
+	}
+
+	return got
+}
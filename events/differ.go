@@ -0,0 +1,168 @@
+package events
+
+import (
+	"sort"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// DefaultOfflineGrace is a default period a stream has to be missing from snapshots
+// before it is considered ended.
+const DefaultOfflineGrace = 3 * time.Minute
+
+// Options control Differ.
+type Options struct {
+	// OfflineGrace is a period a stream has to be missing from snapshots before
+	// it is considered ended, which rides out flaky APIs (DefaultOfflineGrace if zero).
+	OfflineGrace time.Duration
+}
+
+// Differ produces events out of consecutive snapshots of live streams.
+//
+// Streams are told apart by their services and IDs, as streamers may have several
+// live streams at a time, e.g. parallel YouTube broadcasts. A new stream of a streamer
+// whose other stream went missing from the same snapshot is considered a restart.
+type Differ struct {
+	opts Options
+	live map[string]*liveT
+
+	// users indexes keys of the live streams by keys of their streamers.
+	users map[string]map[string]struct{}
+}
+
+type liveT struct {
+	s        stream.Stream
+	lastSeen time.Time
+}
+
+func NewDiffer(opts Options) *Differ {
+	if opts.OfflineGrace <= 0 {
+		opts.OfflineGrace = DefaultOfflineGrace
+	}
+
+	return &Differ{
+		opts:  opts,
+		live:  make(map[string]*liveT),
+		users: make(map[string]map[string]struct{}),
+	}
+}
+
+// Diff compares a snapshot of live streams taken at a given time with the previous ones,
+// and yields the events it implies. The last event is always a Snapshot.
+func (d *Differ) Diff(ss []stream.Stream, now time.Time) []Event {
+	events := make([]Event, 0)
+
+	// Restarts are told by the missing streams, so the whole snapshot is looked at first.
+	seen := make(map[string]struct{})
+	unique := make([]stream.Stream, 0, len(ss))
+
+	for _, s := range ss {
+		key := streamKey(&s)
+		if _, yes := seen[key]; yes {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		unique = append(unique, s)
+	}
+
+	for _, s := range unique {
+		l, exists := d.live[streamKey(&s)]
+		if !exists {
+			if previous := d.previous(&s, seen); previous != nil {
+				d.remove(&previous.s)
+				events = append(events, Restarted{Stream: s, Previous: previous.s, LastSeen: previous.lastSeen})
+			} else {
+				events = append(events, WentLive{Stream: s, Parallel: len(d.users[userKey(&s)]) > 0})
+			}
+
+			d.add(s, now)
+			continue
+		}
+
+		if s.Title != l.s.Title {
+			events = append(events, TitleChanged{Stream: s, OldTitle: l.s.Title})
+		}
+
+		if s.CategoryID != l.s.CategoryID || s.Category != l.s.Category {
+			events = append(events, CategoryChanged{
+				Stream:        s,
+				OldCategoryID: l.s.CategoryID,
+				OldCategory:   l.s.Category,
+			})
+		}
+
+		l.s = s
+		l.lastSeen = now
+	}
+
+	// Sort the missing streams, so the events don't depend on the map order.
+	missing := make([]string, 0)
+	for key, l := range d.live {
+		if _, yes := seen[key]; !yes && now.Sub(l.lastSeen) > d.opts.OfflineGrace {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, key := range missing {
+		l := d.live[key]
+		d.remove(&l.s)
+		events = append(events, WentOffline{Stream: l.s, LastSeen: l.lastSeen})
+	}
+
+	return append(events, Snapshot{Streams: ss, At: now})
+}
+
+// previous yields the stream a new stream restarts, i.e. the last seen stream
+// of the same streamer missing from the snapshot, if there is any.
+func (d *Differ) previous(s *stream.Stream, seen map[string]struct{}) *liveT {
+	keys := make([]string, 0)
+	for key := range d.users[userKey(s)] {
+		if _, yes := seen[key]; !yes {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var previous *liveT
+	for _, key := range keys {
+		if l := d.live[key]; previous == nil || l.lastSeen.After(previous.lastSeen) {
+			previous = l
+		}
+	}
+
+	return previous
+}
+
+func (d *Differ) add(s stream.Stream, now time.Time) {
+	d.live[streamKey(&s)] = &liveT{s: s, lastSeen: now}
+
+	keys, exists := d.users[userKey(&s)]
+	if !exists {
+		keys = make(map[string]struct{})
+		d.users[userKey(&s)] = keys
+	}
+
+	keys[streamKey(&s)] = struct{}{}
+}
+
+func (d *Differ) remove(s *stream.Stream) {
+	delete(d.live, streamKey(s))
+
+	keys := d.users[userKey(s)]
+	delete(keys, streamKey(s))
+
+	if len(keys) == 0 {
+		delete(d.users, userKey(s))
+	}
+}
+
+func streamKey(s *stream.Stream) string {
+	return s.Service + "/" + s.ID
+}
+
+func userKey(s *stream.Stream) string {
+	return s.Service + "/" + s.User.ID
+}
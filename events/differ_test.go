@@ -0,0 +1,136 @@
+package events_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/events"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+func TestDiffReportsStreamsGoingLiveOnce(t *testing.T) {
+	d := events.NewDiffer(events.Options{})
+	now := time.Now()
+	s := live("user1", "stream1", "Title")
+
+	expectEvents(t, d.Diff([]stream.Stream{s, s}, now), events.WentLive{Stream: s})
+	expectEvents(t, d.Diff([]stream.Stream{s}, now.Add(time.Minute)))
+}
+
+func TestDiffReportsChanges(t *testing.T) {
+	d := events.NewDiffer(events.Options{})
+	now := time.Now()
+	s := live("user1", "stream1", "Title")
+
+	d.Diff([]stream.Stream{s}, now)
+
+	changed := s
+	changed.Title = "New title"
+	changed.CategoryID = "2"
+	changed.Category = "Baduk"
+
+	expectEvents(t, d.Diff([]stream.Stream{changed}, now),
+		events.TitleChanged{Stream: changed, OldTitle: "Title"},
+		events.CategoryChanged{Stream: changed, OldCategoryID: "1", OldCategory: "Go"},
+	)
+}
+
+func TestDiffReportsRestarts(t *testing.T) {
+	d := events.NewDiffer(events.Options{})
+	now := time.Now()
+	s := live("user1", "stream1", "Title")
+	restarted := live("user1", "stream2", "Another title")
+
+	d.Diff([]stream.Stream{s}, now)
+
+	expectEvents(t, d.Diff([]stream.Stream{restarted}, now.Add(time.Minute)),
+		events.Restarted{Stream: restarted, Previous: s, LastSeen: now},
+	)
+}
+
+func TestDiffTellsParallelStreamsApart(t *testing.T) {
+	d := events.NewDiffer(events.Options{OfflineGrace: time.Minute})
+	now := time.Now()
+	s1 := live("user1", "stream1", "Title")
+	s2 := live("user1", "stream2", "Another title")
+
+	expectEvents(t, d.Diff([]stream.Stream{s1, s2}, now),
+		events.WentLive{Stream: s1},
+		events.WentLive{Stream: s2, Parallel: true},
+	)
+	expectEvents(t, d.Diff([]stream.Stream{s2, s1}, now.Add(time.Minute)))
+
+	expectEvents(t, d.Diff([]stream.Stream{s2}, now.Add(2*time.Minute)))
+	expectEvents(t, d.Diff([]stream.Stream{s2}, now.Add(3*time.Minute)),
+		events.WentOffline{Stream: s1, LastSeen: now.Add(time.Minute)},
+	)
+}
+
+func TestDiffReportsRestartsOfParallelStreams(t *testing.T) {
+	d := events.NewDiffer(events.Options{})
+	now := time.Now()
+	s1 := live("user1", "stream1", "Title")
+	s2 := live("user1", "stream2", "Another title")
+	s3 := live("user1", "stream3", "Yet another title")
+
+	d.Diff([]stream.Stream{s1, s2}, now)
+
+	expectEvents(t, d.Diff([]stream.Stream{s1, s3}, now.Add(time.Minute)),
+		events.Restarted{Stream: s3, Previous: s2, LastSeen: now},
+	)
+
+	// The restarted stream is superseded, so it doesn't go offline later on.
+	expectEvents(t, d.Diff([]stream.Stream{s1, s3}, now.Add(time.Hour)))
+}
+
+func TestDiffReportsStreamsGoingOfflineAfterGrace(t *testing.T) {
+	d := events.NewDiffer(events.Options{OfflineGrace: time.Minute})
+	now := time.Now()
+	s := live("user1", "stream1", "Title")
+
+	d.Diff([]stream.Stream{s}, now)
+
+	expectEvents(t, d.Diff(nil, now.Add(time.Minute)))
+	expectEvents(t, d.Diff(nil, now.Add(2*time.Minute)), events.WentOffline{Stream: s, LastSeen: now})
+	expectEvents(t, d.Diff([]stream.Stream{s}, now.Add(3*time.Minute)), events.WentLive{Stream: s})
+}
+
+//
+// HELPERS
+//
+
+func live(userID, streamID, title string) stream.Stream {
+	return stream.Stream{
+		ID:         streamID,
+		Service:    "twitch",
+		User:       stream.User{ID: userID},
+		Title:      title,
+		CategoryID: "1",
+		Category:   "Go",
+	}
+}
+
+// expectEvents checks the events of a snapshot, ignoring the trailing Snapshot event.
+func expectEvents(t *testing.T, got []events.Event, expected ...events.Event) {
+	t.Helper()
+
+	if len(got) == 0 {
+		t.Fatalf("Expected a Snapshot event")
+	}
+
+	if _, yes := got[len(got)-1].(events.Snapshot); !yes {
+		t.Errorf("Expected the last event to be a Snapshot, got %#v", got[len(got)-1])
+	}
+
+	got = got[:len(got)-1]
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %#v", len(expected), len(got), got)
+	}
+
+	for i := range expected {
+		if !reflect.DeepEqual(got[i], expected[i]) {
+			t.Errorf("Expected event %#v, got %#v", expected[i], got[i])
+		}
+	}
+}
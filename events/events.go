@@ -0,0 +1,72 @@
+// Package events turns snapshots of live streams into typed events about their changes,
+// so that consumers don't have to diff the snapshots themselves.
+package events
+
+import (
+	"time"
+
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// Event is a change in a set of live streams. It is one of WentLive, WentOffline,
+// TitleChanged, CategoryChanged, Restarted or Snapshot.
+type Event interface {
+	event()
+}
+
+// WentLive is emitted when a stream goes live, unless it is a restart.
+type WentLive struct {
+	Stream stream.Stream
+
+	// Parallel tells whether the streamer has other live streams, so the stream
+	// can't be a continuation of their previous one.
+	Parallel bool
+}
+
+// WentOffline is emitted when a live stream has been missing from snapshots for longer
+// than the offline grace period.
+type WentOffline struct {
+	// Stream is the latest observed state of the stream.
+	Stream stream.Stream
+
+	// LastSeen is the time the stream was last observed live.
+	LastSeen time.Time
+}
+
+// TitleChanged is emitted when a live stream changes its title.
+type TitleChanged struct {
+	Stream   stream.Stream
+	OldTitle string
+}
+
+// CategoryChanged is emitted when a live stream changes its category.
+type CategoryChanged struct {
+	Stream        stream.Stream
+	OldCategoryID string
+	OldCategory   string
+}
+
+// Restarted is emitted when a live streamer is observed with a stream of a new ID
+// in place of their previous stream, e.g. after their connection dropped.
+type Restarted struct {
+	Stream   stream.Stream
+	Previous stream.Stream
+
+	// LastSeen is the time the previous stream was last observed live.
+	LastSeen time.Time
+}
+
+// Snapshot is emitted after the events of every snapshot, and carries the snapshot itself.
+type Snapshot struct {
+	Streams []stream.Stream
+
+	// At is the time the snapshot was diffed at.
+	At time.Time
+}
+
+func (WentLive) event()        {}
+func (WentOffline) event()     {}
+func (TitleChanged) event()    {}
+func (CategoryChanged) event() {}
+func (Restarted) event()       {}
+func (Snapshot) event()        {}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"log"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/watcher"
+)
+
+// Watcher is a counterpart of watcher.Watcher that yields events instead of snapshots.
+type Watcher interface {
+	// Watch starts a watcher's loop. This has to be called prior to receiving any values
+	// from Source.
+	Watch(c context.Context) error
+
+	// Source returns a channel of events.
+	// In order to receive values on this channel, Watch has to be called before.
+	Source() <-chan Event
+}
+
+// Feed is a Watcher that diffs the snapshots of an underlying watcher.Watcher.
+type Feed struct {
+	w watcher.Watcher
+	d *Differ
+	c chan Event
+}
+
+func NewFeed(w watcher.Watcher, opts Options) *Feed {
+	return &Feed{
+		w: w,
+		d: NewDiffer(opts),
+		c: make(chan Event),
+	}
+}
+
+// Watch starts the underlying watcher, and closes the Source once the watcher closes its own.
+func (f *Feed) Watch(c context.Context) error {
+	go func() {
+		if err := f.w.Watch(c); err != nil {
+			log.Printf("Failed to watch streams: %s", err)
+		}
+	}()
+
+	for ss := range f.w.Source() {
+		for _, e := range f.d.Diff(ss, clock.NowUTC()) {
+			f.c <- e
+		}
+	}
+
+	close(f.c)
+
+	return nil
+}
+
+func (f *Feed) Source() <-chan Event {
+	return f.c
+}
@@ -4,13 +4,13 @@ go 1.20
 
 require (
 	github.com/bwmarrin/discordgo v0.28.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/jmoiron/sqlx v1.4.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/oauth2 v0.23.0
 )
 
 require (
-	github.com/gorilla/websocket v1.5.0 // indirect
 	golang.org/x/crypto v0.0.0-20220315160706-3147a52a75dd // indirect
 	golang.org/x/sys v0.5.0 // indirect
 )
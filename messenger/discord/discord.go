@@ -3,28 +3,80 @@ package discord
 import (
 	"context"
 	"fmt"
+	"log"
 	"math/rand"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
 	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
 	"github.com/TeamTenuki/twiddler/stream"
 )
 
+// PlatformName is a name of the Discord messaging platform.
+const PlatformName = "discord"
+
+// serviceT is a presentation of a streaming service in embeds.
+type serviceT struct {
+	name    string
+	iconURL string
+}
+
+var services = map[string]serviceT{
+	"twitch": {
+		name:    "Twitch",
+		iconURL: "https://assets.help.twitch.tv/Glitch_Purple_RGB.png",
+	},
+	"youtube": {
+		name:    "YouTube",
+		iconURL: "https://www.gstatic.com/youtube/img/branding/favicon/favicon_144x144.png",
+	},
+}
+
+// serviceOf yields a presentation of the service a stream comes from.
+// Streams without a service are considered to come from Twitch.
+func serviceOf(s *stream.Stream) serviceT {
+	if s.Service == "" {
+		return services["twitch"]
+	}
+
+	if service, exists := services[s.Service]; exists {
+		return service
+	}
+
+	return serviceT{name: s.Service}
+}
+
+// Options of the Discord messenger.
+type Options struct {
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+}
+
 type Messenger struct {
-	s *discordgo.Session
+	s         *discordgo.Session
+	templates map[string]string
 }
 
-func NewMessenger(apiKey string) (messenger.Messenger, error) {
+func NewMessenger(apiKey string, opts Options) (messenger.Messenger, error) {
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
 	s, err := discordgo.New("Bot " + apiKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Discord client instance: %w", err)
 	}
 
 	m := &Messenger{
-		s: s,
+		s:         s,
+		templates: opts.Templates,
 	}
 
 	return m, nil
@@ -38,19 +90,75 @@ func (m *Messenger) Run() error {
 	return nil
 }
 
-func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) error {
-	title := fmt.Sprintf("%s Went Live!", s.User.DisplayName)
-	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
-		title = fmt.Sprintf("%s (%s) Went Live!",
-			strings.Replace(s.User.DisplayName, "_", "\\_", -1),
-			strings.Replace(s.User.Name, "_", "\\_", -1))
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	set := templates.ForRoomOrDefault(c, roomID, m.templates)
+	data := templates.NewStream(s, clock.NowUTC())
+	content := strings.TrimSpace(mention + " " + set.RenderOrDefault(templates.Content, data))
+
+	msg, err := m.s.ChannelMessageSendComplex(roomID, &discordgo.MessageSend{
+		Content:         content,
+		Embeds:          []*discordgo.MessageEmbed{liveEmbed(set, s)},
+		AllowedMentions: allowedMentions(mention),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return msg.ID, nil
+}
+
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.templates)
+	_, err := m.s.ChannelMessageEditEmbed(roomID, messageID, liveEmbed(set, s))
+
+	return err
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	embed := endedEmbed(s, endedAt)
+
+	edit := func() error {
+		_, err := m.s.ChannelMessageEditEmbed(roomID, messageID, embed)
+
+		return err
 	}
 
+	return messenger.EditOrPost(roomID, messageID, edit, func() error {
+		_, err := m.s.ChannelMessageSendEmbed(roomID, embed)
+
+		return err
+	})
+}
+
+// liveEmbed formats an announcement of a stream going live.
+// The thumbnail URL is made unique, so every edit of the announcement refreshes it.
+func liveEmbed(set *templates.Set, s *stream.Stream) *discordgo.MessageEmbed {
+	data := templates.NewStream(s, clock.NowUTC())
 	thumbnailURL := fmt.Sprintf("%s?cache_invalidation_token=%d", s.ThumbnailURL, rand.Int())
+	service := serviceOf(s)
 
-	_, err := m.s.ChannelMessageSendEmbed(roomID, &discordgo.MessageEmbed{
-		Title:       title,
-		Description: fmt.Sprintf("[%s](%s)", s.Title, s.User.ChannelURL),
+	var fields []*discordgo.MessageEmbedField
+	if s.Category != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:   "Category",
+			Value:  s.Category,
+			Inline: true,
+		})
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       set.RenderOrDefault(templates.Title, data),
+		Description: set.RenderOrDefault(templates.Description, data),
 		Image: &discordgo.MessageEmbedImage{
 			URL:    thumbnailURL,
 			Width:  1280,
@@ -61,32 +169,83 @@ func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.St
 			Width:  300,
 			Height: 300,
 		},
-		Color: 0x00aa00,
+		Fields: fields,
+		Color:  0x00aa00,
 		Author: &discordgo.MessageEmbedAuthor{
-			Name:    "Twitch",
+			Name:    service.name,
 			URL:     s.User.ChannelURL.String(),
-			IconURL: "https://assets.help.twitch.tv/Glitch_Purple_RGB.png",
+			IconURL: service.iconURL,
 		},
 		Timestamp: s.StartedAt.Format(time.RFC3339),
 		Footer: &discordgo.MessageEmbedFooter{
-			Text: "Live since",
+			Text: set.RenderOrDefault(templates.Footer, data),
 		},
-	})
+	}
+}
 
-	return err
+// endedEmbed formats a summary of a stream that went offline.
+func endedEmbed(s *stream.Stream, endedAt time.Time) *discordgo.MessageEmbed {
+	service := serviceOf(s)
+
+	var image *discordgo.MessageEmbedImage
+	if s.User.OfflineImageURL != nil && s.User.OfflineImageURL.String() != "" {
+		image = &discordgo.MessageEmbedImage{URL: s.User.OfflineImageURL.String()}
+	}
+
+	return &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("%s Was Live", displayName(s)),
+		Description: fmt.Sprintf("[%s](%s)\nOffline — streamed for %s",
+			s.Title, s.User.ChannelURL, messenger.FormatDuration(endedAt.Sub(s.StartedAt))),
+		Image: image,
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL:    s.User.PictureURL.String(),
+			Width:  300,
+			Height: 300,
+		},
+		Color: 0x777777,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    service.name,
+			URL:     s.User.ChannelURL.String(),
+			IconURL: service.iconURL,
+		},
+		Timestamp: endedAt.Format(time.RFC3339),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Ended",
+		},
+	}
+}
+
+// displayName formats a name of the streamer, mentioning the login if it differs
+// from the display name.
+func displayName(s *stream.Stream) string {
+	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
+		return fmt.Sprintf("%s (%s)",
+			strings.Replace(s.User.DisplayName, "_", "\\_", -1),
+			strings.Replace(s.User.Name, "_", "\\_", -1))
+	}
+
+	return s.User.DisplayName
 }
 
 func (m *Messenger) MessageStreamList(c context.Context, roomID string, s []stream.Stream) error {
 	fields := make([]*discordgo.MessageEmbedField, 0)
 	for _, stream := range s {
+		value := fmt.Sprintf("[%s](%s)", stream.Title, stream.User.ChannelURL)
+		if stream.Category != "" {
+			value += " · " + stream.Category
+		}
+
 		fields = append(fields, &discordgo.MessageEmbedField{
 			Name:  stream.User.Name,
-			Value: fmt.Sprintf("[%s](%s)", stream.Title, stream.User.ChannelURL),
+			Value: value,
 		})
 	}
 
+	set := templates.ForRoomOrDefault(c, roomID, m.templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(s, clock.NowUTC()))
+
 	_, err := m.s.ChannelMessageSendEmbed(roomID, &discordgo.MessageEmbed{
-		Title:  "Currently Live",
+		Title:  title,
 		Fields: fields,
 	})
 
@@ -94,7 +253,11 @@ func (m *Messenger) MessageStreamList(c context.Context, roomID string, s []stre
 }
 
 func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
-	_, err := m.s.ChannelMessageSend(roomID, text)
+	// Texts may quote streamers and users, who must not be able to ping anyone.
+	_, err := m.s.ChannelMessageSendComplex(roomID, &discordgo.MessageSend{
+		Content:         text,
+		AllowedMentions: allowedMentions(""),
+	})
 
 	return err
 }
@@ -115,6 +278,25 @@ func (m *Messenger) Close() error {
 	return m.s.Close()
 }
 
+var mentionRegex = regexp.MustCompile(`^<@([&!]?)(\d+)>$`)
+
+// allowedMentions permits pinging nobody but the given mention of a user or a role, so that
+// text controlled by streamers, e.g. titles, can never ping anyone, let alone @everyone.
+func allowedMentions(mention string) *discordgo.MessageAllowedMentions {
+	allowed := &discordgo.MessageAllowedMentions{Parse: []discordgo.AllowedMentionType{}}
+
+	groups := mentionRegex.FindStringSubmatch(mention)
+	switch {
+	case groups == nil:
+	case groups[1] == "&":
+		allowed.Roles = []string{groups[2]}
+	default:
+		allowed.Users = []string{groups[2]}
+	}
+
+	return allowed
+}
+
 func mentionsBot(s *discordgo.Session, ms []*discordgo.User) bool {
 	for _, u := range ms {
 		if u.ID == s.State.User.ID {
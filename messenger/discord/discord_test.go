@@ -0,0 +1,33 @@
+package discord
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowedMentionsPermitOnlyConfiguredMention(t *testing.T) {
+	tests := []struct {
+		mention string
+		roles   []string
+		users   []string
+	}{
+		{mention: "<@&123>", roles: []string{"123"}},
+		{mention: "<@456>", users: []string{"456"}},
+		{mention: "<@!456>", users: []string{"456"}},
+		{mention: "@everyone"},
+		{mention: ""},
+	}
+
+	for _, test := range tests {
+		allowed := allowedMentions(test.mention)
+
+		if allowed.Parse == nil || len(allowed.Parse) != 0 {
+			t.Errorf("%q: expected no parsed mention types, got %v", test.mention, allowed.Parse)
+		}
+
+		if !reflect.DeepEqual(allowed.Roles, test.roles) || !reflect.DeepEqual(allowed.Users, test.users) {
+			t.Errorf("%q: expected roles %v and users %v, got %v and %v",
+				test.mention, test.roles, test.users, allowed.Roles, allowed.Users)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// WebhookPlatformName is a name of the platform of rooms posted to through Discord webhooks.
+const WebhookPlatformName = "discord-webhook"
+
+// DefaultAPIURL is the base URL of the Discord API that webhooks are executed at.
+const DefaultAPIURL = "https://discord.com/api/v10"
+
+// WebhookRoomPrefix starts IDs of rooms posted to through webhooks, followed by webhook IDs.
+const WebhookRoomPrefix = "webhook:"
+
+const (
+	// maxRateLimitAttempts limits how many times a request is attempted when rate limited.
+	maxRateLimitAttempts = 3
+
+	// maxErrorBodySize limits how much of error responses is quoted in errors.
+	maxErrorBodySize = 1 << 10
+)
+
+var webhookURLRegex = regexp.MustCompile(
+	`^https://(?:(?:canary|ptb)\.)?discord(?:app)?\.com/api/(?:v\d+/)?webhooks/(\d+)/([\w-]+)$`)
+
+// WebhookRoomID yields an ID of the room posted to through a webhook with the given URL.
+// Tokens of webhooks are secret, so room IDs only carry webhook IDs.
+func WebhookRoomID(webhookURL string) (string, error) {
+	groups := webhookURLRegex.FindStringSubmatch(webhookURL)
+	if groups == nil {
+		return "", errors.New("invalid Discord webhook URL")
+	}
+
+	return WebhookRoomPrefix + groups[1], nil
+}
+
+// WebhookOptions of the Discord webhook messenger.
+type WebhookOptions struct {
+	// URLs of incoming webhooks, each of which is a room. They carry secret tokens, so they
+	// are only kept in memory, and rooms are stored by webhook IDs.
+	URLs []string
+
+	// APIURL overrides DefaultAPIURL.
+	APIURL string
+
+	// Username and AvatarURL override the ones of webhooks in messages other than
+	// announcements, which are posted on behalf of streamers.
+	Username  string
+	AvatarURL string
+
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+
+	// Client overrides http.DefaultClient.
+	Client *http.Client
+}
+
+var (
+	_ messenger.Messenger     = &WebhookMessenger{}
+	_ messenger.RoomRegistrar = &WebhookMessenger{}
+)
+
+// WebhookMessenger posts the same messages as Messenger through incoming webhooks,
+// which need no bot user. It receives no commands.
+type WebhookMessenger struct {
+	opts   WebhookOptions
+	client *http.Client
+	// webhooks are URLs of webhooks to execute by rooms.
+	webhooks map[string]string
+}
+
+func NewWebhookMessenger(opts WebhookOptions) (*WebhookMessenger, error) {
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+
+	webhooks := make(map[string]string, len(opts.URLs))
+	for _, u := range opts.URLs {
+		groups := webhookURLRegex.FindStringSubmatch(u)
+		if groups == nil {
+			return nil, errors.New("invalid Discord webhook URL")
+		}
+
+		webhooks[WebhookRoomPrefix+groups[1]] = fmt.Sprintf("%s/webhooks/%s/%s", opts.APIURL, groups[1], groups[2])
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &WebhookMessenger{opts: opts, client: client, webhooks: webhooks}, nil
+}
+
+// MessageStream posts an announcement on behalf of the streamer, i.e. with their name and picture.
+func (m *WebhookMessenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	data := templates.NewStream(s, clock.NowUTC())
+	content := strings.TrimSpace(mention + " " + set.RenderOrDefault(templates.Content, data))
+
+	return m.execute(c, roomID, &discordgo.WebhookParams{
+		Content:         content,
+		Username:        s.User.DisplayName,
+		AvatarURL:       s.User.PictureURL.String(),
+		Embeds:          []*discordgo.MessageEmbed{liveEmbed(set, s)},
+		AllowedMentions: allowedMentions(mention),
+	})
+}
+
+func (m *WebhookMessenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	embeds := []*discordgo.MessageEmbed{liveEmbed(templates.ForRoomOrDefault(c, roomID, m.opts.Templates), s)}
+
+	return m.edit(c, roomID, messageID, &discordgo.WebhookEdit{Embeds: &embeds})
+}
+
+func (m *WebhookMessenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	embed := endedEmbed(s, endedAt)
+
+	edit := func() error {
+		embeds := []*discordgo.MessageEmbed{embed}
+
+		return m.edit(c, roomID, messageID, &discordgo.WebhookEdit{Embeds: &embeds})
+	}
+
+	return messenger.EditOrPost(roomID, messageID, edit, func() error {
+		_, err := m.execute(c, roomID, &discordgo.WebhookParams{
+			Username:        s.User.DisplayName,
+			AvatarURL:       s.User.PictureURL.String(),
+			Embeds:          []*discordgo.MessageEmbed{embed},
+			AllowedMentions: allowedMentions(""),
+		})
+
+		return err
+	})
+}
+
+func (m *WebhookMessenger) MessageStreamList(c context.Context, roomID string, s []stream.Stream) error {
+	fields := make([]*discordgo.MessageEmbedField, 0)
+	for _, stream := range s {
+		value := fmt.Sprintf("[%s](%s)", stream.Title, stream.User.ChannelURL)
+		if stream.Category != "" {
+			value += " · " + stream.Category
+		}
+
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  stream.User.Name,
+			Value: value,
+		})
+	}
+
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(s, clock.NowUTC()))
+
+	_, err := m.execute(c, roomID, &discordgo.WebhookParams{
+		Username:  m.opts.Username,
+		AvatarURL: m.opts.AvatarURL,
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:  title,
+			Fields: fields,
+		}},
+		AllowedMentions: allowedMentions(""),
+	})
+
+	return err
+}
+
+func (m *WebhookMessenger) MessageText(c context.Context, roomID, text string) error {
+	_, err := m.execute(c, roomID, &discordgo.WebhookParams{
+		Content:         text,
+		Username:        m.opts.Username,
+		AvatarURL:       m.opts.AvatarURL,
+		AllowedMentions: allowedMentions(""),
+	})
+
+	return err
+}
+
+// AddCommandHandler does nothing, as commands are never received over webhooks.
+func (m *WebhookMessenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+}
+
+// RegisterRooms adds the rooms of webhooks, as there's no other way to add them.
+// They are added on every start, so they are removed by removing their URLs.
+func (m *WebhookMessenger) RegisterRooms(c context.Context) error {
+	conn := db.FromContext(c)
+	platform := messenger.PlatformFromContext(c)
+	for roomID := range m.webhooks {
+		_, err := conn.ExecContext(c, `INSERT OR IGNORE INTO [rooms] ([room_id], [platform]) VALUES (?, ?)`, roomID, platform)
+		if err != nil {
+			return fmt.Errorf("failed to register Discord webhook room %s: %w", roomID, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *WebhookMessenger) Run() error {
+	return nil
+}
+
+func (m *WebhookMessenger) Close() error {
+	return nil
+}
+
+// webhookURL yields a URL of the webhook of a room, which carries its token.
+func (m *WebhookMessenger) webhookURL(roomID string) (string, error) {
+	u, exists := m.webhooks[roomID]
+	if !exists {
+		return "", fmt.Errorf("no Discord webhook configured for room %s", roomID)
+	}
+
+	return u, nil
+}
+
+// execute posts a message through the webhook of a room and returns its ID.
+func (m *WebhookMessenger) execute(c context.Context, roomID string, params *discordgo.WebhookParams) (string, error) {
+	u, err := m.webhookURL(roomID)
+	if err != nil {
+		return "", err
+	}
+
+	var msg discordgo.Message
+	if err := m.request(c, "POST", u+"?wait=true", params, &msg); err != nil {
+		return "", err
+	}
+
+	return msg.ID, nil
+}
+
+// edit edits a message posted through the webhook of a room.
+func (m *WebhookMessenger) edit(c context.Context, roomID, messageID string, data *discordgo.WebhookEdit) error {
+	u, err := m.webhookURL(roomID)
+	if err != nil {
+		return err
+	}
+
+	return m.request(c, "PATCH", u+"/messages/"+messageID, data, nil)
+}
+
+// request sends a JSON request and decodes the response into res, unless it's nil.
+// Rate limited requests are retried after the delay Discord asks for.
+func (m *WebhookMessenger) request(c context.Context, method, u string, body, res any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(c, method, u, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Add("Content-Type", "application/json")
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			// Errors quote the URL, which carries the token.
+			return errors.New("failed to reach Discord webhook: " + strings.ReplaceAll(err.Error(), u, "<webhook>"))
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRateLimitAttempts {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.NewDecoder(resp.Body).Decode(&limit)
+			resp.Body.Close()
+
+			select {
+			case <-time.After(time.Duration(limit.RetryAfter * float64(time.Second))):
+				continue
+			case <-c.Done():
+				return c.Err()
+			}
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+			return fmt.Errorf("Discord replied with status %s: %s", resp.Status, bytes.TrimSpace(message))
+		}
+
+		if res == nil {
+			return nil
+		}
+
+		return json.NewDecoder(resp.Body).Decode(res)
+	}
+}
@@ -0,0 +1,256 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+const testWebhookURL = "https://discord.com/api/webhooks/123/s3cr3t-token"
+
+func TestWebhookMessageStreamPostsOnBehalfOfStreamer(t *testing.T) {
+	server := newFakeDiscord(t)
+	c := newWebhookContext()
+	m := server.messenger(t, c)
+
+	messageID, err := m.MessageStream(c, "webhook:123", newWebhookStream())
+	if err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	if messageID != "m1" {
+		t.Errorf("Expected message ID m1, got %q", messageID)
+	}
+
+	r := server.lastRequest(t)
+	if r.method != "POST" || r.path != "/webhooks/123/s3cr3t-token" || r.query != "wait=true" {
+		t.Errorf("Unexpected request %s %s?%s", r.method, r.path, r.query)
+	}
+
+	var params discordgo.WebhookParams
+	if err := json.Unmarshal(r.body, &params); err != nil {
+		t.Fatalf("Failed to decode request: %s", err)
+	}
+
+	if params.Username != "Streamer" || params.AvatarURL != "https://example.com/picture.png" {
+		t.Errorf("Expected the name and picture of the streamer, got %q and %q", params.Username, params.AvatarURL)
+	}
+
+	if len(params.Embeds) != 1 || params.Embeds[0].Title != "Streamer Went Live!" {
+		t.Errorf("Unexpected embeds %+v", params.Embeds)
+	}
+}
+
+func TestWebhookRoomsAreRegisteredWithoutTokens(t *testing.T) {
+	server := newFakeDiscord(t)
+	c := newWebhookContext()
+	server.messenger(t, c)
+
+	rooms, err := db.RoomsAll(c)
+	if err != nil {
+		t.Fatalf("Failed to retrieve rooms: %s", err)
+	}
+
+	if len(rooms) != 1 || rooms[0].ID != "webhook:123" || rooms[0].Platform != WebhookPlatformName {
+		t.Errorf("Expected the room of the webhook to be registered, got %+v", rooms)
+	}
+
+	var tables int
+	db.FromContext(c).GetContext(c, &tables, `SELECT COUNT(*) FROM [sqlite_master] WHERE [name] = 'webhooks'`)
+	if tables != 0 {
+		t.Errorf("Expected no table of webhook tokens")
+	}
+}
+
+func TestWebhookEndedStreamEditsAnnouncement(t *testing.T) {
+	server := newFakeDiscord(t)
+	c := newWebhookContext()
+	m := server.messenger(t, c)
+
+	s := newWebhookStream()
+	if err := m.MessageStreamEnded(c, "webhook:123", "m7", s, s.StartedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("MessageStreamEnded failed: %s", err)
+	}
+
+	r := server.lastRequest(t)
+	if r.method != "PATCH" || r.path != "/webhooks/123/s3cr3t-token/messages/m7" {
+		t.Errorf("Unexpected request %s %s", r.method, r.path)
+	}
+
+	var edit discordgo.WebhookEdit
+	if err := json.Unmarshal(r.body, &edit); err != nil {
+		t.Fatalf("Failed to decode request: %s", err)
+	}
+
+	if edit.Embeds == nil || len(*edit.Embeds) != 1 || (*edit.Embeds)[0].Footer.Text != "Ended" {
+		t.Errorf("Unexpected embeds %+v", edit.Embeds)
+	}
+}
+
+func TestWebhookEndedStreamIsPostedIfAnnouncementIsGone(t *testing.T) {
+	server := newFakeDiscord(t)
+	server.statuses = []int{http.StatusNotFound}
+	c := newWebhookContext()
+	m := server.messenger(t, c)
+
+	s := newWebhookStream()
+	if err := m.MessageStreamEnded(c, "webhook:123", "m7", s, s.StartedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("MessageStreamEnded failed: %s", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.requests) != 2 || server.requests[0].method != "PATCH" || server.requests[1].method != "POST" {
+		t.Errorf("Expected an edit followed by a post, got %+v", server.requests)
+	}
+}
+
+func TestWebhookRetriesRateLimitedRequests(t *testing.T) {
+	server := newFakeDiscord(t)
+	server.statuses = []int{http.StatusTooManyRequests}
+	c := newWebhookContext()
+	m := server.messenger(t, c)
+
+	if err := m.MessageText(c, "webhook:123", "hello"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.requests) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(server.requests))
+	}
+}
+
+func TestWebhookRoomID(t *testing.T) {
+	roomID, err := WebhookRoomID(testWebhookURL)
+	if err != nil || roomID != "webhook:123" {
+		t.Errorf("Expected room webhook:123, got %q, %v", roomID, err)
+	}
+
+	if _, err := WebhookRoomID("https://example.com/api/webhooks/123/token"); err == nil {
+		t.Errorf("Expected URLs of other hosts to be rejected")
+	}
+}
+
+//
+// HELPERS
+//
+
+type discordRequest struct {
+	method string
+	path   string
+	query  string
+	body   []byte
+}
+
+type fakeDiscord struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []discordRequest
+	// statuses are replied to the first requests, in order, and 200 to the rest.
+	statuses []int
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	f := &fakeDiscord{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+
+		f.mu.Lock()
+		f.requests = append(f.requests, discordRequest{r.Method, r.URL.Path, r.URL.RawQuery, body})
+		status := http.StatusOK
+		if len(f.statuses) > 0 {
+			status, f.statuses = f.statuses[0], f.statuses[1:]
+		}
+		id := len(f.requests)
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		switch status {
+		case http.StatusOK:
+			json.NewEncoder(w).Encode(map[string]any{"id": fmt.Sprintf("m%d", id)})
+		case http.StatusTooManyRequests:
+			json.NewEncoder(w).Encode(map[string]any{"message": "rate limited", "retry_after": 0.01})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"message": "Unknown Message", "code": 10008})
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeDiscord) messenger(t *testing.T, c context.Context) *WebhookMessenger {
+	m, err := NewWebhookMessenger(WebhookOptions{
+		URLs:   []string{testWebhookURL},
+		APIURL: f.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookMessenger failed: %s", err)
+	}
+
+	if err := m.RegisterRooms(messenger.NewPlatformContext(c, WebhookPlatformName)); err != nil {
+		t.Fatalf("RegisterRooms failed: %s", err)
+	}
+
+	return m
+}
+
+func (f *fakeDiscord) lastRequest(t *testing.T) discordRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.requests) == 0 {
+		t.Fatalf("Expected a request")
+	}
+
+	return f.requests[len(f.requests)-1]
+}
+
+func newWebhookContext() context.Context {
+	db.MustInit(":memory:")
+	c := db.NewContext(context.Background())
+	db.SetupDB(c)
+
+	return c
+}
+
+func newWebhookStream() *stream.Stream {
+	channelURL, _ := url.Parse("https://twitch.tv/streamer")
+	pictureURL, _ := url.Parse("https://example.com/picture.png")
+	thumbnailURL, _ := url.Parse("https://example.com/thumbnail.jpg")
+
+	return &stream.Stream{
+		ID:           "s1",
+		Service:      "twitch",
+		Title:        "Playing Go",
+		Category:     "Go",
+		ThumbnailURL: thumbnailURL,
+		StartedAt:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		User: stream.User{
+			Name:        "streamer",
+			DisplayName: "Streamer",
+			ChannelURL:  channelURL,
+			PictureURL:  pictureURL,
+		},
+	}
+}
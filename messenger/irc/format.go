@@ -0,0 +1,111 @@
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// maxText limits the length of message texts in bytes, so that whole lines stay
+// within 512 bytes as relayed to other clients.
+const maxText = 400
+
+// mIRC formatting codes.
+const (
+	bold  = "\x02"
+	color = "\x03"
+	reset = "\x0f"
+
+	green = "03"
+	grey  = "14"
+)
+
+// liveLine formats an announcement of a stream going live, preceded by mention if any.
+func liveLine(set *templates.Set, s *stream.Stream, mention string) string {
+	data := templates.NewStream(s, clock.NowUTC())
+
+	line := fmt.Sprintf("%s%s[LIVE]%s %s%s%s — %s",
+		color, green, reset,
+		bold, singleLine(plain(set.RenderOrDefault(templates.Title, data))), bold,
+		singleLine(plain(set.RenderOrDefault(templates.Description, data))))
+
+	if s.Category != "" {
+		line += " · " + s.Category
+	}
+
+	if content := strings.TrimSpace(mention + " " + singleLine(plain(set.RenderOrDefault(templates.Content, data)))); content != "" {
+		line = content + " " + line
+	}
+
+	return line
+}
+
+// endedLine formats a summary of a stream that went offline.
+func endedLine(s *stream.Stream, endedAt time.Time) string {
+	return fmt.Sprintf("%s%s[OFFLINE]%s %s was live: %s — streamed for %s",
+		color, grey, reset,
+		displayName(s), singleLine(s.Title), messenger.FormatDuration(endedAt.Sub(s.StartedAt)))
+}
+
+// listLine formats an entry of a list of live streams.
+func listLine(s *stream.Stream) string {
+	line := fmt.Sprintf("%s%s%s — %s <%s>", bold, s.User.Name, bold, singleLine(s.Title), s.User.ChannelURL)
+	if s.Category != "" {
+		line += " · " + s.Category
+	}
+
+	return line
+}
+
+// displayName formats a name of the streamer, mentioning the login if it differs
+// from the display name.
+func displayName(s *stream.Stream) string {
+	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
+		return fmt.Sprintf("%s (%s)", s.User.DisplayName, s.User.Name)
+	}
+
+	return s.User.DisplayName
+}
+
+var (
+	linkRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	boldRegex = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	unescaper = strings.NewReplacer(`\_`, "_", `\*`, "*", "```", "", "`", "")
+)
+
+// plain converts rendered templates and texts from Discord-flavoured Markdown, which
+// templates and commands use, to plain text.
+func plain(s string) string {
+	s = linkRegex.ReplaceAllString(unescaper.Replace(s), "$1 <$2>")
+
+	return boldRegex.ReplaceAllString(s, "$1")
+}
+
+var controlRegex = regexp.MustCompile(`[\x00-\x1f]+`)
+
+// singleLine replaces line breaks and other control characters, which streamers could
+// use to inject IRC commands or formatting, with spaces.
+func singleLine(s string) string {
+	return strings.TrimSpace(controlRegex.ReplaceAllString(s, " "))
+}
+
+// truncate cuts a text to at most n bytes without splitting characters.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	s = s[:n-len("…")]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s + "…"
+}
@@ -0,0 +1,638 @@
+// Package irc implements a messenger that announces streams to IRC channels with colored
+// single-line messages, and receives commands by nick highlights, e.g. "twiddler: list".
+//
+// The connection is kept open in the background: it's re-established with exponential
+// backoff whenever it fails, rejoining all the channels the bot was in, while outgoing
+// messages wait to be sent, paced to stay below flood limits of servers.
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+const (
+	// DefaultPace is an interval between messages once a burst is exhausted.
+	DefaultPace = 2 * time.Second
+
+	// DefaultBurst is how many messages may be sent at once.
+	DefaultBurst = 4
+
+	// DefaultReconnect is the initial delay between reconnection attempts.
+	DefaultReconnect = time.Second
+
+	// maxBackoff limits delays between reconnection attempts.
+	maxBackoff = 5 * time.Minute
+
+	// readTimeout is how long the server may stay silent, servers ping clients more often.
+	readTimeout = 5 * time.Minute
+
+	// queueSize is how many outgoing messages may wait for the connection.
+	queueSize = 256
+)
+
+// PlatformName is a name of the IRC messaging platform.
+const PlatformName = "irc"
+
+// Options of the IRC messenger.
+type Options struct {
+	// Addr is an address of the server, e.g. "irc.libera.chat:6697".
+	Addr string
+
+	// TLS enables TLS, configured by TLSConfig if set.
+	TLS       bool
+	TLSConfig *tls.Config
+
+	// Nick of the bot, also used as the user name unless User is set.
+	Nick     string
+	User     string
+	RealName string
+
+	// Password is a server password, if the server requires one.
+	Password string
+
+	// SASLUser and SASLPassword enable SASL PLAIN authentication.
+	SASLUser     string
+	SASLPassword string
+
+	// Channels are joined on connection besides the rooms of the bot.
+	Channels []string
+
+	// Pace and Burst override DefaultPace and DefaultBurst.
+	Pace  time.Duration
+	Burst int
+
+	// Reconnect overrides DefaultReconnect.
+	Reconnect time.Duration
+
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+}
+
+var (
+	_ messenger.Messenger    = &Messenger{}
+	_ messenger.RoomResolver = &Messenger{}
+)
+
+// Messenger posts to IRC channels, which are its rooms, identified by channel names, e.g. "#go".
+type Messenger struct {
+	opts Options
+	out  chan string
+
+	mu       sync.Mutex
+	handlerC context.Context
+	handler  messenger.Handler
+	nick     string
+	joined   map[string]bool
+	online   bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewMessenger(opts Options) (*Messenger, error) {
+	if opts.Addr == "" || opts.Nick == "" {
+		return nil, errors.New("IRC server address and nick are required")
+	}
+
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
+	if opts.User == "" {
+		opts.User = opts.Nick
+	}
+
+	if opts.RealName == "" {
+		opts.RealName = opts.Nick
+	}
+
+	if opts.Pace == 0 {
+		opts.Pace = DefaultPace
+	}
+
+	if opts.Burst == 0 {
+		opts.Burst = DefaultBurst
+	}
+
+	if opts.Reconnect == 0 {
+		opts.Reconnect = DefaultReconnect
+	}
+
+	m := &Messenger{
+		opts:   opts,
+		out:    make(chan string, queueSize),
+		nick:   opts.Nick,
+		joined: make(map[string]bool),
+	}
+
+	for _, channel := range opts.Channels {
+		m.joined[strings.ToLower(channel)] = true
+	}
+
+	return m, nil
+}
+
+// MessageStream announces a stream. IRC has no notion of message IDs, so none is returned.
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	return "", m.privmsg(roomID, liveLine(templates.ForRoomOrDefault(c, roomID, m.opts.Templates), s, mention))
+}
+
+// UpdateStream does nothing, as IRC messages can't be edited.
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	return nil
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	return m.privmsg(roomID, endedLine(s, endedAt))
+}
+
+func (m *Messenger) MessageStreamList(c context.Context, roomID string, ss []stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(ss, clock.NowUTC()))
+
+	if err := m.privmsg(roomID, bold+singleLine(plain(title))+bold); err != nil {
+		return err
+	}
+
+	for i := range ss {
+		if err := m.privmsg(roomID, listLine(&ss[i])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MessageText sends every line of a text as a separate message.
+func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
+	for _, line := range strings.Split(plain(text), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if err := m.privmsg(roomID, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var channelRegex = regexp.MustCompile(`^[#&][^\s,\x07]{1,49}$`)
+
+// ResolveRoom takes channel names, e.g. "#go", as room IDs. Names are case-insensitive,
+// so they are lower-cased.
+func (m *Messenger) ResolveRoom(c context.Context, ref string) (string, error) {
+	if !channelRegex.MatchString(ref) {
+		return "", errors.New("Improper room format, expected a channel like `#go`")
+	}
+
+	return strings.ToLower(ref), nil
+}
+
+// FormatRoom refers to rooms by their channel names.
+func (m *Messenger) FormatRoom(roomID string) string {
+	return roomID
+}
+
+func (m *Messenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlerC, m.handler = c, h
+}
+
+// Run joins the channels of all the rooms, and starts maintaining the connection.
+func (m *Messenger) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.handlerC
+	if c == nil {
+		c = context.Background()
+	}
+
+	platform := messenger.PlatformFromContext(c)
+	if rooms, err := db.RoomsAll(c); err == nil {
+		for _, r := range rooms {
+			// Rooms of other messengers may look like channels too.
+			if r.Platform != "" && platform != "" && r.Platform != platform {
+				continue
+			}
+
+			if channelRegex.MatchString(r.ID) {
+				m.joined[strings.ToLower(r.ID)] = true
+			}
+		}
+	}
+
+	c, cancel := context.WithCancel(c)
+	m.cancel, m.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		m.connect(c, m.handler)
+	}()
+
+	return nil
+}
+
+func (m *Messenger) Close() error {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	return nil
+}
+
+// privmsg queues a message to a channel, joining it first if necessary.
+func (m *Messenger) privmsg(channel, text string) error {
+	channel = strings.ToLower(channel)
+
+	// Channels are joined on registration, so only joining new ones while online is up to us.
+	m.mu.Lock()
+	join := !m.joined[channel] && m.online
+	m.joined[channel] = true
+	m.mu.Unlock()
+
+	if join {
+		if err := m.queue("JOIN " + channel); err != nil {
+			return err
+		}
+	}
+
+	// Line breaks would inject arbitrary commands.
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+
+	return m.queue(fmt.Sprintf("PRIVMSG %s :%s", channel, truncate(text, maxText)))
+}
+
+func (m *Messenger) queue(line string) error {
+	select {
+	case m.out <- line:
+		return nil
+	default:
+		return errors.New("IRC message queue is full")
+	}
+}
+
+// connect keeps the connection open until c is cancelled, reconnecting with
+// exponential backoff whenever it fails.
+func (m *Messenger) connect(c context.Context, h messenger.Handler) {
+	backoff := m.opts.Reconnect
+	pending := ""
+
+	for {
+		started := time.Now()
+		var err error
+		pending, err = m.session(c, h, pending)
+		if c.Err() != nil {
+			return
+		}
+
+		log.Printf("IRC connection to %s lost: %s", m.opts.Addr, err)
+
+		if time.Since(started) > maxBackoff {
+			backoff = m.opts.Reconnect
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.Done():
+			return
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (m *Messenger) dial(c context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+
+	if m.opts.TLS {
+		config := m.opts.TLSConfig
+		if config == nil {
+			host, _, _ := net.SplitHostPort(m.opts.Addr)
+			config = &tls.Config{ServerName: host}
+		}
+
+		return (&tls.Dialer{NetDialer: d, Config: config}).DialContext(c, "tcp", m.opts.Addr)
+	}
+
+	return d.DialContext(c, "tcp", m.opts.Addr)
+}
+
+// session connects, registers and processes messages from the server until the connection
+// fails. Outgoing messages are written once registered, starting with pending, a message
+// that failed to be written in the previous session, which is returned if it fails again.
+func (m *Messenger) session(c context.Context, h messenger.Handler, pending string) (string, error) {
+	conn, err := m.dial(c)
+	if err != nil {
+		return pending, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Closing a connection is the only way to interrupt a blocking read.
+	go func() {
+		select {
+		case <-c.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	w := &writerT{conn: conn}
+
+	m.mu.Lock()
+	m.nick = m.opts.Nick
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.online = false
+		m.mu.Unlock()
+	}()
+
+	if m.opts.Password != "" {
+		w.write("PASS " + m.opts.Password)
+	}
+	if m.opts.SASLUser != "" {
+		w.write("CAP REQ :sasl")
+	}
+	w.write("NICK " + m.opts.Nick)
+	w.write(fmt.Sprintf("USER %s 0 * :%s", m.opts.User, m.opts.RealName))
+
+	// The writer is started once registered, and reports a message it failed to write
+	// once stopped.
+	stop := make(chan struct{})
+	failed := make(chan string, 1)
+	registered := false
+
+	r := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if registered {
+				close(stop)
+				pending = <-failed
+			}
+
+			return pending, err
+		}
+
+		msg := parseMessage(strings.TrimRight(line, "\r\n"))
+
+		switch msg.command {
+		case "PING":
+			w.write("PONG :" + msg.trailing())
+
+		case "CAP":
+			if len(msg.params) > 1 && msg.params[1] == "ACK" {
+				w.write("AUTHENTICATE PLAIN")
+			} else if len(msg.params) > 1 && msg.params[1] == "NAK" {
+				return pending, errors.New("server doesn't support SASL")
+			}
+
+		case "AUTHENTICATE":
+			if msg.trailing() == "+" {
+				credentials := m.opts.SASLUser + "\x00" + m.opts.SASLUser + "\x00" + m.opts.SASLPassword
+				w.write("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte(credentials)))
+			}
+
+		case "903":
+			w.write("CAP END")
+
+		case "902", "904", "905", "906":
+			return pending, fmt.Errorf("SASL authentication failed: %s", msg.trailing())
+
+		case "433":
+			// The nick is taken, possibly by a ghost of the previous connection.
+			m.mu.Lock()
+			m.nick += "_"
+			nick := m.nick
+			m.mu.Unlock()
+
+			w.write("NICK " + nick)
+
+		case "001":
+			if len(msg.params) > 0 {
+				m.mu.Lock()
+				m.nick = msg.params[0]
+				m.mu.Unlock()
+			}
+
+			m.rejoin(w)
+
+			registered = true
+			go m.write(w, pending, stop, failed)
+
+		case "PRIVMSG":
+			if h == nil || len(msg.params) < 2 {
+				continue
+			}
+
+			if command, ok := m.command(msg.params[0], msg.trailing()); ok {
+				channel := strings.ToLower(msg.params[0])
+				if err := h.Handle(c, channel, command, m); err != nil {
+					log.Printf("Failed to handle IRC command in %s: %s", channel, err)
+				}
+			}
+		}
+	}
+}
+
+// rejoin joins all the channels the bot is supposed to be in, as many at once as fit in a line,
+// and marks the bot online.
+func (m *Messenger) rejoin(w *writerT) {
+	m.mu.Lock()
+	channels := make([]string, 0, len(m.joined))
+	for channel := range m.joined {
+		channels = append(channels, channel)
+	}
+	m.online = true
+	m.mu.Unlock()
+
+	sort.Strings(channels)
+
+	line := ""
+	for _, channel := range channels {
+		if line != "" && len(line)+len(channel) > maxText {
+			w.write("JOIN " + line)
+			line = ""
+		}
+
+		if line != "" {
+			line += ","
+		}
+		line += channel
+	}
+
+	if line != "" {
+		w.write("JOIN " + line)
+	}
+}
+
+// write writes queued messages, pacing them with a token bucket of Burst messages
+// refilled one per Pace, until the connection fails or stop is closed.
+func (m *Messenger) write(w *writerT, pending string, stop <-chan struct{}, failed chan<- string) {
+	burst, pace := float64(m.opts.Burst), m.opts.Pace
+	tokens, last := burst, time.Now()
+
+	for {
+		line := pending
+		if line == "" {
+			select {
+			case line = <-m.out:
+			case <-stop:
+				failed <- ""
+				return
+			}
+		}
+
+		now := time.Now()
+		tokens, last = math.Min(burst, tokens+float64(now.Sub(last))/float64(pace)), now
+
+		// The connection may have failed while waiting for the line.
+		select {
+		case <-stop:
+			failed <- line
+			return
+		default:
+		}
+
+		if tokens < 1 {
+			select {
+			case <-time.After(time.Duration((1 - tokens) * float64(pace))):
+			case <-stop:
+				failed <- line
+				return
+			}
+
+			tokens, last = 1, time.Now()
+		}
+
+		if err := w.write(line); err != nil {
+			failed <- line
+			return
+		}
+
+		pending = ""
+		tokens--
+	}
+}
+
+// command extracts a command from a message highlighting the bot in a channel,
+// e.g. "twiddler: list".
+func (m *Messenger) command(target, text string) (string, bool) {
+	if !channelRegex.MatchString(target) {
+		return "", false
+	}
+
+	m.mu.Lock()
+	nick := m.nick
+	m.mu.Unlock()
+
+	if len(text) <= len(nick) || !strings.EqualFold(text[:len(nick)], nick) {
+		return "", false
+	}
+
+	rest := text[len(nick):]
+	if !strings.HasPrefix(rest, ":") && !strings.HasPrefix(rest, ",") && !strings.HasPrefix(rest, " ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(rest[1:]), true
+}
+
+// writerT serialises writes of lines to the connection.
+type writerT struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *writerT) write(line string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := w.conn.Write([]byte(line + "\r\n"))
+
+	return err
+}
+
+// messageT is a message received from the server.
+type messageT struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+func parseMessage(line string) messageT {
+	var msg messageT
+
+	if strings.HasPrefix(line, ":") {
+		msg.prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		msg.command, msg.params = strings.ToUpper(fields[0]), fields[1:]
+	}
+
+	if hasTrailing {
+		msg.params = append(msg.params, trailing)
+	}
+
+	return msg
+}
+
+// trailing yields the last parameter of a message.
+func (msg messageT) trailing() string {
+	if len(msg.params) == 0 {
+		return ""
+	}
+
+	return msg.params[len(msg.params)-1]
+}
@@ -0,0 +1,298 @@
+package irc_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/irc"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestRegistersWithSASLOverTLS(t *testing.T) {
+	c := testutil.SetupDB()
+	db.FromContext(c).MustExec(`INSERT INTO [rooms] ([room_id]) VALUES ('#go'), ('123')`)
+
+	server := newFakeIRC(t, true)
+	m := server.messenger(t, c, irc.Options{
+		SASLUser:     "bot",
+		SASLPassword: "secret",
+		Channels:     []string{"#Extra"},
+	})
+	defer m.Close()
+
+	server.expectLines(t,
+		"CAP REQ :sasl",
+		"NICK twiddler",
+		"USER twiddler 0 * :twiddler",
+		"AUTHENTICATE PLAIN",
+		"AUTHENTICATE "+base64.StdEncoding.EncodeToString([]byte("bot\x00bot\x00secret")),
+		"CAP END",
+		"JOIN #extra,#go",
+	)
+}
+
+func TestHighlightRoutesCommands(t *testing.T) {
+	c, cancel := context.WithCancel(testutil.SetupDB())
+	defer cancel()
+
+	server := newFakeIRC(t, false)
+
+	h := testutil.NewHandler()
+	m := server.messengerWithHandler(t, c, h, irc.Options{Channels: []string{"#go"}})
+	defer m.Close()
+
+	server.expectLines(t, "NICK twiddler", "USER twiddler 0 * :twiddler", "JOIN #go")
+
+	server.send(t, "PING :server")
+	server.expectLines(t, "PONG :server")
+
+	server.send(t, ":fan!f@host PRIVMSG #go :twiddlers rock")
+	server.send(t, ":fan!f@host PRIVMSG twiddler :twiddler: spam #go")
+	server.send(t, ":fan!f@host PRIVMSG #Go :Twiddler: list")
+
+	select {
+	case handled := <-h.Handled:
+		if handled != "#go: list" {
+			t.Errorf("Expected %q handled, got %q", "#go: list", handled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the command")
+	}
+
+	select {
+	case handled := <-h.Handled:
+		t.Errorf("Unexpected command %q", handled)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReconnectRejoins(t *testing.T) {
+	c := testutil.SetupDB()
+	server := newFakeIRC(t, false)
+	m := server.messenger(t, c, irc.Options{Channels: []string{"#go"}})
+	defer m.Close()
+
+	server.expectLines(t, "NICK twiddler", "USER twiddler 0 * :twiddler", "JOIN #go")
+
+	server.disconnect()
+	server.expectLines(t, "NICK twiddler", "USER twiddler 0 * :twiddler", "JOIN #go")
+
+	if err := m.MessageText(c, "#go", "Back"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	server.expectLines(t, "PRIVMSG #go :Back")
+}
+
+func TestPacesMessages(t *testing.T) {
+	c := testutil.SetupDB()
+	server := newFakeIRC(t, false)
+	m := server.messenger(t, c, irc.Options{Channels: []string{"#go"}, Burst: 2, Pace: 100 * time.Millisecond})
+	defer m.Close()
+
+	server.expectLines(t, "NICK twiddler", "USER twiddler 0 * :twiddler", "JOIN #go")
+
+	if err := m.MessageText(c, "#go", "one\ntwo\nthree\nfour"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	var times []time.Time
+	for _, text := range []string{"one", "two", "three", "four"} {
+		times = append(times, server.expectLines(t, "PRIVMSG #go :"+text))
+	}
+
+	if d := times[1].Sub(times[0]); d > 50*time.Millisecond {
+		t.Errorf("Expected the burst sent at once, got %s between", d)
+	}
+
+	if d := times[2].Sub(times[0]); d < 80*time.Millisecond {
+		t.Errorf("Expected a pause after the burst, got %s", d)
+	}
+
+	if d := times[3].Sub(times[2]); d < 80*time.Millisecond {
+		t.Errorf("Expected messages paced after the burst, got %s between", d)
+	}
+}
+
+func TestMessageStreamSendsSingleLine(t *testing.T) {
+	c := testutil.SetupDB()
+	server := newFakeIRC(t, false)
+	m := server.messenger(t, c, irc.Options{})
+	defer m.Close()
+
+	server.expectLines(t, "NICK twiddler", "USER twiddler 0 * :twiddler")
+
+	if _, err := m.MessageStream(c, "#go", testutil.NewStream("Go\r\nQUIT :bye")); err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	server.expectLines(t,
+		"JOIN #go",
+		"PRIVMSG #go :\x0303[LIVE]\x0f \x02Go_Sensei (sensei) Went Live!\x02"+
+			" — Go QUIT :bye <https://www.twitch.tv/sensei> · Go")
+}
+
+//
+// HELPERS
+//
+
+type receivedT struct {
+	line string
+	at   time.Time
+}
+
+type fakeIRC struct {
+	ln        net.Listener
+	tlsConfig *tls.Config
+	sasl      bool
+	lines     chan receivedT
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newFakeIRC starts a server that registers clients, with SASL authentication
+// and over TLS if sasl is set.
+func newFakeIRC(t *testing.T, sasl bool) *fakeIRC {
+	t.Helper()
+
+	s := &fakeIRC{sasl: sasl, lines: make(chan receivedT, 1000)}
+
+	var err error
+	if sasl {
+		// The certificate of a TLS test server is valid for 127.0.0.1.
+		certs := httptest.NewTLSServer(http.NotFoundHandler())
+		t.Cleanup(certs.Close)
+
+		s.tlsConfig = certs.Client().Transport.(*http.Transport).TLSClientConfig
+		s.ln, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certs.TLS.Certificates})
+	} else {
+		s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { s.ln.Close() })
+
+	go s.accept()
+
+	return s
+}
+
+func (s *fakeIRC) messenger(t *testing.T, c context.Context, opts irc.Options) *irc.Messenger {
+	return s.messengerWithHandler(t, c, testutil.NewHandler(), opts)
+}
+
+func (s *fakeIRC) messengerWithHandler(t *testing.T, c context.Context, h messenger.Handler, opts irc.Options) *irc.Messenger {
+	t.Helper()
+
+	opts.Addr = s.ln.Addr().String()
+	opts.Nick = "twiddler"
+	opts.Reconnect = 10 * time.Millisecond
+	opts.TLS = s.sasl
+	opts.TLSConfig = s.tlsConfig
+
+	m, err := irc.NewMessenger(opts)
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	m.AddCommandHandler(c, h)
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+
+	return m
+}
+
+func (s *fakeIRC) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conn = conn
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *fakeIRC) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		s.lines <- receivedT{line: line, at: time.Now()}
+
+		switch {
+		case line == "CAP REQ :sasl":
+			fmt.Fprintf(conn, ":server CAP * ACK :sasl\r\n")
+		case line == "AUTHENTICATE PLAIN":
+			fmt.Fprintf(conn, "AUTHENTICATE +\r\n")
+		case strings.HasPrefix(line, "AUTHENTICATE "):
+			fmt.Fprintf(conn, ":server 903 twiddler :SASL authentication successful\r\n")
+		case line == "CAP END", strings.HasPrefix(line, "USER ") && !s.sasl:
+			fmt.Fprintf(conn, ":server 001 twiddler :Welcome\r\n")
+		}
+	}
+}
+
+func (s *fakeIRC) send(t *testing.T, line string) {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := fmt.Fprintf(s.conn, "%s\r\n", line); err != nil {
+		t.Fatalf("Failed to send %q: %s", line, err)
+	}
+}
+
+func (s *fakeIRC) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.Close()
+}
+
+// expectLines expects lines received in order, returning the time the last one was received.
+func (s *fakeIRC) expectLines(t *testing.T, lines ...string) time.Time {
+	t.Helper()
+
+	var at time.Time
+	for _, expected := range lines {
+		select {
+		case r := <-s.lines:
+			if r.line != expected {
+				t.Fatalf("Expected %q, got %q", expected, r.line)
+			}
+			at = r.at
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+
+	return at
+}
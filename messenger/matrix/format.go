@@ -0,0 +1,207 @@
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+const htmlFormat = "org.matrix.custom.html"
+
+// contentT is a content of an m.room.message event.
+type contentT struct {
+	MsgType       string     `json:"msgtype"`
+	Body          string     `json:"body"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	Mentions      *mentionsT `json:"m.mentions,omitempty"`
+	NewContent    *contentT  `json:"m.new_content,omitempty"`
+	RelatesTo     *relationT `json:"m.relates_to,omitempty"`
+}
+
+// mentionsT lists who a message intentionally mentions. Messages of the bot always
+// carry it, so that text controlled by streamers, e.g. titles, can never ping anyone.
+type mentionsT struct {
+	UserIDs []string `json:"user_ids,omitempty"`
+	Room    bool     `json:"room,omitempty"`
+}
+
+type relationT struct {
+	RelType string `json:"rel_type"`
+	EventID string `json:"event_id"`
+}
+
+// replacement wraps content into an edit of the message with eventID.
+func replacement(eventID string, content contentT) contentT {
+	newContent := content
+	newContent.Mentions = &mentionsT{}
+
+	edit := content
+	edit.Body = "* " + content.Body
+	edit.FormattedBody = "* " + content.FormattedBody
+	edit.Mentions = &mentionsT{}
+	edit.NewContent = &newContent
+	edit.RelatesTo = &relationT{RelType: "m.replace", EventID: eventID}
+
+	return edit
+}
+
+// liveContent formats an announcement of a stream going live, mentioning a user
+// or the whole room if mention is set.
+func liveContent(set *templates.Set, s *stream.Stream, mention, thumbnail string) contentT {
+	data := templates.NewStream(s, clock.NowUTC())
+
+	title := set.RenderOrDefault(templates.Title, data)
+	description := set.RenderOrDefault(templates.Description, data)
+	footer := set.RenderOrDefault(templates.Footer, data)
+	content := set.RenderOrDefault(templates.Content, data)
+
+	mentions := &mentionsT{}
+	var body, formatted []string
+
+	if pill := pillOf(mention, mentions); pill != "" || content != "" {
+		body = append(body, strings.TrimSpace(mention+" "+plain(content)))
+		formatted = append(formatted, fmt.Sprintf("<p>%s</p>", strings.TrimSpace(pill+" "+toHTML(content))))
+	}
+
+	body = append(body, plain(title), plain(description))
+	formatted = append(formatted,
+		fmt.Sprintf("<h3>%s</h3>", toHTML(title)),
+		fmt.Sprintf("<p>%s</p>", toHTML(description)))
+
+	if s.Category != "" {
+		body = append(body, s.Category+" · "+serviceName(s))
+		formatted = append(formatted, fmt.Sprintf("<p>%s · %s</p>", html.EscapeString(s.Category), serviceName(s)))
+	}
+
+	if thumbnail != "" {
+		formatted = append(formatted, fmt.Sprintf(`<img src="%s" alt="%s" width="640" height="360">`,
+			html.EscapeString(thumbnail), html.EscapeString(s.Title)))
+	}
+
+	started := s.StartedAt.UTC().Format("2006-01-02 15:04 MST")
+	body = append(body, plain(footer)+" "+started)
+	formatted = append(formatted, fmt.Sprintf("<p><em>%s %s</em></p>", toHTML(footer), started))
+
+	return contentT{
+		MsgType:       "m.text",
+		Body:          strings.Join(body, "\n"),
+		Format:        htmlFormat,
+		FormattedBody: strings.Join(formatted, ""),
+		Mentions:      mentions,
+	}
+}
+
+// endedContent formats a summary of a stream that went offline.
+func endedContent(s *stream.Stream, endedAt time.Time) contentT {
+	duration := messenger.FormatDuration(endedAt.Sub(s.StartedAt))
+
+	return contentT{
+		MsgType: "m.notice",
+		Body: fmt.Sprintf("%s Was Live\n%s (%s)\nOffline — streamed for %s",
+			displayName(s), s.Title, s.User.ChannelURL, duration),
+		Format: htmlFormat,
+		FormattedBody: fmt.Sprintf("<h3>%s Was Live</h3><p>%s</p><p>Offline — streamed for %s</p>",
+			html.EscapeString(displayName(s)), link(s.Title, s.User.ChannelURL.String()), duration),
+		Mentions: &mentionsT{},
+	}
+}
+
+// listContent formats a list of live streams.
+func listContent(title string, ss []stream.Stream) contentT {
+	body := []string{plain(title)}
+	items := make([]string, 0, len(ss))
+
+	for _, s := range ss {
+		line := fmt.Sprintf("%s - %s (%s)", s.User.Name, s.Title, s.User.ChannelURL)
+		item := fmt.Sprintf("<li><b>%s</b> - %s", html.EscapeString(s.User.Name), link(s.Title, s.User.ChannelURL.String()))
+
+		if s.Category != "" {
+			line += " · " + s.Category
+			item += " · " + html.EscapeString(s.Category)
+		}
+
+		body = append(body, line)
+		items = append(items, item+"</li>")
+	}
+
+	return contentT{
+		MsgType:       "m.notice",
+		Body:          strings.Join(body, "\n"),
+		Format:        htmlFormat,
+		FormattedBody: fmt.Sprintf("<h3>%s</h3><ul>%s</ul>", toHTML(title), strings.Join(items, "")),
+		Mentions:      &mentionsT{},
+	}
+}
+
+// pillOf formats a mention of a user or the whole room, adding it to mentions.
+func pillOf(mention string, mentions *mentionsT) string {
+	switch {
+	case mention == "@room":
+		mentions.Room = true
+		return "@room"
+
+	case strings.HasPrefix(mention, "@"):
+		mentions.UserIDs = append(mentions.UserIDs, mention)
+		return fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, html.EscapeString(mention), html.EscapeString(mention))
+	}
+
+	return ""
+}
+
+func serviceName(s *stream.Stream) string {
+	switch s.Service {
+	case "", "twitch":
+		return "Twitch"
+	case "youtube":
+		return "YouTube"
+	}
+
+	return html.EscapeString(s.Service)
+}
+
+// displayName formats a name of the streamer, mentioning the login if it differs
+// from the display name.
+func displayName(s *stream.Stream) string {
+	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
+		return fmt.Sprintf("%s (%s)", s.User.DisplayName, s.User.Name)
+	}
+
+	return s.User.DisplayName
+}
+
+func link(text, url string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+}
+
+var (
+	linkRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	boldRegex = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	codeRegex = regexp.MustCompile("`([^`]+)`")
+	unescaper = strings.NewReplacer(`\_`, "_", `\*`, "*")
+)
+
+// toHTML converts rendered templates and texts from Discord-flavoured Markdown, which
+// templates and commands use, to HTML.
+func toHTML(s string) string {
+	s = html.EscapeString(unescaper.Replace(s))
+	s = linkRegex.ReplaceAllString(s, `<a href="$2">$1</a>`)
+	s = boldRegex.ReplaceAllString(s, "<b>$1</b>")
+	s = codeRegex.ReplaceAllString(s, "<code>$1</code>")
+
+	return strings.Replace(s, "\n", "<br>", -1)
+}
+
+// plain converts rendered templates and texts from Markdown to plain text.
+func plain(s string) string {
+	s = linkRegex.ReplaceAllString(unescaper.Replace(s), "$1 ($2)")
+
+	return boldRegex.ReplaceAllString(s, "$1")
+}
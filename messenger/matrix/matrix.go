@@ -0,0 +1,409 @@
+// Package matrix implements a messenger that announces streams to Matrix rooms with
+// HTML-formatted messages, and receives commands by mentions over /sync long polling.
+//
+// The bot has to be invited to the rooms it announces to, and accepts invites by itself.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// DefaultPollTimeout is how long the homeserver holds /sync requests without new events.
+const DefaultPollTimeout = 30 * time.Second
+
+// thumbnailRefresh is how long an uploaded thumbnail is reused by the announcement of
+// a stream and its edits, which caps uploads at one per stream in the period.
+const thumbnailRefresh = 10 * time.Minute
+
+// PlatformName is a name of the Matrix messaging platform.
+const PlatformName = "matrix"
+
+// Options of the Matrix messenger.
+type Options struct {
+	// HomeserverURL is a base URL of the client-server API, e.g. "https://matrix.org".
+	HomeserverURL string
+
+	// AccessToken is an access token of the bot user.
+	AccessToken string
+
+	// UserID is a Matrix ID of the bot user, e.g. "@twiddler:matrix.org".
+	// It's looked up with the access token if empty.
+	UserID string
+
+	// PollTimeout overrides DefaultPollTimeout.
+	PollTimeout time.Duration
+
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+}
+
+var (
+	_ messenger.Messenger    = &Messenger{}
+	_ messenger.RoomResolver = &Messenger{}
+)
+
+// Messenger posts to Matrix rooms, identified by room IDs, e.g. "!abc:matrix.org".
+type Messenger struct {
+	opts Options
+	txn  atomic.Int64
+
+	mu          sync.Mutex
+	handlerC    context.Context
+	handler     messenger.Handler
+	displayName string
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	thumbnailsMu sync.Mutex
+	// thumbnails are uploaded thumbnails by services and IDs of streams.
+	thumbnails map[string]thumbnailT
+}
+
+// thumbnailT is a thumbnail uploaded to the media repository.
+type thumbnailT struct {
+	uri        string
+	uploadedAt time.Time
+}
+
+func NewMessenger(opts Options) (*Messenger, error) {
+	if opts.HomeserverURL == "" || opts.AccessToken == "" {
+		return nil, errors.New("Matrix homeserver URL and access token are required")
+	}
+
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
+	if opts.PollTimeout == 0 {
+		opts.PollTimeout = DefaultPollTimeout
+	}
+
+	opts.HomeserverURL = strings.TrimSuffix(opts.HomeserverURL, "/")
+
+	return &Messenger{opts: opts, thumbnails: make(map[string]thumbnailT)}, nil
+}
+
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	return m.send(c, roomID, m.liveContent(c, roomID, s, mention))
+}
+
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	_, err := m.send(c, roomID, replacement(messageID, m.liveContent(c, roomID, s, "")))
+
+	return err
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	content := endedContent(s, endedAt)
+	m.forgetThumbnail(s)
+
+	edit := func() error {
+		_, err := m.send(c, roomID, replacement(messageID, content))
+
+		return err
+	}
+
+	return messenger.EditOrPost(roomID, messageID, edit, func() error {
+		_, err := m.send(c, roomID, content)
+
+		return err
+	})
+}
+
+func (m *Messenger) MessageStreamList(c context.Context, roomID string, ss []stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(ss, clock.NowUTC()))
+	_, err := m.send(c, roomID, listContent(title, ss))
+
+	return err
+}
+
+func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
+	_, err := m.send(c, roomID, contentT{
+		MsgType:       "m.notice",
+		Body:          plain(text),
+		Format:        htmlFormat,
+		FormattedBody: toHTML(text),
+		Mentions:      &mentionsT{},
+	})
+
+	return err
+}
+
+// ResolveRoom resolves room aliases, e.g. "#go:matrix.org", into room IDs.
+// Room IDs, e.g. "!abc:matrix.org", are taken as is.
+func (m *Messenger) ResolveRoom(c context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "!") && strings.Contains(ref, ":"):
+		return ref, nil
+
+	case strings.HasPrefix(ref, "#") && strings.Contains(ref, ":"):
+		var resp struct {
+			RoomID string `json:"room_id"`
+		}
+
+		if err := m.do(c, "GET", "/_matrix/client/v3/directory/room/"+url.PathEscape(ref), nil, &resp); err != nil {
+			return "", fmt.Errorf("Failed to resolve room alias %s: %w", ref, err)
+		}
+
+		return resp.RoomID, nil
+	}
+
+	return "", errors.New("Improper room format, expected a room ID like `!abc:matrix.org` or an alias like `#go:matrix.org`")
+}
+
+// FormatRoom refers to rooms by their IDs, as rooms may have no aliases.
+func (m *Messenger) FormatRoom(roomID string) string {
+	return roomID
+}
+
+func (m *Messenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlerC, m.handler = c, h
+}
+
+// Run looks up the bot user and starts syncing, which accepts invites and receives
+// mentions for the command handler, if any.
+func (m *Messenger) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.handlerC
+	if c == nil {
+		c = context.Background()
+	}
+
+	if m.opts.UserID == "" {
+		var resp struct {
+			UserID string `json:"user_id"`
+		}
+
+		if err := m.do(c, "GET", "/_matrix/client/v3/account/whoami", nil, &resp); err != nil {
+			return fmt.Errorf("failed to look up the Matrix bot user: %w", err)
+		}
+
+		m.opts.UserID = resp.UserID
+	}
+
+	var profile struct {
+		DisplayName string `json:"displayname"`
+	}
+
+	path := "/_matrix/client/v3/profile/" + url.PathEscape(m.opts.UserID) + "/displayname"
+	if err := m.do(c, "GET", path, nil, &profile); err == nil {
+		m.displayName = profile.DisplayName
+	}
+
+	c, cancel := context.WithCancel(c)
+	m.cancel, m.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		m.listen(c, m.handler)
+	}()
+
+	return nil
+}
+
+func (m *Messenger) Close() error {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel = nil
+	m.mu.Unlock()
+
+	// The lock is released while waiting, as syncing takes it to look up the bot names.
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	return nil
+}
+
+// liveContent formats an announcement of a stream going live.
+func (m *Messenger) liveContent(c context.Context, roomID string, s *stream.Stream, mention string) contentT {
+	return liveContent(templates.ForRoomOrDefault(c, roomID, m.opts.Templates), s, mention, m.thumbnail(c, s))
+}
+
+// thumbnail yields an mxc:// URI of the thumbnail of a stream. It's uploaded once per
+// thumbnailRefresh, so that edits of announcements refresh it every now and then.
+func (m *Messenger) thumbnail(c context.Context, s *stream.Stream) string {
+	if s.ThumbnailURL == nil || s.ThumbnailURL.String() == "" {
+		return ""
+	}
+
+	key := s.Service + "/" + s.ID
+	now := clock.NowUTC()
+
+	m.thumbnailsMu.Lock()
+	previous, exists := m.thumbnails[key]
+	m.thumbnailsMu.Unlock()
+
+	if exists && now.Sub(previous.uploadedAt) < thumbnailRefresh {
+		return previous.uri
+	}
+
+	uri, err := m.upload(c, s.ThumbnailURL.String())
+	if err != nil {
+		log.Printf("Failed to upload thumbnail of stream %s: %s", s.ID, err)
+		return previous.uri
+	}
+
+	m.thumbnailsMu.Lock()
+	defer m.thumbnailsMu.Unlock()
+
+	// Thumbnails of streams that went offline unnoticed are dropped eventually.
+	for k, t := range m.thumbnails {
+		if now.Sub(t.uploadedAt) >= thumbnailRefresh {
+			delete(m.thumbnails, k)
+		}
+	}
+
+	m.thumbnails[key] = thumbnailT{uri: uri, uploadedAt: now}
+
+	return uri
+}
+
+// forgetThumbnail drops the uploaded thumbnail of a stream that went offline.
+func (m *Messenger) forgetThumbnail(s *stream.Stream) {
+	m.thumbnailsMu.Lock()
+	defer m.thumbnailsMu.Unlock()
+
+	delete(m.thumbnails, s.Service+"/"+s.ID)
+}
+
+// send sends a message event into a room, returning its event ID.
+func (m *Messenger) send(c context.Context, roomID string, content contentT) (string, error) {
+	txnID := fmt.Sprintf("twiddler-%d-%d", time.Now().UnixNano(), m.txn.Add(1))
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), txnID)
+
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+
+	if err := m.do(c, "PUT", path, content, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.EventID, nil
+}
+
+// upload downloads an image and uploads it to the media repository, returning its mxc:// URI.
+func (m *Messenger) upload(c context.Context, imageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(c, "GET", imageURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	image, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer image.Body.Close()
+
+	if image.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image, server replied with status %s", image.Status)
+	}
+
+	req, err = http.NewRequestWithContext(c, "POST", m.opts.HomeserverURL+"/_matrix/media/v3/upload?filename=thumbnail.jpg", image.Body)
+	if err != nil {
+		return "", err
+	}
+
+	contentType := image.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "image/jpeg"
+	}
+	req.Header.Add("Content-Type", contentType)
+
+	var resp struct {
+		ContentURI string `json:"content_uri"`
+	}
+
+	if err := m.roundTrip(req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.ContentURI, nil
+}
+
+// do invokes a client-server API endpoint with a JSON body, decoding the response into resp.
+func (m *Messenger) do(c context.Context, method, path string, body, resp any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(c, method, m.opts.HomeserverURL+path, r)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	return m.roundTrip(req, resp)
+}
+
+func (m *Messenger) roundTrip(req *http.Request, resp any) error {
+	req.Header.Add("Authorization", "Bearer "+m.opts.AccessToken)
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		var e struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		json.NewDecoder(r.Body).Decode(&e)
+
+		return fmt.Errorf("homeserver replied with status %s: %s %s", r.Status, e.ErrCode, e.Error)
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	return json.NewDecoder(r.Body).Decode(resp)
+}
@@ -0,0 +1,445 @@
+package matrix_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/commands"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger/matrix"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestMessageStreamUploadsThumbnail(t *testing.T) {
+	c := testutil.SetupDB()
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+
+	if err := db.MentionStore(c, db.Mention{RoomID: "!room:fake", Mention: "@fan:fake"}); err != nil {
+		t.Fatalf("Failed to store mention: %s", err)
+	}
+
+	eventID, err := m.MessageStream(c, "!room:fake", hs.stream("Learning <Go>"))
+	if err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	if eventID != "$event1" {
+		t.Errorf("Expected event ID %q, got %q", "$event1", eventID)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if len(hs.uploads) != 1 || hs.uploads[0] != "thumbnail" {
+		t.Errorf("Expected the thumbnail uploaded, got %q", hs.uploads)
+	}
+
+	sent := hs.sent["!room:fake"]
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(sent))
+	}
+
+	for _, expected := range []string{
+		`<a href="https://matrix.to/#/@fan:fake">@fan:fake</a>`,
+		`<h3>Go_Sensei (sensei) Went Live!</h3>`,
+		`<a href="https://www.twitch.tv/sensei">Learning &lt;Go&gt;</a>`,
+		`<img src="mxc://fake/thumbnail1"`,
+	} {
+		if !strings.Contains(sent[0].FormattedBody, expected) {
+			t.Errorf("Expected %q in %q", expected, sent[0].FormattedBody)
+		}
+	}
+
+	if ids := sent[0].Mentions.UserIDs; len(ids) != 1 || ids[0] != "@fan:fake" {
+		t.Errorf("Expected @fan:fake mentioned, got %q", ids)
+	}
+}
+
+func TestUpdateStreamReplacesMessage(t *testing.T) {
+	c := testutil.SetupDB()
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+
+	if err := m.UpdateStream(c, "!room:fake", "$event1", hs.stream("Renamed")); err != nil {
+		t.Fatalf("UpdateStream failed: %s", err)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	sent := hs.sent["!room:fake"]
+	if len(sent) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(sent))
+	}
+
+	if r := sent[0].RelatesTo; r == nil || r.RelType != "m.replace" || r.EventID != "$event1" {
+		t.Errorf("Expected a replacement of $event1, got %+v", r)
+	}
+
+	if sent[0].NewContent == nil || !strings.Contains(sent[0].NewContent.Body, "Renamed") {
+		t.Errorf("Expected new content with the new title, got %+v", sent[0].NewContent)
+	}
+}
+
+func TestThumbnailIsReusedUntilRefresh(t *testing.T) {
+	c := testutil.SetupDB()
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	defer clock.OverrideClock(nil)
+
+	s := hs.stream("Title")
+	if _, err := m.MessageStream(c, "!room:fake", s); err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := m.UpdateStream(c, "!room:fake", "$event1", s); err != nil {
+			t.Fatalf("UpdateStream failed: %s", err)
+		}
+	}
+
+	hs.expectUploads(t, 1)
+
+	fixedClock.Add(10 * time.Minute)
+	if err := m.UpdateStream(c, "!room:fake", "$event1", s); err != nil {
+		t.Fatalf("UpdateStream failed: %s", err)
+	}
+
+	hs.expectUploads(t, 2)
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	sent := hs.sent["!room:fake"]
+	if last := sent[len(sent)-1]; !strings.Contains(last.NewContent.FormattedBody, `<img src="mxc://fake/thumbnail2"`) {
+		t.Errorf("Expected the refreshed thumbnail, got %q", last.NewContent.FormattedBody)
+	}
+}
+
+func TestResolveRoom(t *testing.T) {
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+
+	for ref, expected := range map[string]string{
+		"!abc:fake": "!abc:fake",
+		"#go:fake":  "!go:fake",
+	} {
+		roomID, err := m.ResolveRoom(context.Background(), ref)
+		if err != nil {
+			t.Errorf("Failed to resolve %q: %s", ref, err)
+		}
+
+		if roomID != expected {
+			t.Errorf("Expected %q resolved to %q, got %q", ref, expected, roomID)
+		}
+	}
+
+	for _, ref := range []string{"#missing:fake", "<#123>", "go"} {
+		if _, err := m.ResolveRoom(context.Background(), ref); err == nil {
+			t.Errorf("Expected %q to fail resolving", ref)
+		}
+	}
+}
+
+func TestSpamAndForgetResolveAliases(t *testing.T) {
+	c := testutil.SetupDB()
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+	h := commands.NewHandler(nil, commands.Options{})
+
+	if err := h.Handle(c, "!room:fake", "spam #go:fake", m); err != nil {
+		t.Fatalf("Failed to handle spam: %s", err)
+	}
+
+	expectRooms(t, c, "!go:fake")
+
+	if err := h.Handle(c, "!room:fake", "forget #go:fake", m); err != nil {
+		t.Fatalf("Failed to handle forget: %s", err)
+	}
+
+	expectRooms(t, c)
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	for _, reply := range hs.sent["!room:fake"] {
+		if strings.Contains(reply.Body, "<#") || !strings.Contains(reply.Body, "!go:fake") {
+			t.Errorf("Expected replies to refer to the room by its ID, got %q", reply.Body)
+		}
+	}
+}
+
+func TestSyncRoutesCommandsAndAcceptsInvites(t *testing.T) {
+	c, cancel := context.WithCancel(testutil.SetupDB())
+	defer cancel()
+
+	hs := newFakeHomeserver(t)
+	m := hs.messenger(t)
+
+	h := testutil.NewHandler()
+	m.AddCommandHandler(c, h)
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	defer m.Close()
+
+	hs.syncs <- syncResponse(map[string][]map[string]any{
+		"!room:fake": {
+			message("@fan:fake", "Twiddler: list"),
+			message("@fan:fake", "twiddlers are great"),
+			message("@twiddler:fake", "twiddler: spam"),
+			message("@fan:fake", "@twiddler:fake settings !room:fake"),
+		},
+	})
+
+	for _, expected := range []string{"!room:fake: list", "!room:fake: settings !room:fake"} {
+		select {
+		case handled := <-h.Handled:
+			if handled != expected {
+				t.Errorf("Expected %q handled, got %q", expected, handled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+
+	select {
+	case handled := <-h.Handled:
+		t.Errorf("Unexpected command %q", handled)
+	default:
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if len(hs.joined) != 1 || hs.joined[0] != "!invited:fake" {
+		t.Errorf("Expected the invite accepted, got %q", hs.joined)
+	}
+}
+
+//
+// HELPERS
+//
+
+type sentT struct {
+	Body          string `json:"body"`
+	FormattedBody string `json:"formatted_body"`
+	Mentions      struct {
+		UserIDs []string `json:"user_ids"`
+	} `json:"m.mentions"`
+	RelatesTo *struct {
+		RelType string `json:"rel_type"`
+		EventID string `json:"event_id"`
+	} `json:"m.relates_to"`
+	NewContent *sentT `json:"m.new_content"`
+}
+
+type fakeHomeserver struct {
+	*httptest.Server
+
+	syncs chan map[string]any
+
+	mu      sync.Mutex
+	events  int
+	uploads []string
+	sent    map[string][]sentT
+	joined  []string
+}
+
+func newFakeHomeserver(t *testing.T) *fakeHomeserver {
+	t.Helper()
+
+	hs := &fakeHomeserver{
+		syncs: make(chan map[string]any, 10),
+		sent:  make(map[string][]sentT),
+	}
+
+	hs.Server = httptest.NewServer(http.HandlerFunc(hs.serve))
+	t.Cleanup(hs.Close)
+
+	return hs
+}
+
+func (hs *fakeHomeserver) messenger(t *testing.T) *matrix.Messenger {
+	t.Helper()
+
+	m, err := matrix.NewMessenger(matrix.Options{
+		HomeserverURL: hs.URL,
+		AccessToken:   "token",
+		PollTimeout:   100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	return m
+}
+
+func (hs *fakeHomeserver) expectUploads(t *testing.T, n int) {
+	t.Helper()
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if len(hs.uploads) != n {
+		t.Errorf("Expected %d uploads, got %d", n, len(hs.uploads))
+	}
+}
+
+func (hs *fakeHomeserver) stream(title string) *stream.Stream {
+	s := testutil.NewStream(title)
+	s.ThumbnailURL, _ = url.Parse(hs.URL + "/thumbnail.jpg")
+
+	return s
+}
+
+func (hs *fakeHomeserver) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/thumbnail.jpg" && r.Header.Get("Authorization") != "Bearer token" {
+		writeError(w, http.StatusUnauthorized, "M_MISSING_TOKEN")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+
+	switch {
+	case r.URL.Path == "/thumbnail.jpg":
+		w.Header().Set("Content-Type", "image/jpeg")
+		io.WriteString(w, "thumbnail")
+
+	case r.URL.Path == "/_matrix/media/v3/upload":
+		data, _ := io.ReadAll(r.Body)
+
+		hs.mu.Lock()
+		hs.uploads = append(hs.uploads, string(data))
+		uri := fmt.Sprintf("mxc://fake/thumbnail%d", len(hs.uploads))
+		hs.mu.Unlock()
+
+		writeJSON(w, map[string]string{"content_uri": uri})
+
+	case path == "/account/whoami":
+		writeJSON(w, map[string]string{"user_id": "@twiddler:fake"})
+
+	case strings.HasPrefix(path, "/profile/"):
+		writeJSON(w, map[string]string{"displayname": "Twiddler"})
+
+	case strings.HasPrefix(path, "/directory/room/"):
+		if alias, _ := url.PathUnescape(strings.TrimPrefix(path, "/directory/room/")); alias == "#go:fake" {
+			writeJSON(w, map[string]string{"room_id": "!go:fake"})
+			return
+		}
+
+		writeError(w, http.StatusNotFound, "M_NOT_FOUND")
+
+	case path == "/sync":
+		hs.sync(w, r)
+
+	case strings.HasPrefix(path, "/rooms/") && strings.HasSuffix(path, "/join"):
+		hs.mu.Lock()
+		hs.joined = append(hs.joined, strings.TrimSuffix(strings.TrimPrefix(path, "/rooms/"), "/join"))
+		hs.mu.Unlock()
+
+		writeJSON(w, map[string]string{})
+
+	case strings.HasPrefix(path, "/rooms/") && strings.Contains(path, "/send/m.room.message/"):
+		roomID := strings.TrimPrefix(path[:strings.Index(path, "/send/")], "/rooms/")
+
+		var content sentT
+		json.NewDecoder(r.Body).Decode(&content)
+
+		hs.mu.Lock()
+		hs.sent[roomID] = append(hs.sent[roomID], content)
+		hs.events++
+		eventID := fmt.Sprintf("$event%d", hs.events)
+		hs.mu.Unlock()
+
+		writeJSON(w, map[string]string{"event_id": eventID})
+
+	default:
+		writeError(w, http.StatusNotFound, "M_UNRECOGNIZED")
+	}
+}
+
+// sync serves history and an invite initially, and then queued responses,
+// holding requests for a while when there are none.
+func (hs *fakeHomeserver) sync(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("since") == "" {
+		s := syncResponse(map[string][]map[string]any{
+			"!room:fake": {message("@fan:fake", "twiddler: spam #old:fake")},
+		})
+		s["rooms"].(map[string]any)["invite"] = map[string]any{"!invited:fake": map[string]any{}}
+
+		writeJSON(w, s)
+		return
+	}
+
+	select {
+	case s := <-hs.syncs:
+		writeJSON(w, s)
+	case <-time.After(100 * time.Millisecond):
+		writeJSON(w, syncResponse(nil))
+	case <-r.Context().Done():
+	}
+}
+
+func syncResponse(rooms map[string][]map[string]any) map[string]any {
+	join := make(map[string]any)
+	for roomID, events := range rooms {
+		join[roomID] = map[string]any{"timeline": map[string]any{"events": events}}
+	}
+
+	return map[string]any{
+		"next_batch": "batch",
+		"rooms":      map[string]any{"join": join},
+	}
+}
+
+func message(sender, body string) map[string]any {
+	return map[string]any{
+		"type":    "m.room.message",
+		"sender":  sender,
+		"content": map[string]string{"msgtype": "m.text", "body": body},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errcode": code, "error": code})
+}
+
+func expectRooms(t *testing.T, c context.Context, ids ...string) {
+	t.Helper()
+
+	rooms, err := db.RoomsAll(c)
+	if err != nil {
+		t.Fatalf("Failed to retrieve rooms: %s", err)
+	}
+
+	if len(rooms) != len(ids) {
+		t.Fatalf("Expected rooms %q, got %+v", ids, rooms)
+	}
+
+	for i := range ids {
+		if rooms[i].ID != ids[i] {
+			t.Errorf("Expected room %q, got %q", ids[i], rooms[i].ID)
+		}
+	}
+}
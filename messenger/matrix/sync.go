@@ -0,0 +1,151 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/messenger"
+)
+
+// maxBackoff limits delays between retries of failed syncs.
+const maxBackoff = time.Minute
+
+// syncT is a response of /sync, limited to what the bot cares about.
+type syncT struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []eventT `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct{} `json:"invite"`
+	} `json:"rooms"`
+}
+
+type eventT struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType   string     `json:"msgtype"`
+		Body      string     `json:"body"`
+		RelatesTo *relationT `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+// listen syncs until c is cancelled, accepting invites and passing commands
+// to h, if any. Events that happened before the bot started are skipped.
+func (m *Messenger) listen(c context.Context, h messenger.Handler) {
+	since := ""
+	backoff := time.Second
+
+	for {
+		// The initial sync returns immediately, and its timeline is history.
+		timeout := m.opts.PollTimeout
+		if since == "" {
+			timeout = 0
+		}
+
+		s, err := m.sync(c, since, timeout)
+		if c.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Matrix sync failed: %s", err)
+
+			select {
+			case <-time.After(backoff):
+			case <-c.Done():
+				return
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			continue
+		}
+
+		backoff = time.Second
+
+		for roomID := range s.Rooms.Invite {
+			if err := m.do(c, "POST", "/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+"/join", struct{}{}, nil); err != nil {
+				log.Printf("Failed to accept invite to Matrix room %s: %s", roomID, err)
+			}
+		}
+
+		if since != "" && h != nil {
+			for roomID, room := range s.Rooms.Join {
+				for _, e := range room.Timeline.Events {
+					if command, ok := m.command(e); ok {
+						if err := h.Handle(c, roomID, command, m); err != nil {
+							log.Printf("Failed to handle Matrix command in %s: %s", roomID, err)
+						}
+					}
+				}
+			}
+		}
+
+		since = s.NextBatch
+	}
+}
+
+func (m *Messenger) sync(c context.Context, since string, timeout time.Duration) (*syncT, error) {
+	query := url.Values{"timeout": {fmt.Sprint(timeout.Milliseconds())}}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	// The request is held by the homeserver for up to timeout.
+	c, cancel := context.WithTimeout(c, timeout+30*time.Second)
+	defer cancel()
+
+	var s syncT
+	if err := m.do(c, "GET", "/_matrix/client/v3/sync?"+query.Encode(), nil, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// command extracts a command from a message addressing the bot by its user ID, localpart
+// or display name, e.g. "twiddler: list". Edits and messages of the bot are ignored.
+func (m *Messenger) command(e eventT) (string, bool) {
+	if e.Type != "m.room.message" || e.Content.MsgType != "m.text" || e.Sender == m.opts.UserID {
+		return "", false
+	}
+
+	if e.Content.RelatesTo != nil && e.Content.RelatesTo.RelType == "m.replace" {
+		return "", false
+	}
+
+	m.mu.Lock()
+	names := []string{m.opts.UserID, localpart(m.opts.UserID), m.displayName}
+	m.mu.Unlock()
+
+	body := strings.TrimSpace(e.Content.Body)
+	for _, name := range names {
+		if name == "" || len(body) <= len(name) || !strings.EqualFold(body[:len(name)], name) {
+			continue
+		}
+
+		rest := strings.TrimLeft(body[len(name):], ":,")
+		if rest != body[len(name):] || strings.HasPrefix(rest, " ") {
+			return strings.TrimSpace(rest), true
+		}
+	}
+
+	return "", false
+}
+
+// localpart yields the name of a user without the server, e.g. "twiddler" of "@twiddler:matrix.org".
+func localpart(userID string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(userID, "@"), ":")
+
+	return name
+}
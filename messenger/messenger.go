@@ -2,6 +2,9 @@ package messenger
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"time"
 
 	"github.com/TeamTenuki/twiddler/stream"
 )
@@ -11,7 +14,18 @@ import (
 type Messenger interface {
 	// MessageStream knows how to format and send a message
 	// containing information about a single stream.
-	MessageStream(c context.Context, roomID string, s *stream.Stream) error
+	// It returns an ID of the sent message, if the messenger has such a notion.
+	MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error)
+
+	// UpdateStream knows how to edit the message with messageID (as returned by MessageStream)
+	// into an up-to-date information about a single stream, e.g. when its title changes.
+	UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error
+
+	// MessageStreamEnded knows how to format and send a message summarising
+	// a stream that went offline at endedAt. If messageID is not empty, the message
+	// with this ID (as returned by MessageStream) is edited into the summary instead,
+	// provided the messenger supports editing.
+	MessageStreamEnded(c context.Context, roomID, messageID string, s *stream.Stream, endedAt time.Time) error
 
 	// MessageStreamList knows how to format and send a message
 	// containing information about a list of streams.
@@ -27,6 +41,57 @@ type Messenger interface {
 	Close() error
 }
 
+// Handler handles commands sent to the bot in room sourceID. The message is a command
+// with its arguments, e.g. "list", preceded by a mention of the bot where messengers
+// have a notion of one similar to Discord's "<@id>", and stripped of it otherwise.
 type Handler interface {
 	Handle(c context.Context, sourceID, message string, m Messenger) error
 }
+
+// RoomResolver is implemented by messengers that refer to rooms in commands and replies
+// otherwise than with Discord-like "<#id>" references.
+type RoomResolver interface {
+	// ResolveRoom yields an ID of the room referred to by ref, e.g. an alias.
+	ResolveRoom(c context.Context, ref string) (string, error)
+
+	// FormatRoom formats a reference to a room in texts of the bot.
+	FormatRoom(roomID string) string
+}
+
+// RoomRegistrar is implemented by messengers whose rooms are configured rather than
+// added by commands, e.g. webhooks.
+type RoomRegistrar interface {
+	// RegisterRooms adds the configured rooms, unless they exist. Rooms belong to
+	// the platform of the context, see NewPlatformContext.
+	RegisterRooms(c context.Context) error
+}
+
+// EditOrPost summarises a stream by editing its announcement with messageID, if any, with edit.
+// Should editing fail, e.g. as the announcement was deleted, the summary is posted anew with post.
+func EditOrPost(roomID, messageID string, edit, post func() error) error {
+	if messageID != "" {
+		err := edit()
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Failed to edit message %s in room %s: %s", messageID, roomID, err)
+	}
+
+	return post()
+}
+
+// FormatDuration formats a stream duration for humans, e.g. "2h13m".
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		d = time.Minute
+	}
+
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	if h == 0 {
+		return fmt.Sprintf("%dm", m)
+	}
+
+	return fmt.Sprintf("%dh%02dm", h, m)
+}
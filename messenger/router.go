@@ -0,0 +1,201 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+type contextKey int
+
+const (
+	platformContextKey contextKey = iota
+)
+
+// NewPlatformContext returns a context of a messenger of the given platform, e.g. "slack",
+// which is retrievable with PlatformFromContext. Rooms added in this context belong to it.
+func NewPlatformContext(c context.Context, platform string) context.Context {
+	return context.WithValue(c, platformContextKey, platform)
+}
+
+// PlatformFromContext retrieves a platform put into context by NewPlatformContext,
+// or an empty string if there's none.
+func PlatformFromContext(c context.Context) string {
+	platform, _ := c.Value(platformContextKey).(string)
+
+	return platform
+}
+
+// Backend is a messenger serving the rooms of a platform.
+type Backend struct {
+	Platform  string
+	Messenger Messenger
+}
+
+var (
+	_ Messenger     = &Router{}
+	_ RoomRegistrar = &Router{}
+)
+
+// Router is a messenger that dispatches messages to the backends of platforms the rooms
+// belong to, so that rooms of several messengers are announced to at once.
+type Router struct {
+	backends []Backend
+	// byPlatform indexes backends by their platforms.
+	byPlatform map[string]Messenger
+	// fallback serves rooms without a recorded platform.
+	fallback string
+}
+
+// NewRouter creates a router between backends, where rooms added before platforms were
+// recorded belong to the backend of platform fallback.
+func NewRouter(fallback string, backends ...Backend) (*Router, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("no messengers to route between")
+	}
+
+	byPlatform := make(map[string]Messenger, len(backends))
+	for _, b := range backends {
+		if b.Platform == "" {
+			return nil, errors.New("messenger without a platform")
+		}
+
+		if _, exists := byPlatform[b.Platform]; exists {
+			return nil, fmt.Errorf("several messengers of platform %q", b.Platform)
+		}
+
+		byPlatform[b.Platform] = b.Messenger
+	}
+
+	if _, exists := byPlatform[fallback]; !exists {
+		return nil, fmt.Errorf("no messenger of the default platform %q", fallback)
+	}
+
+	return &Router{backends: backends, byPlatform: byPlatform, fallback: fallback}, nil
+}
+
+func (r *Router) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	m, err := r.backendFor(c, roomID)
+	if err != nil {
+		return "", err
+	}
+
+	return m.MessageStream(c, roomID, s)
+}
+
+func (r *Router) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	m, err := r.backendFor(c, roomID)
+	if err != nil {
+		return err
+	}
+
+	return m.UpdateStream(c, roomID, messageID, s)
+}
+
+func (r *Router) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	m, err := r.backendFor(c, roomID)
+	if err != nil {
+		return err
+	}
+
+	return m.MessageStreamEnded(c, roomID, messageID, s, endedAt)
+}
+
+func (r *Router) MessageStreamList(c context.Context, roomID string, s []stream.Stream) error {
+	m, err := r.backendFor(c, roomID)
+	if err != nil {
+		return err
+	}
+
+	return m.MessageStreamList(c, roomID, s)
+}
+
+func (r *Router) MessageText(c context.Context, roomID, text string) error {
+	m, err := r.backendFor(c, roomID)
+	if err != nil {
+		return err
+	}
+
+	return m.MessageText(c, roomID, text)
+}
+
+// AddCommandHandler adds the handler to every backend, in a context of its platform.
+// Backends pass themselves to the handler, so replies to commands bypass the router.
+func (r *Router) AddCommandHandler(c context.Context, h Handler) {
+	for _, b := range r.backends {
+		b.Messenger.AddCommandHandler(NewPlatformContext(c, b.Platform), h)
+	}
+}
+
+// RegisterRooms registers the configured rooms of the backends that have any,
+// in contexts of their platforms.
+func (r *Router) RegisterRooms(c context.Context) error {
+	for _, b := range r.backends {
+		registrar, ok := b.Messenger.(RoomRegistrar)
+		if !ok {
+			continue
+		}
+
+		if err := registrar.RegisterRooms(NewPlatformContext(c, b.Platform)); err != nil {
+			return fmt.Errorf("failed to register rooms of %s messenger: %w", b.Platform, err)
+		}
+	}
+
+	return nil
+}
+
+// Run runs every backend, closing the ones already running if any of them fails.
+func (r *Router) Run() error {
+	for i, b := range r.backends {
+		if err := b.Messenger.Run(); err != nil {
+			for _, running := range r.backends[:i] {
+				running.Messenger.Close()
+			}
+
+			return fmt.Errorf("failed to run %s messenger: %w", b.Platform, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Router) Close() error {
+	var errs []error
+	for _, b := range r.backends {
+		if err := b.Messenger.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close %s messenger: %w", b.Platform, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// backendFor yields the backend of the platform a room belongs to.
+// Unknown rooms, e.g. the ones commands are sent from, belong to the default platform.
+func (r *Router) backendFor(c context.Context, roomID string) (Messenger, error) {
+	room, _, err := db.RoomFor(c, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve platform of room %s: %w", roomID, err)
+	}
+
+	platform := room.Platform
+	if platform == "" {
+		platform = r.fallback
+	}
+
+	m, exists := r.byPlatform[platform]
+	if !exists {
+		return nil, fmt.Errorf("no messenger of platform %q of room %s", platform, roomID)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,293 @@
+package messenger_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/commands"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestRouterDispatchesByPlatformOfRoom(t *testing.T) {
+	c := testutil.SetupDB()
+	addRoom(c, "D1", "discord")
+	addRoom(c, "S1", "slack")
+	addRoom(c, "T1", "telegram")
+
+	discord, slack, telegram := testutil.NewMessenger(), testutil.NewMessenger(), testutil.NewMessenger()
+	r := newRouter(t, "discord", discord, slack, telegram)
+
+	s := &stream.Stream{ID: "s1", Title: "Playing Go", StartedAt: time.Now()}
+	for _, roomID := range []string{"D1", "S1", "T1"} {
+		if _, err := r.MessageStream(c, roomID, s); err != nil {
+			t.Fatalf("MessageStream to %s failed: %s", roomID, err)
+		}
+
+		if err := r.MessageText(c, roomID, "hello"); err != nil {
+			t.Fatalf("MessageText to %s failed: %s", roomID, err)
+		}
+	}
+
+	expectSent(t, discord, "D1", "S1", "T1")
+	expectSent(t, slack, "S1", "D1", "T1")
+	expectSent(t, telegram, "T1", "D1", "S1")
+}
+
+func TestRouterSendsToDefaultPlatformWithoutRecordedOne(t *testing.T) {
+	c := testutil.SetupDB()
+	addRoom(c, "old", "")
+
+	discord, slack := testutil.NewMessenger(), testutil.NewMessenger()
+	r := newRouter(t, "slack", discord, slack)
+
+	if err := r.MessageText(c, "old", "hello"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	if err := r.MessageText(c, "unknown", "hello"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	expectSent(t, slack, "old", "D1")
+	expectSent(t, slack, "unknown", "D1")
+}
+
+func TestRouterFailsForRoomsOfUnconfiguredPlatforms(t *testing.T) {
+	c := testutil.SetupDB()
+	addRoom(c, "M1", "matrix")
+
+	r := newRouter(t, "discord", testutil.NewMessenger())
+
+	if err := r.MessageText(c, "M1", "hello"); err == nil {
+		t.Errorf("Expected an error for a room of an unconfigured platform")
+	}
+}
+
+func TestNewRouterRejectsDuplicateAndUnknownPlatforms(t *testing.T) {
+	m := testutil.NewMessenger()
+
+	if _, err := messenger.NewRouter("discord",
+		messenger.Backend{Platform: "discord", Messenger: m},
+		messenger.Backend{Platform: "discord", Messenger: m},
+	); err == nil {
+		t.Errorf("Expected an error for duplicate platforms")
+	}
+
+	if _, err := messenger.NewRouter("slack", messenger.Backend{Platform: "discord", Messenger: m}); err == nil {
+		t.Errorf("Expected an error for an unknown default platform")
+	}
+}
+
+func TestRoomsAddedByCommandsBelongToPlatformOfMessenger(t *testing.T) {
+	c := testutil.SetupDB()
+
+	slack := &handlerMessenger{Messenger: testutil.NewMessenger()}
+	r := newRouter(t, "discord", testutil.NewMessenger(), slack)
+	r.AddCommandHandler(c, commands.NewHandler(nil, commands.Options{}))
+
+	if err := slack.command("spam <#C1>"); err != nil {
+		t.Fatalf("Command failed: %s", err)
+	}
+
+	room, exists, err := db.RoomFor(c, "C1")
+	if err != nil || !exists {
+		t.Fatalf("Expected room C1 to be added, got %v, %v", exists, err)
+	}
+
+	if room.Platform != "slack" {
+		t.Errorf("Expected room of platform slack, got %q", room.Platform)
+	}
+}
+
+func TestForgetLeavesRoomsOfOtherPlatforms(t *testing.T) {
+	c := testutil.SetupDB()
+
+	slack := &handlerMessenger{Messenger: testutil.NewMessenger()}
+	r := newRouter(t, "discord", testutil.NewMessenger(), slack)
+	r.AddCommandHandler(c, commands.NewHandler(nil, commands.Options{}))
+
+	addRoom(c, "C1", "discord")
+	addRoom(c, "C2", "slack")
+
+	for _, command := range []string{"forget <#C1>", "forget <#C2>"} {
+		if err := slack.command(command); err != nil {
+			t.Fatalf("Command failed: %s", err)
+		}
+	}
+
+	if _, exists, _ := db.RoomFor(c, "C1"); !exists {
+		t.Errorf("Expected room C1 of another platform to be left")
+	}
+
+	if _, exists, _ := db.RoomFor(c, "C2"); exists {
+		t.Errorf("Expected room C2 to be forgotten")
+	}
+
+	if messages := slack.Room("source").Messages; len(messages) != 2 || !strings.Contains(messages[0], "belongs to discord") {
+		t.Errorf("Unexpected replies %q", messages)
+	}
+}
+
+func TestCommandsLeaveRoomsOfOtherPlatforms(t *testing.T) {
+	c := testutil.SetupDB()
+
+	slack := &handlerMessenger{Messenger: testutil.NewMessenger()}
+	r := newRouter(t, "discord", testutil.NewMessenger(), slack)
+	r.AddCommandHandler(c, commands.NewHandler(nil, commands.Options{}))
+
+	addRoom(c, "C1", "discord")
+
+	refused := []string{
+		"set <#C1> back-online on",
+		"filter add <#C1> include go",
+		"follow <#C1> sensei",
+		"mention set <#C1> <@U1>",
+	}
+
+	for _, command := range refused {
+		if err := slack.command(command); err != nil {
+			t.Fatalf("Command failed: %s", err)
+		}
+	}
+
+	messages := slack.Room("source").Messages
+	if len(messages) != len(refused) {
+		t.Fatalf("Expected a reply per command, got %q", messages)
+	}
+
+	for i, message := range messages {
+		if !strings.Contains(message, "belongs to discord") {
+			t.Errorf("Expected %q to be refused, got %q", refused[i], message)
+		}
+	}
+
+	settings, _ := db.RoomSettingsAll(c, "C1")
+	filters, _ := db.FiltersForRoom(c, "C1")
+	follows, _ := db.FollowsForRoom(c, "C1")
+	mentions, _ := db.MentionsForRoom(c, "C1")
+
+	if len(settings)+len(filters)+len(follows)+len(mentions) != 0 {
+		t.Errorf("Expected room C1 to be left intact, got %v, %v, %v, %v", settings, filters, follows, mentions)
+	}
+}
+
+func TestConfiguredRoomsAreManagedFromAnyPlatform(t *testing.T) {
+	c := testutil.SetupDB()
+
+	slack := &handlerMessenger{Messenger: testutil.NewMessenger()}
+	r := newRouter(t, "discord", testutil.NewMessenger(), slack)
+	r.AddCommandHandler(c, commands.NewHandler(nil, commands.Options{ConfiguredPlatforms: []string{"webhook"}}))
+
+	addRoom(c, "ci", "webhook")
+
+	for _, command := range []string{"filter add <#ci> include go", "forget <#ci>"} {
+		if err := slack.command(command); err != nil {
+			t.Fatalf("Command failed: %s", err)
+		}
+	}
+
+	if filters, _ := db.FiltersForRoom(c, "ci"); len(filters) != 1 {
+		t.Errorf("Expected a filter of room ci, got %v", filters)
+	}
+
+	// The room would be registered again on the next start.
+	if _, exists, _ := db.RoomFor(c, "ci"); !exists {
+		t.Errorf("Expected configured room ci to be left")
+	}
+
+	if messages := slack.Room("source").Messages; len(messages) != 2 || !strings.Contains(messages[1], "configured") {
+		t.Errorf("Unexpected replies %q", messages)
+	}
+}
+
+func TestRouterRegistersRoomsInPlatformsOfBackends(t *testing.T) {
+	c := testutil.SetupDB()
+
+	r := newRouter(t, "discord",
+		testutil.NewMessenger(),
+		&registrarMessenger{Messenger: testutil.NewMessenger(), roomID: "S1"},
+		&registrarMessenger{Messenger: testutil.NewMessenger(), roomID: "T1"},
+	)
+
+	if err := r.RegisterRooms(c); err != nil {
+		t.Fatalf("RegisterRooms failed: %s", err)
+	}
+
+	for roomID, platform := range map[string]string{"S1": "slack", "T1": "telegram"} {
+		if room, _, _ := db.RoomFor(c, roomID); room.Platform != platform {
+			t.Errorf("Expected room %s of platform %s, got %q", roomID, platform, room.Platform)
+		}
+	}
+}
+
+//
+// HELPERS
+//
+
+// registrarMessenger is a messenger with a configured room.
+type registrarMessenger struct {
+	*testutil.Messenger
+
+	roomID string
+}
+
+func (m *registrarMessenger) RegisterRooms(c context.Context) error {
+	addRoom(c, m.roomID, messenger.PlatformFromContext(c))
+	return nil
+}
+
+// handlerMessenger is a messenger that receives commands on demand.
+type handlerMessenger struct {
+	*testutil.Messenger
+
+	c context.Context
+	h messenger.Handler
+}
+
+func (m *handlerMessenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+	m.c, m.h = c, h
+}
+
+func (m *handlerMessenger) command(message string) error {
+	return m.h.Handle(m.c, "source", message, m)
+}
+
+var platforms = []string{"discord", "slack", "telegram"}
+
+func newRouter(t *testing.T, fallback string, ms ...messenger.Messenger) *messenger.Router {
+	backends := make([]messenger.Backend, len(ms))
+	for i, m := range ms {
+		backends[i] = messenger.Backend{Platform: platforms[i], Messenger: m}
+	}
+
+	r, err := messenger.NewRouter(fallback, backends...)
+	if err != nil {
+		t.Fatalf("NewRouter failed: %s", err)
+	}
+
+	return r
+}
+
+// expectSent checks that a messenger was sent messages to room roomID, and none to others.
+func expectSent(t *testing.T, m *testutil.Messenger, roomID string, others ...string) {
+	t.Helper()
+
+	if len(m.Room(roomID).Messages) == 0 {
+		t.Errorf("Expected messages to room %s", roomID)
+	}
+
+	for _, other := range others {
+		if store := m.Room(other); len(store.Messages) != 0 || len(store.Streams) != 0 {
+			t.Errorf("Expected no messages to room %s, got %+v", other, store)
+		}
+	}
+}
+
+func addRoom(c context.Context, roomID, platform string) {
+	db.FromContext(c).MustExecContext(c, `INSERT INTO [rooms] ([room_id], [platform]) VALUES (?, ?)`, roomID, platform)
+}
@@ -0,0 +1,219 @@
+package slack
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// maxHeader is the maximum length of a header block text.
+const maxHeader = 150
+
+// blockT is a Block Kit layout block.
+type blockT struct {
+	Type      string     `json:"type"`
+	Text      *textT     `json:"text,omitempty"`
+	Fields    []textT    `json:"fields,omitempty"`
+	Elements  []elementT `json:"elements,omitempty"`
+	Accessory *elementT  `json:"accessory,omitempty"`
+	ImageURL  string     `json:"image_url,omitempty"`
+	AltText   string     `json:"alt_text,omitempty"`
+}
+
+// textT is a Block Kit text object, either "plain_text" or "mrkdwn".
+type textT struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// elementT is a Block Kit element of context blocks and section accessories,
+// either a text object or an image.
+type elementT struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+func header(text string) blockT {
+	if runes := []rune(text); len(runes) > maxHeader {
+		text = string(runes[:maxHeader-1]) + "…"
+	}
+
+	return blockT{Type: "header", Text: &textT{Type: "plain_text", Text: text}}
+}
+
+func section(text string) blockT {
+	return blockT{Type: "section", Text: &textT{Type: "mrkdwn", Text: text}}
+}
+
+// liveBlocks formats an announcement of a stream going live, preceded by content if any.
+// The thumbnail URL is made unique, so every edit of the announcement refreshes it.
+func liveBlocks(set *templates.Set, s *stream.Stream, content string) []blockT {
+	data := templates.NewStream(s, clock.NowUTC())
+
+	var blocks []blockT
+	if content != "" {
+		blocks = append(blocks, section(content))
+	}
+
+	blocks = append(blocks, header(plain(set.RenderOrDefault(templates.Title, data))))
+
+	description := section(mrkdwn(set.RenderOrDefault(templates.Description, data)))
+	if s.User.PictureURL != nil && s.User.PictureURL.String() != "" {
+		description.Accessory = &elementT{
+			Type:     "image",
+			ImageURL: s.User.PictureURL.String(),
+			AltText:  s.User.DisplayName,
+		}
+	}
+	blocks = append(blocks, description)
+
+	if s.ThumbnailURL != nil && s.ThumbnailURL.String() != "" {
+		blocks = append(blocks, blockT{
+			Type:     "image",
+			ImageURL: fmt.Sprintf("%s?cache_invalidation_token=%d", s.ThumbnailURL, rand.Int()),
+			AltText:  s.Title,
+		})
+	}
+
+	elements := []elementT{{Type: "mrkdwn", Text: serviceName(s)}}
+	if s.Category != "" {
+		elements = append(elements, elementT{Type: "mrkdwn", Text: escape(s.Category)})
+	}
+	elements = append(elements, elementT{
+		Type: "mrkdwn",
+		Text: mrkdwn(set.RenderOrDefault(templates.Footer, data)) + " " + date(s.StartedAt),
+	})
+
+	return append(blocks, blockT{Type: "context", Elements: elements})
+}
+
+// endedBlocks formats a summary of a stream that went offline.
+func endedBlocks(s *stream.Stream, endedAt time.Time) []blockT {
+	return []blockT{
+		header(displayName(s) + " Was Live"),
+		section(fmt.Sprintf("%s\nOffline — streamed for %s",
+			link(s.Title, s.User.ChannelURL.String()), messenger.FormatDuration(endedAt.Sub(s.StartedAt)))),
+		{
+			Type:     "context",
+			Elements: []elementT{{Type: "mrkdwn", Text: serviceName(s) + " · Ended " + date(endedAt)}},
+		},
+	}
+}
+
+// listBlocks formats a list of live streams.
+func listBlocks(title string, ss []stream.Stream) []blockT {
+	blocks := []blockT{header(plain(title))}
+
+	for _, s := range ss {
+		text := fmt.Sprintf("*%s*\n%s", escape(s.User.Name), link(s.Title, s.User.ChannelURL.String()))
+		if s.Category != "" {
+			text += " · " + escape(s.Category)
+		}
+
+		blocks = append(blocks, section(text))
+	}
+
+	return blocks
+}
+
+// fallbackText is a plain text of an announcement shown in notifications.
+func fallbackText(content string, set *templates.Set, s *stream.Stream) string {
+	if content != "" {
+		return content
+	}
+
+	return escape(plain(set.RenderOrDefault(templates.Title, templates.NewStream(s, clock.NowUTC()))))
+}
+
+func serviceName(s *stream.Stream) string {
+	switch s.Service {
+	case "", "twitch":
+		return "Twitch"
+	case "youtube":
+		return "YouTube"
+	}
+
+	return escape(s.Service)
+}
+
+// displayName formats a name of the streamer, mentioning the login if it differs
+// from the display name.
+func displayName(s *stream.Stream) string {
+	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
+		return fmt.Sprintf("%s (%s)", s.User.DisplayName, s.User.Name)
+	}
+
+	return s.User.DisplayName
+}
+
+// date formats a time in the local time zone of the reader.
+func date(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", t.Unix(), t.UTC().Format(time.RFC1123))
+}
+
+func link(text, url string) string {
+	return fmt.Sprintf("<%s|%s>", url, linkText(escape(text)))
+}
+
+// linkText replaces pipes of escaped link texts, which mrkdwn has no escape for,
+// with a lookalike, so that the text can't pass for the URL of a link.
+func linkText(s string) string {
+	return strings.ReplaceAll(s, "|", "∣")
+}
+
+// linkable answers whether a URL is safe to link to. Other "URLs", e.g. "!channel",
+// turn into mentions and special commands when enclosed in angle brackets.
+func linkable(url string) bool {
+	return (strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) &&
+		!strings.ContainsAny(url, "|")
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escape escapes control characters of mrkdwn, so that text controlled by streamers,
+// e.g. titles, can never ping anyone.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+var (
+	linkRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	boldRegex = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	unescaper = strings.NewReplacer(`\_`, "_", `\*`, "*")
+	tagRegex  = regexp.MustCompile(`&lt;([@#][A-Z0-9]+(?:\|[\w-]*)?|!subteam\^[A-Z0-9]+)&gt;`)
+)
+
+// mrkdwn converts rendered templates from Discord-flavoured Markdown, which templates
+// use by default, to Slack mrkdwn. Links to anything but web pages are left as text.
+func mrkdwn(s string) string {
+	s = linkRegex.ReplaceAllStringFunc(escape(unescaper.Replace(s)), func(match string) string {
+		groups := linkRegex.FindStringSubmatch(match)
+		if !linkable(groups[2]) {
+			return match
+		}
+
+		return fmt.Sprintf("<%s|%s>", groups[2], linkText(groups[1]))
+	})
+
+	return boldRegex.ReplaceAllString(s, "*$1*")
+}
+
+// withTags converts texts of the bot to Slack mrkdwn, leaving references to channels,
+// users and user groups intact.
+func withTags(s string) string {
+	return tagRegex.ReplaceAllString(mrkdwn(s), "<$1>")
+}
+
+// plain strips Markdown escapes from rendered templates of plain text, e.g. headers.
+func plain(s string) string {
+	return unescaper.Replace(s)
+}
@@ -0,0 +1,256 @@
+// Package slack implements a messenger that announces streams to Slack channels with
+// Block Kit messages, and receives commands by mentions over Socket Mode.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// PlatformName is a name of the Slack messaging platform.
+const PlatformName = "slack"
+
+// DefaultAPIURL is a base URL of the Slack Web API.
+const DefaultAPIURL = "https://slack.com/api"
+
+// Options of the Slack messenger.
+type Options struct {
+	// BotToken is a bot user OAuth token (xoxb-) used to post messages.
+	BotToken string
+
+	// AppToken is an app-level token (xapp-) with connections:write scope used to receive
+	// mentions over Socket Mode. Commands are disabled without it.
+	AppToken string
+
+	// APIURL overrides DefaultAPIURL. Meant for testing.
+	APIURL string
+
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+}
+
+var _ messenger.Messenger = &Messenger{}
+
+// Messenger posts to Slack channels, which are its rooms, identified by channel IDs.
+type Messenger struct {
+	opts Options
+
+	mu       sync.Mutex
+	handlerC context.Context
+	handler  messenger.Handler
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewMessenger(opts Options) (*Messenger, error) {
+	if opts.BotToken == "" {
+		return nil, errors.New("Slack bot token is required")
+	}
+
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+
+	return &Messenger{opts: opts}, nil
+}
+
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	content := set.RenderOrDefault(templates.Content, templates.NewStream(s, clock.NowUTC()))
+	text := strings.TrimSpace(mention + " " + mrkdwn(content))
+
+	var resp postResponseT
+	err = m.call(c, "chat.postMessage", m.opts.BotToken, messageT{
+		Channel: roomID,
+		Text:    fallbackText(text, set, s),
+		Blocks:  liveBlocks(set, s, text),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.TS, nil
+}
+
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+
+	return m.call(c, "chat.update", m.opts.BotToken, messageT{
+		Channel: roomID,
+		TS:      messageID,
+		Text:    fallbackText("", set, s),
+		Blocks:  liveBlocks(set, s, ""),
+	}, nil)
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	message := messageT{
+		Channel: roomID,
+		Text:    escape(displayName(s) + " was live"),
+		Blocks:  endedBlocks(s, endedAt),
+	}
+
+	edit := func() error {
+		update := message
+		update.TS = messageID
+
+		return m.call(c, "chat.update", m.opts.BotToken, update, nil)
+	}
+
+	return messenger.EditOrPost(roomID, messageID, edit, func() error {
+		return m.call(c, "chat.postMessage", m.opts.BotToken, message, nil)
+	})
+}
+
+func (m *Messenger) MessageStreamList(c context.Context, roomID string, ss []stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(ss, clock.NowUTC()))
+
+	return m.call(c, "chat.postMessage", m.opts.BotToken, messageT{
+		Channel: roomID,
+		Text:    title,
+		Blocks:  listBlocks(title, ss),
+	}, nil)
+}
+
+func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
+	// Texts may quote streamers and users, so they are escaped to never ping anyone
+	// but those mentioned by the bot itself.
+	return m.call(c, "chat.postMessage", m.opts.BotToken, messageT{
+		Channel: roomID,
+		Text:    withTags(text),
+	}, nil)
+}
+
+func (m *Messenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlerC, m.handler = c, h
+}
+
+// Run starts receiving mentions over Socket Mode, provided there's a command handler
+// and an app-level token to connect with.
+func (m *Messenger) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handler == nil || m.opts.AppToken == "" {
+		return nil
+	}
+
+	c, cancel := context.WithCancel(m.handlerC)
+	m.cancel, m.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		m.listen(c, m.handler)
+	}()
+
+	return nil
+}
+
+func (m *Messenger) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+		m.cancel = nil
+	}
+
+	return nil
+}
+
+// messageT is a message posted with chat.postMessage or edited with chat.update.
+type messageT struct {
+	Channel string   `json:"channel"`
+	TS      string   `json:"ts,omitempty"`
+	Text    string   `json:"text"`
+	Blocks  []blockT `json:"blocks,omitempty"`
+}
+
+// responseT is the part common to all Web API responses.
+type responseT struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (r *responseT) response() *responseT {
+	return r
+}
+
+type postResponseT struct {
+	responseT
+	TS string `json:"ts"`
+}
+
+// call invokes a Web API method with a JSON body, decoding the response into resp if given.
+func (m *Messenger) call(c context.Context, method, token string, body any, resp interface{ response() *responseT }) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(c, "POST", m.opts.APIURL+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack %s failed with status %s", method, r.Status)
+	}
+
+	if resp == nil {
+		resp = &responseT{}
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode Slack %s response: %w", method, err)
+	}
+
+	if !resp.response().OK {
+		return fmt.Errorf("Slack %s failed: %s", method, resp.response().Error)
+	}
+
+	return nil
+}
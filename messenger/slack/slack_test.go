@@ -0,0 +1,336 @@
+package slack_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger/slack"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestMessageStreamPostsBlocks(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	if err := db.MentionStore(c, db.Mention{RoomID: "C1", Mention: "<@U42>"}); err != nil {
+		t.Fatalf("Failed to store mention: %s", err)
+	}
+
+	messageID, err := m.MessageStream(c, "C1", testutil.NewStream("Learning <Go> & <!channel>"))
+	if err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	if messageID != "1.000001" {
+		t.Errorf("Expected message ID %q, got %q", "1.000001", messageID)
+	}
+
+	call := api.lastCall(t, "chat.postMessage")
+
+	if call.Token != "xoxb-bot" {
+		t.Errorf("Expected bot token, got %q", call.Token)
+	}
+
+	if call.Body.Channel != "C1" || call.Body.Text != "<@U42>" {
+		t.Errorf("Unexpected channel %q or text %q", call.Body.Channel, call.Body.Text)
+	}
+
+	blocks := call.Body.Blocks
+	if len(blocks) < 3 || blocks[0].Type != "section" || blocks[1].Type != "header" {
+		t.Fatalf("Unexpected blocks %+v", blocks)
+	}
+
+	if title := blocks[1].Text.Text; title != "Go_Sensei (sensei) Went Live!" {
+		t.Errorf("Unexpected header %q", title)
+	}
+
+	expected := "<https://www.twitch.tv/sensei|Learning &lt;Go&gt; &amp; &lt;!channel&gt;>"
+	if description := blocks[2].Text.Text; description != expected {
+		t.Errorf("Expected description %q, got %q", expected, description)
+	}
+}
+
+func TestMessageStreamDoesNotLinkHostileTitles(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	if _, err := m.MessageStream(c, "C1", testutil.NewStream("go [hi](!channel) now | [x](<!here>)")); err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	description := api.lastCall(t, "chat.postMessage").Body.Blocks[1].Text.Text
+	if strings.Contains(description, "<!") || strings.Count(description, "|") != 1 {
+		t.Errorf("Expected the title not to turn into mentions or links, got %q", description)
+	}
+}
+
+func TestMessageTextLinksOnlyWebPages(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	if err := m.MessageText(c, "C1", "see [the|docs](https://example.com/) not [hi](!channel)"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	expected := "see <https://example.com/|the∣docs> not [hi](!channel)"
+	if text := api.lastCall(t, "chat.postMessage").Body.Text; text != expected {
+		t.Errorf("Expected text %q, got %q", expected, text)
+	}
+}
+
+func TestUpdateStreamEditsMessage(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	if err := m.UpdateStream(c, "C1", "1.000001", testutil.NewStream("Renamed")); err != nil {
+		t.Fatalf("UpdateStream failed: %s", err)
+	}
+
+	call := api.lastCall(t, "chat.update")
+	if call.Body.Channel != "C1" || call.Body.TS != "1.000001" {
+		t.Errorf("Unexpected channel %q or ts %q", call.Body.Channel, call.Body.TS)
+	}
+}
+
+func TestMessageStreamEndedPostsWhenEditFails(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	api.fail("chat.update", "message_not_found")
+
+	s := testutil.NewStream("Title")
+	if err := m.MessageStreamEnded(c, "C1", "1.000001", s, s.StartedAt.Add(90*time.Minute)); err != nil {
+		t.Fatalf("MessageStreamEnded failed: %s", err)
+	}
+
+	call := api.lastCall(t, "chat.postMessage")
+	if call.Body.TS != "" {
+		t.Errorf("Expected a new message, got ts %q", call.Body.TS)
+	}
+
+	if text := call.Body.Blocks[1].Text.Text; !strings.HasSuffix(text, "streamed for 1h30m") {
+		t.Errorf("Unexpected summary %q", text)
+	}
+}
+
+func TestMessageTextEscapesText(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	if err := m.MessageText(c, "C1", "Room <#C2|general> mentions <@U1> but not <!everyone>"); err != nil {
+		t.Fatalf("MessageText failed: %s", err)
+	}
+
+	expected := "Room <#C2|general> mentions <@U1> but not &lt;!everyone&gt;"
+	if text := api.lastCall(t, "chat.postMessage").Body.Text; text != expected {
+		t.Errorf("Expected text %q, got %q", expected, text)
+	}
+}
+
+func TestSocketModeRoutesMentions(t *testing.T) {
+	c, cancel := context.WithCancel(testutil.SetupDB())
+	defer cancel()
+
+	api := newFakeSlack(t)
+	m := api.messenger(t)
+
+	h := testutil.NewHandler()
+	m.AddCommandHandler(c, h)
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	defer m.Close()
+
+	api.send(t, map[string]any{
+		"envelope_id": "envelope1",
+		"type":        "events_api",
+		"payload": map[string]any{
+			"event": map[string]string{"type": "app_mention", "channel": "C1", "text": "<@UBOT> list"},
+		},
+	})
+
+	select {
+	case message := <-h.Handled:
+		if message != "C1: <@UBOT> list" {
+			t.Errorf("Unexpected message %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the mention to be handled")
+	}
+
+	select {
+	case id := <-api.acks:
+		if id != "envelope1" {
+			t.Errorf("Expected envelope1 acknowledged, got %q", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the acknowledgement")
+	}
+}
+
+//
+// HELPERS
+//
+
+type callT struct {
+	Token string
+	Body  struct {
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+		Text    string `json:"text"`
+		Blocks  []struct {
+			Type string `json:"type"`
+			Text struct {
+				Text string `json:"text"`
+			} `json:"text"`
+		} `json:"blocks"`
+	}
+}
+
+type fakeSlack struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	calls     map[string][]callT
+	failures  map[string]string
+	conn      *websocket.Conn
+	connected chan struct{}
+	acks      chan string
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	t.Helper()
+
+	s := &fakeSlack{
+		calls:     make(map[string][]callT),
+		failures:  make(map[string]string),
+		connected: make(chan struct{}, 10),
+		acks:      make(chan string, 10),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.api)
+	mux.HandleFunc("/socket", s.socket)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakeSlack) messenger(t *testing.T) *slack.Messenger {
+	t.Helper()
+
+	m, err := slack.NewMessenger(slack.Options{
+		BotToken: "xoxb-bot",
+		AppToken: "xapp-app",
+		APIURL:   s.URL + "/api",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	return m
+}
+
+func (s *fakeSlack) fail(method, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = reason
+}
+
+func (s *fakeSlack) api(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+
+	var call callT
+	call.Token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	json.NewDecoder(r.Body).Decode(&call.Body)
+
+	s.mu.Lock()
+	s.calls[method] = append(s.calls[method], call)
+	failure := s.failures[method]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case failure != "":
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": failure})
+	case method == "apps.connections.open":
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "url": "ws" + strings.TrimPrefix(s.URL, "http") + "/socket"})
+	default:
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "channel": call.Body.Channel, "ts": "1.000001"})
+	}
+}
+
+func (s *fakeSlack) socket(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.conn = conn
+	conn.WriteJSON(map[string]string{"type": "hello"})
+	s.mu.Unlock()
+
+	s.connected <- struct{}{}
+
+	for {
+		var ack struct {
+			EnvelopeID string `json:"envelope_id"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+
+		s.acks <- ack.EnvelopeID
+	}
+}
+
+func (s *fakeSlack) send(t *testing.T, envelope any) {
+	t.Helper()
+
+	select {
+	case <-s.connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for Socket Mode connection")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.conn.WriteJSON(envelope); err != nil {
+		t.Fatalf("Failed to send envelope: %s", err)
+	}
+}
+
+func (s *fakeSlack) lastCall(t *testing.T, method string) callT {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	calls := s.calls[method]
+	if len(calls) == 0 {
+		t.Fatalf("Expected a call to %s", method)
+	}
+
+	return calls[len(calls)-1]
+}
@@ -0,0 +1,129 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TeamTenuki/twiddler/messenger"
+)
+
+// maxBackoff limits delays between Socket Mode reconnection attempts.
+const maxBackoff = time.Minute
+
+// envelopeT is a message received over Socket Mode.
+type envelopeT struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// eventsPayloadT is a payload of an "events_api" envelope.
+type eventsPayloadT struct {
+	Event struct {
+		Type    string `json:"type"`
+		Text    string `json:"text"`
+		Channel string `json:"channel"`
+		User    string `json:"user"`
+		BotID   string `json:"bot_id"`
+	} `json:"event"`
+}
+
+type connectionsOpenT struct {
+	responseT
+	URL string `json:"url"`
+}
+
+// listen receives mentions over Socket Mode until c is cancelled, reconnecting
+// with exponential backoff whenever the connection fails.
+func (m *Messenger) listen(c context.Context, h messenger.Handler) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := m.session(c, h)
+		if c.Err() != nil {
+			return
+		}
+
+		log.Printf("Slack Socket Mode session ended: %s", err)
+
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.Done():
+			return
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session opens a Socket Mode connection and processes envelopes until it fails
+// or the server asks to reconnect. Every envelope is acknowledged right away,
+// as Slack retries unacknowledged ones.
+func (m *Messenger) session(c context.Context, h messenger.Handler) error {
+	var open connectionsOpenT
+	if err := m.call(c, "apps.connections.open", m.opts.AppToken, struct{}{}, &open); err != nil {
+		return err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(c, open.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Closing a connection is the only way to interrupt a blocking read.
+	go func() {
+		select {
+		case <-c.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	for {
+		var e envelopeT
+		if err := conn.ReadJSON(&e); err != nil {
+			return err
+		}
+
+		if e.EnvelopeID != "" {
+			if err := conn.WriteJSON(map[string]string{"envelope_id": e.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+
+		switch e.Type {
+		case "disconnect":
+			return errors.New("server requested reconnection")
+
+		case "events_api":
+			var p eventsPayloadT
+			if err := json.Unmarshal(e.Payload, &p); err != nil {
+				log.Printf("Failed to decode Slack event: %s", err)
+				continue
+			}
+
+			// Mentions are handled concurrently, so that slow commands don't delay acknowledgements.
+			if p.Event.Type == "app_mention" && p.Event.BotID == "" {
+				go func(channel, text string) {
+					if err := h.Handle(c, channel, text, m); err != nil {
+						log.Printf("Failed to handle Slack command in %s: %s", channel, err)
+					}
+				}(p.Event.Channel, p.Event.Text)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// liveCaption formats an announcement of a stream going live, preceded by mention if any.
+func liveCaption(set *templates.Set, s *stream.Stream, mention string) string {
+	data := templates.NewStream(s, clock.NowUTC())
+
+	var lines []string
+	if content := strings.TrimSpace(html.EscapeString(mention) + " " + toHTML(set.RenderOrDefault(templates.Content, data))); content != "" {
+		lines = append(lines, content, "")
+	}
+
+	lines = append(lines,
+		"<b>"+toHTML(set.RenderOrDefault(templates.Title, data))+"</b>",
+		toHTML(set.RenderOrDefault(templates.Description, data)),
+		"")
+
+	details := serviceName(s)
+	if s.Category != "" {
+		details = html.EscapeString(s.Category) + " · " + details
+	}
+
+	lines = append(lines,
+		"<i>"+details+"</i>",
+		"<i>"+toHTML(set.RenderOrDefault(templates.Footer, data))+" "+s.StartedAt.UTC().Format("2006-01-02 15:04 MST")+"</i>")
+
+	return strings.Join(lines, "\n")
+}
+
+// endedText formats a summary of a stream that went offline.
+func endedText(s *stream.Stream, endedAt time.Time) string {
+	return fmt.Sprintf("<b>%s Was Live</b>\n%s\n\n<i>Offline — streamed for %s</i>",
+		html.EscapeString(displayName(s)),
+		link(s.Title, s.User.ChannelURL.String()),
+		messenger.FormatDuration(endedAt.Sub(s.StartedAt)))
+}
+
+// listText formats a list of live streams.
+func listText(title string, ss []stream.Stream) string {
+	lines := []string{"<b>" + toHTML(title) + "</b>"}
+
+	for _, s := range ss {
+		line := fmt.Sprintf("• <b>%s</b> — %s", html.EscapeString(s.User.Name), link(s.Title, s.User.ChannelURL.String()))
+		if s.Category != "" {
+			line += " · " + html.EscapeString(s.Category)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// thumbnailURL yields an URL of the stream thumbnail made unique, so that Telegram
+// doesn't serve a cached one.
+func thumbnailURL(s *stream.Stream) string {
+	if s.ThumbnailURL == nil || s.ThumbnailURL.String() == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s?cache_invalidation_token=%d", s.ThumbnailURL, rand.Int())
+}
+
+func serviceName(s *stream.Stream) string {
+	switch s.Service {
+	case "", "twitch":
+		return "Twitch"
+	case "youtube":
+		return "YouTube"
+	}
+
+	return html.EscapeString(s.Service)
+}
+
+// displayName formats a name of the streamer, mentioning the login if it differs
+// from the display name.
+func displayName(s *stream.Stream) string {
+	if strings.ToLower(s.User.Name) != strings.ToLower(s.User.DisplayName) {
+		return fmt.Sprintf("%s (%s)", s.User.DisplayName, s.User.Name)
+	}
+
+	return s.User.DisplayName
+}
+
+func link(text, url string) string {
+	return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+}
+
+var (
+	linkRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+	boldRegex = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	codeRegex = regexp.MustCompile("`([^`]+)`")
+	unescaper = strings.NewReplacer(`\_`, "_", `\*`, "*")
+)
+
+// toHTML converts rendered templates and texts from Discord-flavoured Markdown, which
+// templates and commands use, to the HTML subset supported by Telegram.
+func toHTML(s string) string {
+	s = html.EscapeString(unescaper.Replace(s))
+	s = linkRegex.ReplaceAllString(s, `<a href="$2">$1</a>`)
+	s = boldRegex.ReplaceAllString(s, "<b>$1</b>")
+
+	return codeRegex.ReplaceAllString(s, "<code>$1</code>")
+}
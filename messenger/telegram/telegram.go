@@ -0,0 +1,336 @@
+// Package telegram implements a messenger that announces streams to Telegram chats with
+// the Bot API, and receives commands, e.g. "/list", by long polling for updates.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// PlatformName is a name of the Telegram messaging platform.
+const PlatformName = "telegram"
+
+// DefaultAPIURL is a base URL of the Telegram Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// DefaultPollTimeout is how long the Bot API holds getUpdates requests without new updates.
+const DefaultPollTimeout = 30 * time.Second
+
+// maxCaption is the maximum length of photo captions, longer announcements are sent as text.
+const maxCaption = 1024
+
+// Options of the Telegram messenger.
+type Options struct {
+	// Token is a bot token issued by @BotFather.
+	Token string
+
+	// APIURL overrides DefaultAPIURL. Meant for testing.
+	APIURL string
+
+	// PollTimeout overrides DefaultPollTimeout.
+	PollTimeout time.Duration
+
+	// Templates override the default templates of announcements by kind, and are
+	// overridden in turn by room settings.
+	Templates map[string]string
+}
+
+var (
+	_ messenger.Messenger    = &Messenger{}
+	_ messenger.RoomResolver = &Messenger{}
+)
+
+// Messenger posts to Telegram chats, identified by chat IDs, e.g. "-1001234567890".
+type Messenger struct {
+	opts Options
+
+	mu       sync.Mutex
+	handlerC context.Context
+	handler  messenger.Handler
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewMessenger(opts Options) (*Messenger, error) {
+	if opts.Token == "" {
+		return nil, errors.New("Telegram bot token is required")
+	}
+
+	if _, err := templates.NewSet(opts.Templates); err != nil {
+		return nil, err
+	}
+
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+
+	if opts.PollTimeout == 0 {
+		opts.PollTimeout = DefaultPollTimeout
+	}
+
+	return &Messenger{opts: opts}, nil
+}
+
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	mention, err := db.MentionFor(c, roomID, strings.ToLower(s.User.Name))
+	if err != nil {
+		log.Printf("Failed to retrieve mention of room %s: %s", roomID, err)
+	}
+
+	caption := liveCaption(templates.ForRoomOrDefault(c, roomID, m.opts.Templates), s, mention)
+
+	var sent messageT
+	if photo := thumbnailURL(s); photo != "" && utf8.RuneCountInString(caption) <= maxCaption {
+		err = m.call(c, "sendPhoto", map[string]any{
+			"chat_id":    roomID,
+			"photo":      photo,
+			"caption":    caption,
+			"parse_mode": "HTML",
+		}, &sent)
+	} else {
+		err = m.call(c, "sendMessage", textMessage(roomID, caption), &sent)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(sent.MessageID, 10), nil
+}
+
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	caption := liveCaption(templates.ForRoomOrDefault(c, roomID, m.opts.Templates), s, "")
+
+	// Replacing the photo with a fresh one refreshes the thumbnail.
+	if photo := thumbnailURL(s); photo != "" && utf8.RuneCountInString(caption) <= maxCaption {
+		return m.call(c, "editMessageMedia", map[string]any{
+			"chat_id":    roomID,
+			"message_id": messageID,
+			"media": map[string]any{
+				"type":       "photo",
+				"media":      photo,
+				"caption":    caption,
+				"parse_mode": "HTML",
+			},
+		}, nil)
+	}
+
+	return m.call(c, "editMessageText", map[string]any{
+		"chat_id":    roomID,
+		"message_id": messageID,
+		"text":       caption,
+		"parse_mode": "HTML",
+	}, nil)
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	summary := endedText(s, endedAt)
+
+	edit := func() error {
+		// Announcements are photos with captions, unless they were too long.
+		err := m.call(c, "editMessageCaption", map[string]any{
+			"chat_id":    roomID,
+			"message_id": messageID,
+			"caption":    summary,
+			"parse_mode": "HTML",
+		}, nil)
+		if err == nil {
+			return nil
+		}
+
+		return m.call(c, "editMessageText", map[string]any{
+			"chat_id":    roomID,
+			"message_id": messageID,
+			"text":       summary,
+			"parse_mode": "HTML",
+		}, nil)
+	}
+
+	return messenger.EditOrPost(roomID, messageID, edit, func() error {
+		return m.call(c, "sendMessage", textMessage(roomID, summary), nil)
+	})
+}
+
+func (m *Messenger) MessageStreamList(c context.Context, roomID string, ss []stream.Stream) error {
+	set := templates.ForRoomOrDefault(c, roomID, m.opts.Templates)
+	title := set.RenderOrDefault(templates.ListTitle, templates.NewList(ss, clock.NowUTC()))
+
+	return m.call(c, "sendMessage", textMessage(roomID, listText(title, ss)), nil)
+}
+
+func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
+	return m.call(c, "sendMessage", textMessage(roomID, toHTML(text)), nil)
+}
+
+var (
+	chatIDRegex   = regexp.MustCompile(`^-?\d+$`)
+	usernameRegex = regexp.MustCompile(`^@\w{4,}$`)
+)
+
+// ResolveRoom resolves public chat usernames, e.g. "@gochannel", into chat IDs.
+// Chat IDs, e.g. "-1001234567890", are taken as is.
+func (m *Messenger) ResolveRoom(c context.Context, ref string) (string, error) {
+	switch {
+	case chatIDRegex.MatchString(ref):
+		return ref, nil
+
+	case usernameRegex.MatchString(ref):
+		var chat struct {
+			ID int64 `json:"id"`
+		}
+
+		if err := m.call(c, "getChat", map[string]any{"chat_id": ref}, &chat); err != nil {
+			return "", fmt.Errorf("Failed to resolve chat %s: %w", ref, err)
+		}
+
+		return strconv.FormatInt(chat.ID, 10), nil
+	}
+
+	return "", errors.New("Improper room format, expected a chat ID like `-1001234567890` or a username like `@gochannel`")
+}
+
+// FormatRoom refers to rooms by their chat IDs, as chats may have no usernames.
+func (m *Messenger) FormatRoom(roomID string) string {
+	return roomID
+}
+
+func (m *Messenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlerC, m.handler = c, h
+}
+
+// Run looks up the bot username and starts polling for commands, provided there's
+// a command handler.
+func (m *Messenger) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handler == nil {
+		return nil
+	}
+
+	var me struct {
+		Username string `json:"username"`
+	}
+
+	if err := m.call(m.handlerC, "getMe", struct{}{}, &me); err != nil {
+		return fmt.Errorf("failed to look up the Telegram bot: %w", err)
+	}
+
+	c, cancel := context.WithCancel(m.handlerC)
+	m.cancel, m.done = cancel, make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		m.listen(c, m.handler, me.Username)
+	}()
+
+	return nil
+}
+
+func (m *Messenger) Close() error {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	return nil
+}
+
+func textMessage(chatID, text string) map[string]any {
+	return map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}
+}
+
+// messageT is a sent message, limited to what the bot cares about.
+type messageT struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// responseT is a response of any Bot API method.
+type responseT struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// call invokes a Bot API method with a JSON body, decoding the result into result if given.
+// Edits that change nothing aren't considered failures.
+func (m *Messenger) call(c context.Context, method string, body, result any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/bot%s/%s", m.opts.APIURL, m.opts.Token, method)
+	req, err := http.NewRequestWithContext(c, "POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error would quote the URL with the token.
+		if c.Err() != nil {
+			return c.Err()
+		}
+
+		return fmt.Errorf("Telegram %s request failed", method)
+	}
+	defer r.Body.Close()
+
+	var resp responseT
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode Telegram %s response: %w", method, err)
+	}
+
+	if !resp.OK {
+		if strings.Contains(resp.Description, "message is not modified") {
+			return nil
+		}
+
+		return fmt.Errorf("Telegram %s failed with code %d: %s", method, resp.ErrorCode, resp.Description)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,304 @@
+package telegram_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger/telegram"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestMessageStreamSendsPhoto(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeBotAPI(t)
+	m := api.messenger(t)
+
+	if err := db.MentionStore(c, db.Mention{RoomID: "-100123", Mention: "@fan"}); err != nil {
+		t.Fatalf("Failed to store mention: %s", err)
+	}
+
+	messageID, err := m.MessageStream(c, "-100123", testutil.NewStream("Learning <Go>"))
+	if err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	if messageID != "1" {
+		t.Errorf("Expected message ID %q, got %q", "1", messageID)
+	}
+
+	call := api.lastCall(t, "sendPhoto")
+
+	if call["chat_id"] != "-100123" || call["parse_mode"] != "HTML" {
+		t.Errorf("Unexpected chat %v or parse mode %v", call["chat_id"], call["parse_mode"])
+	}
+
+	if photo, _ := call["photo"].(string); !strings.HasPrefix(photo, "https://example.com/thumbnail.jpg?") {
+		t.Errorf("Unexpected photo %q", photo)
+	}
+
+	caption, _ := call["caption"].(string)
+	for _, expected := range []string{
+		"@fan\n",
+		"<b>Go_Sensei (sensei) Went Live!</b>",
+		`<a href="https://www.twitch.tv/sensei">Learning &lt;Go&gt;</a>`,
+		"<i>Go · Twitch</i>",
+	} {
+		if !strings.Contains(caption, expected) {
+			t.Errorf("Expected %q in caption %q", expected, caption)
+		}
+	}
+}
+
+func TestUpdateStreamReplacesPhoto(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeBotAPI(t)
+	m := api.messenger(t)
+
+	if err := m.UpdateStream(c, "-100123", "1", testutil.NewStream("Renamed")); err != nil {
+		t.Fatalf("UpdateStream failed: %s", err)
+	}
+
+	call := api.lastCall(t, "editMessageMedia")
+	if call["chat_id"] != "-100123" || call["message_id"] != "1" {
+		t.Errorf("Unexpected chat %v or message %v", call["chat_id"], call["message_id"])
+	}
+
+	media, _ := call["media"].(map[string]any)
+	if caption, _ := media["caption"].(string); !strings.Contains(caption, "Renamed") {
+		t.Errorf("Expected the new title in caption %q", caption)
+	}
+}
+
+func TestMessageStreamListFormatsHTML(t *testing.T) {
+	c := testutil.SetupDB()
+	api := newFakeBotAPI(t)
+	m := api.messenger(t)
+
+	if err := m.MessageStreamList(c, "-100123", []stream.Stream{*testutil.NewStream("A & B")}); err != nil {
+		t.Fatalf("MessageStreamList failed: %s", err)
+	}
+
+	expected := "<b>Currently Live</b>\n• <b>sensei</b> — <a href=\"https://www.twitch.tv/sensei\">A &amp; B</a> · Go"
+	if text := api.lastCall(t, "sendMessage")["text"]; text != expected {
+		t.Errorf("Expected text %q, got %q", expected, text)
+	}
+}
+
+func TestResolveRoom(t *testing.T) {
+	api := newFakeBotAPI(t)
+	m := api.messenger(t)
+
+	for ref, expected := range map[string]string{
+		"-100123":    "-100123",
+		"42":         "42",
+		"@gochannel": "-100456",
+	} {
+		roomID, err := m.ResolveRoom(context.Background(), ref)
+		if err != nil {
+			t.Errorf("Failed to resolve %q: %s", ref, err)
+		}
+
+		if roomID != expected {
+			t.Errorf("Expected %q resolved to %q, got %q", ref, expected, roomID)
+		}
+	}
+
+	for _, ref := range []string{"@missing", "<#123>", "go"} {
+		if _, err := m.ResolveRoom(context.Background(), ref); err == nil {
+			t.Errorf("Expected %q to fail resolving", ref)
+		}
+	}
+}
+
+func TestPollingRoutesCommands(t *testing.T) {
+	c, cancel := context.WithCancel(testutil.SetupDB())
+	defer cancel()
+
+	api := newFakeBotAPI(t)
+	m := api.messenger(t)
+
+	h := testutil.NewHandler()
+	m.AddCommandHandler(c, h)
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	defer m.Close()
+
+	api.updates <- []map[string]any{
+		update(6, -100123, "/list@twiddler_bot"),
+		update(7, -100123, "/list@other_bot"),
+		update(8, -100123, "hello"),
+		update(9, 42, "/spam -100123"),
+	}
+
+	for _, expected := range []string{"-100123: list", "42: spam -100123"} {
+		select {
+		case handled := <-h.Handled:
+			if handled != expected {
+				t.Errorf("Expected %q handled, got %q", expected, handled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", expected)
+		}
+	}
+
+	select {
+	case handled := <-h.Handled:
+		t.Errorf("Unexpected command %q", handled)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.offset != 10 {
+		t.Errorf("Expected offset 10 after the updates, got %d", api.offset)
+	}
+}
+
+//
+// HELPERS
+//
+
+type fakeBotAPI struct {
+	*httptest.Server
+
+	updates chan []map[string]any
+
+	mu       sync.Mutex
+	calls    map[string][]map[string]any
+	messages int
+	offset   int
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	t.Helper()
+
+	api := &fakeBotAPI{
+		updates: make(chan []map[string]any, 10),
+		calls:   make(map[string][]map[string]any),
+	}
+
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+func (api *fakeBotAPI) messenger(t *testing.T) *telegram.Messenger {
+	t.Helper()
+
+	m, err := telegram.NewMessenger(telegram.Options{
+		Token:       "123:secret",
+		APIURL:      api.URL,
+		PollTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	return m
+}
+
+func (api *fakeBotAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method, found := strings.CutPrefix(r.URL.Path, "/bot123:secret/")
+	if !found {
+		writeJSON(w, map[string]any{"ok": false, "error_code": 401, "description": "Unauthorized"})
+		return
+	}
+
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	api.mu.Lock()
+	api.calls[method] = append(api.calls[method], body)
+	api.mu.Unlock()
+
+	switch method {
+	case "sendPhoto", "sendMessage":
+		api.mu.Lock()
+		api.messages++
+		id := api.messages
+		api.mu.Unlock()
+
+		writeJSON(w, map[string]any{"ok": true, "result": map[string]any{"message_id": id}})
+
+	case "getMe":
+		writeJSON(w, map[string]any{"ok": true, "result": map[string]any{"id": 1, "username": "twiddler_bot"}})
+
+	case "getChat":
+		if body["chat_id"] == "@gochannel" {
+			writeJSON(w, map[string]any{"ok": true, "result": map[string]any{"id": -100456}})
+			return
+		}
+
+		writeJSON(w, map[string]any{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"})
+
+	case "getUpdates":
+		api.getUpdates(w, r, body)
+
+	default:
+		writeJSON(w, map[string]any{"ok": true, "result": true})
+	}
+}
+
+// getUpdates serves a stale update initially, and then queued updates,
+// holding requests for a while when there are none.
+func (api *fakeBotAPI) getUpdates(w http.ResponseWriter, r *http.Request, body map[string]any) {
+	offset, _ := body["offset"].(float64)
+
+	api.mu.Lock()
+	api.offset = int(offset)
+	api.mu.Unlock()
+
+	if offset == -1 {
+		writeJSON(w, map[string]any{"ok": true, "result": []any{update(5, 42, "/forget -100123")}})
+		return
+	}
+
+	select {
+	case updates := <-api.updates:
+		writeJSON(w, map[string]any{"ok": true, "result": updates})
+	case <-time.After(100 * time.Millisecond):
+		writeJSON(w, map[string]any{"ok": true, "result": []any{}})
+	case <-r.Context().Done():
+	}
+}
+
+func (api *fakeBotAPI) lastCall(t *testing.T, method string) map[string]any {
+	t.Helper()
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	calls := api.calls[method]
+	if len(calls) == 0 {
+		t.Fatalf("Expected a call to %s", method)
+	}
+
+	return calls[len(calls)-1]
+}
+
+func update(id, chatID int, text string) map[string]any {
+	return map[string]any{
+		"update_id": id,
+		"message": map[string]any{
+			"message_id": id,
+			"chat":       map[string]any{"id": chatID},
+			"text":       text,
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/messenger"
+)
+
+// maxBackoff limits delays between retries of failed polls.
+const maxBackoff = time.Minute
+
+// updateT is an update received with getUpdates, limited to what the bot cares about.
+type updateT struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// listen polls for updates until c is cancelled, passing commands to h.
+// Updates that arrived before the bot started are skipped.
+func (m *Messenger) listen(c context.Context, h messenger.Handler, username string) {
+	offset := int64(-1)
+	backoff := time.Second
+
+	for {
+		// The first poll only looks up the last pending update to skip them all.
+		timeout := m.opts.PollTimeout
+		if offset == -1 {
+			timeout = 0
+		}
+
+		updates, err := m.updates(c, offset, timeout)
+		if c.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Telegram polling failed: %s", err)
+
+			select {
+			case <-time.After(backoff):
+			case <-c.Done():
+				return
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			continue
+		}
+
+		backoff = time.Second
+
+		skip := offset == -1
+		if skip {
+			offset = 0
+		}
+
+		for _, u := range updates {
+			if u.UpdateID >= offset {
+				offset = u.UpdateID + 1
+			}
+
+			if skip || u.Message == nil {
+				continue
+			}
+
+			command, ok := parseCommand(u.Message.Text, username)
+			if !ok {
+				continue
+			}
+
+			chatID := strconv.FormatInt(u.Message.Chat.ID, 10)
+			if err := h.Handle(c, chatID, command, m); err != nil {
+				log.Printf("Failed to handle Telegram command in %s: %s", chatID, err)
+			}
+		}
+	}
+}
+
+func (m *Messenger) updates(c context.Context, offset int64, timeout time.Duration) ([]updateT, error) {
+	// The request is held by the Bot API for up to timeout.
+	c, cancel := context.WithTimeout(c, timeout+30*time.Second)
+	defer cancel()
+
+	var updates []updateT
+	err := m.call(c, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+
+	return updates, err
+}
+
+var commandRegex = regexp.MustCompile(`^/(\w+)(?:@(\w+))?(.*)$`)
+
+// parseCommand converts a bot command, e.g. "/list@twiddler_bot", into a command of
+// the handler. Commands addressed to other bots are ignored.
+func parseCommand(text, username string) (string, bool) {
+	groups := commandRegex.FindStringSubmatch(strings.TrimSpace(text))
+	if groups == nil {
+		return "", false
+	}
+
+	if groups[2] != "" && !strings.EqualFold(groups[2], username) {
+		return "", false
+	}
+
+	return groups[1] + groups[3], true
+}
@@ -0,0 +1,272 @@
+// Package templates renders customisable parts of stream announcements with text/template.
+//
+// Stream templates are executed with a Stream, and list templates with a List. Besides the
+// builtins, templates may use the following functions:
+//
+//	lower  - converts a string to lower case
+//	upper  - converts a string to upper case
+//	escape - escapes Markdown emphasis, e.g. in streamer names with underscores
+//
+// Templates are single-line, though newlines may be inserted with {{"\n"}}.
+package templates
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// Kinds of templates.
+const (
+	// Title of a stream announcement, executed with a Stream.
+	Title = "title"
+
+	// Description of a stream announcement, executed with a Stream.
+	Description = "description"
+
+	// Footer of a stream announcement, executed with a Stream.
+	Footer = "footer"
+
+	// Content is a plain text sent along with a stream announcement, executed with a Stream.
+	Content = "content"
+
+	// ListTitle is a title of the list of live streams, executed with a List.
+	ListTitle = "list-title"
+)
+
+// Kinds lists all the kinds of templates.
+var Kinds = []string{Title, Description, Footer, Content, ListTitle}
+
+// Defaults are the templates used unless configured otherwise.
+var Defaults = map[string]string{
+	Title: `{{escape .User.DisplayName}}` +
+		`{{if ne (lower .User.Name) (lower .User.DisplayName)}} ({{escape .User.Name}}){{end}} Went Live!`,
+	Description: `[{{.Title}}]({{.User.ChannelURL}})`,
+	Footer:      `Live since`,
+	Content:     ``,
+	ListTitle:   `Currently Live`,
+}
+
+// SettingKey yields a key of the room setting overriding a given kind of templates.
+func SettingKey(kind string) string {
+	return kind + "-template"
+}
+
+// Stream is the data model of stream templates.
+type Stream struct {
+	ID       string
+	Service  string
+	Title    string
+	Category string
+	Language string
+
+	// ThumbnailURL is an URL of the stream preview.
+	ThumbnailURL string
+
+	// StartedAt is the time the stream went live.
+	StartedAt time.Time
+
+	// Uptime is how long the stream has been live, e.g. "2h13m".
+	Uptime string
+
+	User User
+}
+
+// User is the data model of streamers in templates.
+type User struct {
+	ID          string
+	Name        string
+	DisplayName string
+	ChannelURL  string
+	PictureURL  string
+}
+
+// List is the data model of list templates.
+type List struct {
+	Streams []Stream
+}
+
+// NewStream builds the data model of a stream.
+func NewStream(s *stream.Stream, now time.Time) Stream {
+	return Stream{
+		ID:           s.ID,
+		Service:      s.Service,
+		Title:        s.Title,
+		Category:     s.Category,
+		Language:     s.Language,
+		ThumbnailURL: urlString(s.ThumbnailURL),
+		StartedAt:    s.StartedAt,
+		Uptime:       messenger.FormatDuration(now.Sub(s.StartedAt)),
+		User: User{
+			ID:          s.User.ID,
+			Name:        s.User.Name,
+			DisplayName: s.User.DisplayName,
+			ChannelURL:  urlString(s.User.ChannelURL),
+			PictureURL:  urlString(s.User.PictureURL),
+		},
+	}
+}
+
+// NewList builds the data model of a list of streams.
+func NewList(ss []stream.Stream, now time.Time) List {
+	l := List{Streams: make([]Stream, len(ss))}
+	for i := range ss {
+		l.Streams[i] = NewStream(&ss[i], now)
+	}
+
+	return l
+}
+
+// Sample is a stream used to validate templates, and to preview them when nobody is live.
+var Sample = Stream{
+	ID:           "0",
+	Service:      "twitch",
+	Title:        "Go lessons for beginners",
+	Category:     "Go",
+	Language:     "en",
+	ThumbnailURL: "https://static-cdn.jtvnw.net/previews-ttv/live_user_sample-1280x720.jpg",
+	StartedAt:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	Uptime:       "1h30m",
+	User: User{
+		ID:          "0",
+		Name:        "go_sensei",
+		DisplayName: "Go_Sensei",
+		ChannelURL:  "https://www.twitch.tv/go_sensei",
+		PictureURL:  "https://static-cdn.jtvnw.net/jtv_user_pictures/go_sensei.png",
+	},
+}
+
+var funcs = template.FuncMap{
+	"lower":  strings.ToLower,
+	"upper":  strings.ToUpper,
+	"escape": escape,
+}
+
+// Parse parses a template of a given kind, and checks that it executes against Sample.
+func Parse(kind, text string) (*template.Template, error) {
+	if _, exists := Defaults[kind]; !exists {
+		return nil, fmt.Errorf("unknown template kind %q", kind)
+	}
+
+	t, err := template.New(kind).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var data any = Sample
+	if kind == ListTitle {
+		data = List{Streams: []Stream{Sample}}
+	}
+
+	if err := t.Execute(&strings.Builder{}, data); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Set is a set of templates of all kinds.
+type Set struct {
+	ts map[string]*template.Template
+}
+
+// NewSet parses templates of a set. Kinds missing from texts fall back to Defaults.
+func NewSet(texts map[string]string) (*Set, error) {
+	s := &Set{ts: make(map[string]*template.Template)}
+
+	for kind, text := range Defaults {
+		if override, exists := texts[kind]; exists {
+			text = override
+		}
+
+		t, err := Parse(kind, text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", kind, err)
+		}
+
+		s.ts[kind] = t
+	}
+
+	return s, nil
+}
+
+// ForRoom parses templates of a room, which override the global ones set with room settings.
+func ForRoom(c context.Context, roomID string, global map[string]string) (*Set, error) {
+	values, err := db.RoomSettingsAll(c, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make(map[string]string, len(Kinds))
+	for _, kind := range Kinds {
+		if text, exists := global[kind]; exists {
+			texts[kind] = text
+		}
+
+		if text, exists := values[SettingKey(kind)]; exists {
+			texts[kind] = text
+		}
+	}
+
+	return NewSet(texts)
+}
+
+// ForRoomOrDefault parses templates of a room like ForRoom, falling back to the defaults
+// if they fail to load.
+func ForRoomOrDefault(c context.Context, roomID string, global map[string]string) *Set {
+	set, err := ForRoom(c, roomID, global)
+	if err != nil {
+		log.Printf("Failed to load templates of room %s: %s", roomID, err)
+		set, _ = NewSet(nil)
+	}
+
+	return set
+}
+
+// Render executes a template of a given kind.
+func (s *Set) Render(kind string, data any) (string, error) {
+	t, exists := s.ts[kind]
+	if !exists {
+		return "", fmt.Errorf("unknown template kind %q", kind)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// RenderOrDefault executes a template of a given kind, falling back to the default one if it fails.
+func (s *Set) RenderOrDefault(kind string, data any) string {
+	text, err := s.Render(kind, data)
+	if err != nil {
+		log.Printf("Failed to render %s template: %s", kind, err)
+
+		defaults, _ := NewSet(nil)
+		text, _ = defaults.Render(kind, data)
+	}
+
+	return text
+}
+
+func escape(s string) string {
+	return strings.Replace(s, "_", "\\_", -1)
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	return u.String()
+}
@@ -0,0 +1,154 @@
+package templates_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger/templates"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+func TestDefaultsRenderOriginalLayout(t *testing.T) {
+	set, err := templates.NewSet(nil)
+	if err != nil {
+		t.Fatalf("Failed to parse default templates: %s", err)
+	}
+
+	data := templates.NewStream(newStream(), time.Date(2024, 1, 1, 13, 30, 0, 0, time.UTC))
+
+	expectRender(t, set, templates.Title, data, `Go\_Sensei (sensei) Went Live!`)
+	expectRender(t, set, templates.Description, data, "[Go lessons](https://www.twitch.tv/sensei)")
+	expectRender(t, set, templates.Footer, data, "Live since")
+	expectRender(t, set, templates.Content, data, "")
+	expectRender(t, set, templates.ListTitle, templates.List{}, "Currently Live")
+}
+
+func TestParseValidatesTemplates(t *testing.T) {
+	valid := []string{
+		`{{.User.DisplayName}} is live for {{.Uptime}}`,
+		`{{upper .Category}}{{"\n"}}{{.Language}}`,
+	}
+
+	for _, text := range valid {
+		if _, err := templates.Parse(templates.Title, text); err != nil {
+			t.Errorf("Expected %q to be valid, got %s", text, err)
+		}
+	}
+
+	invalid := []string{
+		`{{.User.DisplayName`,
+		`{{.Viewers}}`,
+		`{{unknown .Title}}`,
+	}
+
+	for _, text := range invalid {
+		if _, err := templates.Parse(templates.Title, text); err == nil {
+			t.Errorf("Expected %q to be invalid", text)
+		}
+	}
+
+	if _, err := templates.Parse(templates.ListTitle, `{{len .Streams}} live`); err != nil {
+		t.Errorf("Expected list title to be valid, got %s", err)
+	}
+
+	if _, err := templates.Parse(templates.ListTitle, `{{.Title}}`); err == nil {
+		t.Errorf("Expected list title executed with a stream to be invalid")
+	}
+}
+
+func TestForRoomOverridesGlobalTemplates(t *testing.T) {
+	db.MustInit(":memory:")
+
+	c := db.NewContext(context.Background())
+	db.SetupDB(c)
+
+	global := map[string]string{
+		templates.Title:  `{{.User.Name}} global`,
+		templates.Footer: `{{.Category}} global`,
+	}
+
+	if err := db.RoomSettingStore(c, "room1", templates.SettingKey(templates.Title), `{{.User.Name}} room`); err != nil {
+		t.Fatalf("Failed to store setting: %s", err)
+	}
+
+	data := templates.NewStream(newStream(), time.Now())
+
+	set, err := templates.ForRoom(c, "room1", global)
+	if err != nil {
+		t.Fatalf("Failed to load templates: %s", err)
+	}
+
+	expectRender(t, set, templates.Title, data, "sensei room")
+	expectRender(t, set, templates.Footer, data, "Go global")
+	expectRender(t, set, templates.Description, data, "[Go lessons](https://www.twitch.tv/sensei)")
+
+	set, err = templates.ForRoom(c, "room2", global)
+	if err != nil {
+		t.Fatalf("Failed to load templates: %s", err)
+	}
+
+	expectRender(t, set, templates.Title, data, "sensei global")
+}
+
+func TestBrokenTemplatesFallBackToDefaults(t *testing.T) {
+	db.MustInit(":memory:")
+
+	c := db.NewContext(context.Background())
+	db.SetupDB(c)
+
+	if err := db.RoomSettingStore(c, "room1", templates.SettingKey(templates.Title), `{{.Missing`); err != nil {
+		t.Fatalf("Failed to store setting: %s", err)
+	}
+
+	data := templates.NewStream(newStream(), time.Now())
+
+	set := templates.ForRoomOrDefault(c, "room1", nil)
+	expectRender(t, set, templates.Title, data, "Go\\_Sensei (sensei) Went Live!")
+
+	// The template only fails for streams other than the sample.
+	set = templates.ForRoomOrDefault(c, "room2", map[string]string{
+		templates.Title: `{{if eq .ID "0"}}sample{{else}}{{index .Title 99}}{{end}}`,
+	})
+
+	if text := set.RenderOrDefault(templates.Title, data); text != "Go\\_Sensei (sensei) Went Live!" {
+		t.Errorf("Expected the default title, got %q", text)
+	}
+}
+
+//
+// HELPERS
+//
+
+func newStream() *stream.Stream {
+	channelURL, _ := url.Parse("https://www.twitch.tv/sensei")
+
+	return &stream.Stream{
+		ID:       "stream1",
+		Service:  "twitch",
+		Title:    "Go lessons",
+		Category: "Go",
+		User: stream.User{
+			ID:          "user1",
+			Name:        "sensei",
+			DisplayName: "Go_Sensei",
+			ChannelURL:  channelURL,
+		},
+		StartedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func expectRender(t *testing.T, set *templates.Set, kind string, data any, expected string) {
+	t.Helper()
+
+	text, err := set.Render(kind, data)
+	if err != nil {
+		t.Fatalf("Failed to render %s: %s", kind, err)
+	}
+
+	if text != expected {
+		t.Errorf("Expected %s %q, got %q", kind, expected, text)
+	}
+}
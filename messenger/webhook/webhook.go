@@ -0,0 +1,475 @@
+// Package webhook implements a messenger that POSTs JSON documents describing streams
+// to configured URLs, one per room, for integrations without a messenger of their own.
+//
+// # Schema
+//
+// Every request carries a Payload, which is versioned by SchemaVersion. Fields are only
+// ever added within a version, so consumers should ignore fields they don't know.
+//
+//	{
+//	  "schema_version": 1,
+//	  "event": "stream.live",          // one of the Event* constants
+//	  "delivery_id": "…",              // unique for every event, kept across retries
+//	  "room_id": "ci",
+//	  "message_id": "…",               // delivery ID of the stream.live event of the stream
+//	  "sent_at": "2024-01-01T12:00:00Z",
+//	  "stream": {                      // stream.live, stream.updated and stream.ended
+//	    "id": "…", "service": "twitch", "title": "…", "category_id": "…", "category": "…",
+//	    "language": "en", "followed": false, "thumbnail_url": "…",
+//	    "started_at": "2024-01-01T11:00:00Z",
+//	    "user": {
+//	      "id": "…", "service": "twitch", "name": "…", "display_name": "…", "channel_url": "…",
+//	      "profile_url": "…", "picture_url": "…", "offline_image_url": "…"
+//	    }
+//	  },
+//	  "ended_at": "2024-01-01T13:00:00Z", // stream.ended
+//	  "streams": [ … ],                // stream.list, streams as above
+//	  "text": "…"                      // text
+//	}
+//
+// # Signatures
+//
+// Requests are signed with a required shared secret: the X-Twiddler-Signature header is "sha256="
+// followed by a hex-encoded HMAC-SHA256 of the X-Twiddler-Timestamp header (Unix seconds),
+// a dot and the body. Consumers should reject stale timestamps to prevent replays.
+//
+// # Retries
+//
+// Payloads are queued per room and delivered in the background, in order, so that slow
+// consumers delay neither announcements elsewhere nor tracking. Deliveries failing with
+// network errors, 429 or 5xx statuses are retried with exponential backoff, holding up
+// the later payloads of their rooms. Consumers should deduplicate deliveries by their IDs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// PlatformName is a name of the platform of rooms posted to by the webhook messenger.
+const PlatformName = "webhook"
+
+// SchemaVersion is the version of Payload, bumped on incompatible changes.
+const SchemaVersion = 1
+
+// Events of payloads.
+const (
+	EventLive    = "stream.live"
+	EventUpdated = "stream.updated"
+	EventEnded   = "stream.ended"
+	EventList    = "stream.list"
+	EventText    = "text"
+)
+
+const (
+	// DefaultAttempts is how many times a delivery is attempted.
+	DefaultAttempts = 5
+
+	// DefaultBackoff is a delay before the first retry, doubled with every retry.
+	DefaultBackoff = time.Second
+
+	// maxBackoff limits delays between retries.
+	maxBackoff = time.Minute
+
+	// queueSize is how many payloads may wait for delivery to a room.
+	queueSize = 256
+)
+
+// Headers of requests.
+const (
+	HeaderEvent     = "X-Twiddler-Event"
+	HeaderDelivery  = "X-Twiddler-Delivery"
+	HeaderTimestamp = "X-Twiddler-Timestamp"
+	HeaderSignature = "X-Twiddler-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// maxErrorBodySize limits how much of error responses is quoted in errors.
+const maxErrorBodySize = 1 << 10
+
+// Options of the webhook messenger.
+type Options struct {
+	// URLs map IDs of rooms to URLs their payloads are posted to.
+	URLs map[string]string
+
+	// Secret signs payloads, and is required.
+	Secret string
+
+	// Attempts and Backoff override DefaultAttempts and DefaultBackoff.
+	Attempts int
+	Backoff  time.Duration
+
+	// Client overrides http.DefaultClient.
+	Client *http.Client
+}
+
+// Payload is a JSON document posted for every event.
+type Payload struct {
+	SchemaVersion int       `json:"schema_version"`
+	Event         string    `json:"event"`
+	DeliveryID    string    `json:"delivery_id"`
+	RoomID        string    `json:"room_id"`
+	MessageID     string    `json:"message_id,omitempty"`
+	SentAt        time.Time `json:"sent_at"`
+
+	Stream  *Stream    `json:"stream,omitempty"`
+	EndedAt *time.Time `json:"ended_at,omitempty"`
+	Streams []Stream   `json:"streams,omitempty"`
+	Text    string     `json:"text,omitempty"`
+}
+
+// Stream is a JSON representation of stream.Stream.
+type Stream struct {
+	ID           string    `json:"id"`
+	Service      string    `json:"service"`
+	Title        string    `json:"title"`
+	CategoryID   string    `json:"category_id"`
+	Category     string    `json:"category"`
+	Language     string    `json:"language"`
+	Followed     bool      `json:"followed"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	StartedAt    time.Time `json:"started_at"`
+	User         User      `json:"user"`
+}
+
+// User is a JSON representation of stream.User.
+type User struct {
+	ID              string `json:"id"`
+	Service         string `json:"service"`
+	Name            string `json:"name"`
+	DisplayName     string `json:"display_name"`
+	ChannelURL      string `json:"channel_url"`
+	ProfileURL      string `json:"profile_url"`
+	PictureURL      string `json:"picture_url"`
+	OfflineImageURL string `json:"offline_image_url"`
+}
+
+// NewStream converts a stream into its JSON representation.
+func NewStream(s *stream.Stream) Stream {
+	return Stream{
+		ID:           s.ID,
+		Service:      s.Service,
+		Title:        s.Title,
+		CategoryID:   s.CategoryID,
+		Category:     s.Category,
+		Language:     s.Language,
+		Followed:     s.Followed,
+		ThumbnailURL: urlString(s.ThumbnailURL),
+		StartedAt:    s.StartedAt,
+		User: User{
+			ID:              s.User.ID,
+			Service:         s.User.Service,
+			Name:            s.User.Name,
+			DisplayName:     s.User.DisplayName,
+			ChannelURL:      urlString(s.User.ChannelURL),
+			ProfileURL:      urlString(s.User.ProfileURL),
+			PictureURL:      urlString(s.User.PictureURL),
+			OfflineImageURL: urlString(s.User.OfflineImageURL),
+		},
+	}
+}
+
+// Sign computes a signature of a payload body sent at a given timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	_ messenger.Messenger     = &Messenger{}
+	_ messenger.RoomRegistrar = &Messenger{}
+)
+
+// Messenger posts payloads to the URLs of its rooms. It receives no commands.
+type Messenger struct {
+	opts   Options
+	client *http.Client
+	// queues are payloads waiting for delivery by rooms.
+	queues map[string]chan Payload
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewMessenger(opts Options) (*Messenger, error) {
+	if opts.Secret == "" {
+		return nil, errors.New("webhook secret is required")
+	}
+
+	queues := make(map[string]chan Payload, len(opts.URLs))
+	for roomID, u := range opts.URLs {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("invalid webhook URL of room %s", roomID)
+		}
+
+		queues[roomID] = make(chan Payload, queueSize)
+	}
+
+	if opts.Attempts == 0 {
+		opts.Attempts = DefaultAttempts
+	}
+
+	if opts.Backoff == 0 {
+		opts.Backoff = DefaultBackoff
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Messenger{opts: opts, client: client, queues: queues}, nil
+}
+
+// MessageStream queues a stream.live payload, and returns its delivery ID as the message ID.
+func (m *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	data := NewStream(s)
+	p := m.payload(EventLive, roomID)
+	p.MessageID, p.Stream = p.DeliveryID, &data
+
+	if err := m.queue(p); err != nil {
+		return "", err
+	}
+
+	return p.DeliveryID, nil
+}
+
+func (m *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	data := NewStream(s)
+	p := m.payload(EventUpdated, roomID)
+	p.MessageID, p.Stream = messageID, &data
+
+	return m.queue(p)
+}
+
+func (m *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	data := NewStream(s)
+	p := m.payload(EventEnded, roomID)
+	p.MessageID, p.Stream, p.EndedAt = messageID, &data, &endedAt
+
+	return m.queue(p)
+}
+
+func (m *Messenger) MessageStreamList(c context.Context, roomID string, ss []stream.Stream) error {
+	p := m.payload(EventList, roomID)
+	p.Streams = make([]Stream, len(ss))
+	for i := range ss {
+		p.Streams[i] = NewStream(&ss[i])
+	}
+
+	return m.queue(p)
+}
+
+func (m *Messenger) MessageText(c context.Context, roomID, text string) error {
+	p := m.payload(EventText, roomID)
+	p.Text = text
+
+	return m.queue(p)
+}
+
+// AddCommandHandler does nothing, as commands are never received over webhooks.
+func (m *Messenger) AddCommandHandler(c context.Context, h messenger.Handler) {
+}
+
+// RegisterRooms adds the rooms of the configured URLs, as there's no other way to add them.
+// They are added on every start, so they are removed by removing their URLs.
+func (m *Messenger) RegisterRooms(c context.Context) error {
+	conn := db.FromContext(c)
+	platform := messenger.PlatformFromContext(c)
+	for roomID := range m.opts.URLs {
+		_, err := conn.ExecContext(c, `INSERT OR IGNORE INTO [rooms] ([room_id], [platform]) VALUES (?, ?)`, roomID, platform)
+		if err != nil {
+			return fmt.Errorf("failed to register webhook room %s: %w", roomID, err)
+		}
+	}
+
+	return nil
+}
+
+// Run starts delivering the payloads queued for the rooms.
+func (m *Messenger) Run() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	for roomID, queue := range m.queues {
+		m.wg.Add(1)
+		go m.deliverAll(c, roomID, queue)
+	}
+
+	return nil
+}
+
+// Close stops delivering payloads, dropping the ones still queued.
+func (m *Messenger) Close() error {
+	m.mu.Lock()
+	cancel := m.cancel
+	m.cancel = nil
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		m.wg.Wait()
+	}
+
+	return nil
+}
+
+func (m *Messenger) payload(event, roomID string) Payload {
+	return Payload{
+		SchemaVersion: SchemaVersion,
+		Event:         event,
+		DeliveryID:    newID(),
+		RoomID:        roomID,
+		SentAt:        clock.NowUTC(),
+	}
+}
+
+// permanentError is a failure of a delivery that retries can't fix.
+type permanentError struct {
+	error
+}
+
+// queue queues a payload for delivery to its room.
+func (m *Messenger) queue(p Payload) error {
+	queue, exists := m.queues[p.RoomID]
+	if !exists {
+		return fmt.Errorf("no webhook URL configured for room %s", p.RoomID)
+	}
+
+	select {
+	case queue <- p:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue of room %s is full", p.RoomID)
+	}
+}
+
+// deliverAll delivers payloads queued for a room one by one until c is cancelled.
+func (m *Messenger) deliverAll(c context.Context, roomID string, queue <-chan Payload) {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case p := <-queue:
+			if err := m.deliver(c, p); err != nil && c.Err() == nil {
+				log.Printf("Failed to deliver webhook payload: %s", err)
+			}
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// deliver posts a payload to the URL of its room, retrying failures with exponential backoff.
+func (m *Messenger) deliver(c context.Context, p Payload) error {
+	u := m.opts.URLs[p.RoomID]
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	backoff := m.opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err = m.post(c, u, p, body)
+		if err == nil {
+			return nil
+		}
+
+		var permanent permanentError
+		if errors.As(err, &permanent) || attempt == m.opts.Attempts {
+			return fmt.Errorf("failed to deliver %s to room %s: %w", p.Event, p.RoomID, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.Done():
+			return c.Err()
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (m *Messenger) post(c context.Context, u string, p Payload, body []byte) error {
+	req, err := http.NewRequestWithContext(c, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+
+	timestamp := strconv.FormatInt(clock.NowUTC().Unix(), 10)
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(HeaderEvent, p.Event)
+	req.Header.Add(HeaderDelivery, p.DeliveryID)
+	req.Header.Add(HeaderTimestamp, timestamp)
+	req.Header.Add(HeaderSignature, Sign(m.opts.Secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	err = fmt.Errorf("server replied with status %s: %s", resp.Status, bytes.TrimSpace(message))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+
+	return permanentError{err}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	return u.String()
+}
@@ -0,0 +1,289 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/messenger"
+	"github.com/TeamTenuki/twiddler/messenger/webhook"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/testutil"
+)
+
+func TestMessageStreamPostsSignedPayload(t *testing.T) {
+	server := newFakeConsumer(t)
+	c, m := server.messenger(t, time.Millisecond)
+
+	messageID, err := m.MessageStream(c, "ci", newStream())
+	if err != nil {
+		t.Fatalf("MessageStream failed: %s", err)
+	}
+
+	r := server.awaitRequests(t, 1)[0]
+
+	if r.signature != webhook.Sign("secret", r.timestamp, r.body) {
+		t.Errorf("Signature %q doesn't match the body", r.signature)
+	}
+
+	if r.event != webhook.EventLive || r.delivery != messageID {
+		t.Errorf("Unexpected event %q or delivery %q", r.event, r.delivery)
+	}
+
+	var p webhook.Payload
+	if err := json.Unmarshal(r.body, &p); err != nil {
+		t.Fatalf("Failed to decode payload: %s", err)
+	}
+
+	if p.SchemaVersion != webhook.SchemaVersion || p.Event != webhook.EventLive || p.RoomID != "ci" {
+		t.Errorf("Unexpected payload %+v", p)
+	}
+
+	if p.MessageID != messageID || p.DeliveryID != messageID {
+		t.Errorf("Expected message and delivery IDs %q, got %q and %q", messageID, p.MessageID, p.DeliveryID)
+	}
+
+	expected := webhook.NewStream(newStream())
+	if p.Stream == nil || !p.Stream.StartedAt.Equal(expected.StartedAt) {
+		t.Fatalf("Unexpected stream %+v", p.Stream)
+	}
+
+	p.Stream.StartedAt = expected.StartedAt
+	if *p.Stream != expected {
+		t.Errorf("Expected stream %+v, got %+v", expected, *p.Stream)
+	}
+}
+
+func TestDeliveryRetriesFailures(t *testing.T) {
+	server := newFakeConsumer(t)
+	server.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	c, m := server.messenger(t, time.Millisecond)
+
+	s := newStream()
+	if err := m.MessageStreamEnded(c, "ci", "message1", s, s.StartedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("MessageStreamEnded failed: %s", err)
+	}
+
+	requests := server.awaitRequests(t, 3)
+
+	for _, r := range requests[1:] {
+		if r.delivery != requests[0].delivery {
+			t.Errorf("Expected retries of delivery %q, got %q", requests[0].delivery, r.delivery)
+		}
+	}
+
+	if gap := requests[2].at.Sub(requests[1].at); gap < 2*time.Millisecond {
+		t.Errorf("Expected backoff doubled between retries, got %s", gap)
+	}
+}
+
+func TestDeliveryGivesUp(t *testing.T) {
+	server := newFakeConsumer(t)
+	server.statuses = []int{http.StatusBadRequest, 500, 500, 500}
+	c, m := server.messenger(t, time.Millisecond)
+
+	// Client errors fail deliveries at once, others after all attempts.
+	for _, text := range []string{"Hello", "Hello again", "Bye"} {
+		if err := m.MessageText(c, "ci", text); err != nil {
+			t.Fatalf("MessageText failed: %s", err)
+		}
+	}
+
+	requests := server.awaitRequests(t, 1+3+1)
+
+	var p webhook.Payload
+	json.Unmarshal(requests[4].body, &p)
+	if p.Text != "Bye" {
+		t.Errorf("Expected the last payload delivered after the failed ones, got %q", p.Text)
+	}
+}
+
+func TestDeliveryDoesNotBlockSender(t *testing.T) {
+	server := newFakeConsumer(t)
+	server.statuses = []int{http.StatusServiceUnavailable}
+	c, m := server.messenger(t, time.Hour)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := m.MessageText(c, "ci", "Hello"); err != nil {
+			t.Fatalf("MessageText failed: %s", err)
+		}
+	}
+
+	server.awaitRequests(t, 1)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected payloads queued at once, took %s", elapsed)
+	}
+
+	// Closing interrupts the backoff of the failed delivery.
+	m.Close()
+}
+
+func TestSecretIsRequired(t *testing.T) {
+	_, err := webhook.NewMessenger(webhook.Options{URLs: map[string]string{"ci": "https://example.com/hook"}})
+	if err == nil {
+		t.Errorf("Expected the messenger to require a secret")
+	}
+}
+
+func TestConfiguredRoomsAreRegistered(t *testing.T) {
+	server := newFakeConsumer(t)
+	c, _ := server.messenger(t, time.Millisecond)
+
+	// Rooms registered before, e.g. by a previous run, are left intact.
+	m, err := webhook.NewMessenger(webhook.Options{URLs: map[string]string{"ci": server.URL}, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	if err := m.RegisterRooms(messenger.NewPlatformContext(c, webhook.PlatformName)); err != nil {
+		t.Fatalf("RegisterRooms failed: %s", err)
+	}
+
+	rooms, err := db.RoomsAll(c)
+	if err != nil {
+		t.Fatalf("Failed to retrieve rooms: %s", err)
+	}
+
+	if len(rooms) != 1 || rooms[0].ID != "ci" || rooms[0].Platform != webhook.PlatformName {
+		t.Errorf("Expected room ci, got %+v", rooms)
+	}
+}
+
+//
+// HELPERS
+//
+
+type requestT struct {
+	event     string
+	delivery  string
+	timestamp string
+	signature string
+	body      []byte
+	at        time.Time
+}
+
+type fakeConsumer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []requestT
+}
+
+func newFakeConsumer(t *testing.T) *fakeConsumer {
+	t.Helper()
+
+	s := &fakeConsumer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// messenger runs a messenger posting to the consumer, in a context of a new database.
+func (s *fakeConsumer) messenger(t *testing.T, backoff time.Duration) (context.Context, *webhook.Messenger) {
+	t.Helper()
+
+	m, err := webhook.NewMessenger(webhook.Options{
+		URLs:     map[string]string{"ci": s.URL + "/hook"},
+		Secret:   "secret",
+		Attempts: 3,
+		Backoff:  backoff,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create messenger: %s", err)
+	}
+
+	c := testutil.SetupDB()
+	if err := m.RegisterRooms(messenger.NewPlatformContext(c, webhook.PlatformName)); err != nil {
+		t.Fatalf("RegisterRooms failed: %s", err)
+	}
+
+	if err := m.Run(); err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	return c, m
+}
+
+// serve replies with queued statuses, and with 204 once there are none.
+func (s *fakeConsumer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, requestT{
+		event:     r.Header.Get(webhook.HeaderEvent),
+		delivery:  r.Header.Get(webhook.HeaderDelivery),
+		timestamp: r.Header.Get(webhook.HeaderTimestamp),
+		signature: r.Header.Get(webhook.HeaderSignature),
+		body:      body,
+		at:        time.Now(),
+	})
+
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+// awaitRequests waits for n requests to be received, and fails if there are more.
+func (s *fakeConsumer) awaitRequests(t *testing.T, n int) []requestT {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		requests := append([]requestT(nil), s.requests...)
+		s.mu.Unlock()
+
+		if len(requests) > n {
+			t.Fatalf("Expected %d requests, got %d", n, len(requests))
+		}
+
+		if len(requests) == n {
+			return requests
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d requests, got %d", n, len(requests))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func newStream() *stream.Stream {
+	channelURL, _ := url.Parse("https://www.twitch.tv/sensei")
+	thumbnailURL, _ := url.Parse("https://example.com/thumbnail.jpg")
+
+	return &stream.Stream{
+		ID:           "stream1",
+		Service:      "twitch",
+		Title:        "Go lessons",
+		CategoryID:   "1469308723",
+		Category:     "Go",
+		Language:     "en",
+		ThumbnailURL: thumbnailURL,
+		User: stream.User{
+			ID:          "user1",
+			Service:     "twitch",
+			Name:        "sensei",
+			DisplayName: "Go_Sensei",
+			ChannelURL:  channelURL,
+		},
+		StartedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+)
+
+// UserCache stores user profiles, so that fetchers don't have to look them up on every fetch.
+type UserCache interface {
+	// User yields a cached profile of a user with a given ID. The second return value
+	// reports whether the profile was found and hasn't expired yet.
+	User(c context.Context, userID string) (User, bool, error)
+
+	// StoreUser stores a user profile, replacing a previously cached one.
+	StoreUser(c context.Context, u User) error
+
+	// InvalidateUser removes a profile of a user with a given ID, so that it
+	// is looked up again on the next fetch.
+	InvalidateUser(c context.Context, userID string) error
+}
+
+// NewMemoryUserCache returns an in-memory UserCache, which keeps profiles for a given duration.
+func NewMemoryUserCache(ttl time.Duration) UserCache {
+	return &memoryUserCacheT{
+		ttl:     ttl,
+		entries: make(map[string]entryT),
+	}
+}
+
+type memoryUserCacheT struct {
+	ttl     time.Duration
+	entries map[string]entryT
+	mu      sync.Mutex
+}
+
+func (m *memoryUserCacheT) User(c context.Context, userID string) (User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[userID]
+	if !exists || entry.expired() {
+		return User{}, false, nil
+	}
+
+	return entry.user, true, nil
+}
+
+func (m *memoryUserCacheT) StoreUser(c context.Context, u User) error {
+	m.mu.Lock()
+	m.entries[u.ID] = entryT{user: u, expiresAt: clock.NowUTC().Add(m.ttl)}
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *memoryUserCacheT) InvalidateUser(c context.Context, userID string) error {
+	m.mu.Lock()
+	delete(m.entries, userID)
+	m.mu.Unlock()
+
+	return nil
+}
+
+type entryT struct {
+	user      User
+	expiresAt time.Time
+}
+
+func (e *entryT) expired() bool {
+	return !clock.NowUTC().Before(e.expiresAt)
+}
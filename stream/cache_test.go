@@ -0,0 +1,46 @@
+package stream_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+func TestMemoryUserCacheExpiry(t *testing.T) {
+	c := context.Background()
+	fixedClock := clock.OverrideByFixed(time.Now())
+	defer clock.OverrideClock(nil)
+
+	cache := stream.NewMemoryUserCache(time.Hour)
+	cache.StoreUser(c, stream.User{ID: "user1"})
+
+	if _, exists, _ := cache.User(c, "user1"); !exists {
+		t.Errorf("Expected fresh user to be cached")
+	}
+
+	fixedClock.Add(time.Hour)
+
+	if _, exists, _ := cache.User(c, "user1"); exists {
+		t.Errorf("Expected user to expire")
+	}
+}
+
+func TestMemoryUserCacheInvalidate(t *testing.T) {
+	c := context.Background()
+	cache := stream.NewMemoryUserCache(time.Hour)
+	cache.StoreUser(c, stream.User{ID: "user1"})
+	cache.StoreUser(c, stream.User{ID: "user2"})
+
+	cache.InvalidateUser(c, "user1")
+
+	if _, exists, _ := cache.User(c, "user1"); exists {
+		t.Errorf("Expected invalidated user to be dropped")
+	}
+
+	if _, exists, _ := cache.User(c, "user2"); !exists {
+		t.Errorf("Expected other users to stay cached")
+	}
+}
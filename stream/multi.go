@@ -0,0 +1,99 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Service is a named streaming service.
+type Service struct {
+	// Name of the service, e.g. "twitch". Streams and users of the service are tagged with it.
+	Name string
+
+	// Fetcher fetches streams from the service.
+	Fetcher Fetcher
+}
+
+// Multi returns a Fetcher that fetches streams from several services concurrently.
+//
+// Every stream and its user are tagged with their service, as IDs are only unique
+// within a service, so streams are told apart by (Service, ID) pairs.
+// If some of the services fail, the streams they returned last time stand in for
+// their current streams, so that their streams don't look ended. The failures are
+// logged. An error aggregating all the failures is returned only when some of the
+// failed services haven't returned streams yet.
+func Multi(services ...Service) Fetcher {
+	return &multiT{
+		services: services,
+		latest:   make([][]Stream, len(services)),
+	}
+}
+
+type multiT struct {
+	services []Service
+
+	mu     sync.Mutex
+	latest [][]Stream
+}
+
+func (m *multiT) Fetch(c context.Context) ([]Stream, error) {
+	results := make([][]Stream, len(m.services))
+	errs := make([]error, len(m.services))
+
+	var wg sync.WaitGroup
+	for i := range m.services {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			service := m.services[i]
+
+			ss, err := service.Fetcher.Fetch(c)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", service.Name, err)
+				return
+			}
+
+			// Fetchers may hand out the same slice more than once, don't modify it in place.
+			tagged := make([]Stream, len(ss))
+			for j, s := range ss {
+				s.Service = service.Name
+				s.User.Service = service.Name
+				tagged[j] = s
+			}
+
+			results[i] = tagged
+		}(i)
+	}
+
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss := make([]Stream, 0)
+	unknown := make([]error, 0)
+
+	for i := range results {
+		if errs[i] == nil {
+			m.latest[i] = results[i]
+		} else if m.latest[i] != nil {
+			log.Printf("Failed to fetch streams, keeping the last known ones: %s", errs[i])
+		} else {
+			unknown = append(unknown, errs[i])
+			continue
+		}
+
+		ss = append(ss, m.latest[i]...)
+	}
+
+	if len(unknown) > 0 {
+		return nil, errors.Join(unknown...)
+	}
+
+	return ss, nil
+}
@@ -0,0 +1,150 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+type fetcherFunc func(c context.Context) ([]stream.Stream, error)
+
+func (f fetcherFunc) Fetch(c context.Context) ([]stream.Stream, error) {
+	return f(c)
+}
+
+func streamsOf(ids ...string) fetcherFunc {
+	return func(c context.Context) ([]stream.Stream, error) {
+		ss := make([]stream.Stream, len(ids))
+		for i := range ids {
+			ss[i] = stream.Stream{ID: ids[i], User: stream.User{ID: "user-" + ids[i]}}
+		}
+
+		return ss, nil
+	}
+}
+
+func failing(err error) fetcherFunc {
+	return func(c context.Context) ([]stream.Stream, error) {
+		return nil, err
+	}
+}
+
+func TestMultiTagsServices(t *testing.T) {
+	f := stream.Multi(
+		stream.Service{Name: "twitch", Fetcher: streamsOf("1")},
+		stream.Service{Name: "youtube", Fetcher: streamsOf("1")},
+	)
+
+	ss, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	if len(ss) != 2 {
+		t.Fatalf("Expected 2 streams, got %d", len(ss))
+	}
+
+	services := make(map[string]bool)
+	for _, s := range ss {
+		// IDs are left intact, so that they match the ones stored before other services were added.
+		if s.ID != "1" || s.User.ID != "user-1" {
+			t.Errorf("Unexpected stream %q of user %q", s.ID, s.User.ID)
+		}
+
+		if s.User.Service != s.Service {
+			t.Errorf("Expected user of service %q, got %q", s.Service, s.User.Service)
+		}
+
+		services[s.Service] = true
+	}
+
+	if !services["twitch"] || !services["youtube"] {
+		t.Errorf("Expected streams of both services, got %v", services)
+	}
+}
+
+func TestMultiKeepsStreamsOfFailedServices(t *testing.T) {
+	boom := errors.New("boom")
+	fails := false
+
+	f := stream.Multi(
+		stream.Service{Name: "twitch", Fetcher: streamsOf("1", "2")},
+		stream.Service{Name: "youtube", Fetcher: fetcherFunc(func(c context.Context) ([]stream.Stream, error) {
+			if fails {
+				return nil, boom
+			}
+
+			return streamsOf("3")(c)
+		})},
+	)
+
+	for i := 0; i < 2; i++ {
+		ss, err := f.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+
+		// The last known stream of youtube stands in for the failed fetch.
+		if len(ss) != 3 || ss[2].ID != "3" || ss[2].Service != "youtube" {
+			t.Errorf("Expected streams of both services, got %v", ss)
+		}
+
+		fails = true
+	}
+}
+
+func TestMultiFailsUntilServicesSucceed(t *testing.T) {
+	boom := errors.New("boom")
+
+	f := stream.Multi(
+		stream.Service{Name: "twitch", Fetcher: streamsOf("1", "2")},
+		stream.Service{Name: "youtube", Fetcher: failing(boom)},
+	)
+
+	ss, err := f.Fetch(context.Background())
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "youtube") {
+		t.Errorf("Expected aggregated youtube error, got %v", err)
+	}
+
+	// Streams of youtube are unknown, so the snapshot would be partial.
+	if ss != nil {
+		t.Errorf("Expected no streams, got %v", ss)
+	}
+}
+
+func TestMultiFailsWhenAllServicesFail(t *testing.T) {
+	f := stream.Multi(
+		stream.Service{Name: "twitch", Fetcher: failing(errors.New("boom1"))},
+		stream.Service{Name: "youtube", Fetcher: failing(errors.New("boom2"))},
+	)
+
+	ss, err := f.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "boom1") || !strings.Contains(err.Error(), "boom2") {
+		t.Errorf("Expected both errors, got %v", err)
+	}
+
+	if ss != nil {
+		t.Errorf("Expected no streams, got %v", ss)
+	}
+}
+
+func TestMultiDoesNotModifyFetchedStreams(t *testing.T) {
+	shared := []stream.Stream{{ID: "1"}}
+	f := stream.Multi(stream.Service{Name: "youtube", Fetcher: fetcherFunc(
+		func(c context.Context) ([]stream.Stream, error) { return shared, nil },
+	)})
+
+	for i := 0; i < 2; i++ {
+		ss, _ := f.Fetch(context.Background())
+		if ss[0].ID != "1" || ss[0].Service != "youtube" {
+			t.Errorf("Expected stream %q of youtube, got %q of %q", "1", ss[0].ID, ss[0].Service)
+		}
+	}
+
+	if shared[0].Service != "" {
+		t.Errorf("Expected fetched streams to be left intact, got service %q", shared[0].Service)
+	}
+}
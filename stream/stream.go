@@ -12,12 +12,28 @@ type Stream struct {
 	// ID is a unique identifier of this stream on a given service.
 	ID string `db:"stream_id"`
 
+	// Service is a name of the streaming service this stream comes from, e.g. "twitch".
+	Service string
+
 	// User is an information about streamer of this stream on a given service.
 	User User
 
 	// Title of this stream.
 	Title string
 
+	// CategoryID is a unique identifier of this stream's category (game) on a given service.
+	CategoryID string
+
+	// Category is a human readable name of this stream's category (game).
+	Category string
+
+	// Language is an ISO 639-1 code of this stream's language, e.g. "en".
+	Language string
+
+	// Followed tells that this stream was fetched only because its streamer is followed,
+	// and not because of its category.
+	Followed bool
+
 	// ThumbnailURL of this stream.
 	ThumbnailURL *url.URL
 
@@ -31,6 +47,9 @@ type User struct {
 	// ID is a unique identifier of a user on a given service.
 	ID string
 
+	// Service is a name of the streaming service this user comes from, e.g. "twitch".
+	Service string
+
 	// Name is a user's name on a given service.
 	Name string
 
@@ -54,5 +73,23 @@ type User struct {
 type Fetcher interface {
 	// Fetch fetches currently live streams info from a streaming service.
 	// Implementation should honour context cancellation.
+	//
+	// Streams are a full snapshot of live streams, so implementation
+	// must not return some of them when it fails to fetch the rest.
 	Fetch(c context.Context) ([]Stream, error)
 }
+
+// Batches splits ss into consecutive slices of at most n elements.
+func Batches(ss []string, n int) [][]string {
+	bs := make([][]string, 0, (len(ss)+n-1)/n)
+	for len(ss) > n {
+		bs = append(bs, ss[:n])
+		ss = ss[n:]
+	}
+
+	if len(ss) > 0 {
+		bs = append(bs, ss)
+	}
+
+	return bs
+}
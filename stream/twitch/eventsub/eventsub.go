@@ -0,0 +1,403 @@
+// Package eventsub implements watchers driven by Twitch EventSub notifications.
+package eventsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+const (
+	// DefaultReconcile is a default interval between reconciliations of EventSub state with Helix.
+	DefaultReconcile = 5 * time.Minute
+
+	// pendingRetry is an interval between attempts to fetch streams that went online
+	// according to EventSub, but aren't yet visible through Helix.
+	pendingRetry = 15 * time.Second
+
+	// pendingTimeout is how long such streams are retried before giving up on them
+	// until the next reconciliation.
+	pendingTimeout = 10 * time.Minute
+
+	helixURL = "https://api.twitch.tv/helix"
+)
+
+// Fetcher knows how to fetch live streams of particular streamers.
+// It is implemented by twitch.Fetcher.
+type Fetcher interface {
+	FetchBroadcasters(c context.Context, userIDs []string) ([]stream.Stream, error)
+}
+
+// Resolver is a Fetcher that can also resolve logins of streamers into user IDs.
+// It is implemented by twitch.Fetcher.
+type Resolver interface {
+	Fetcher
+	// UserIDs yields user IDs of existing streamers keyed by their logins in lower case.
+	UserIDs(c context.Context, logins []string) (map[string]string, error)
+}
+
+// ResolveBroadcasters resolves logins of streamers into user IDs, retrying failures
+// until it succeeds or c is cancelled. Logins of streamers that don't exist are skipped
+// with a warning, and it fails if none of them exist.
+func ResolveBroadcasters(c context.Context, r Resolver, logins []string) ([]string, error) {
+	for {
+		found, err := r.UserIDs(c, logins)
+		if err == nil {
+			return broadcasterIDs(logins, found)
+		}
+
+		log.Printf("Failed to resolve EventSub broadcasters: %s", err)
+
+		select {
+		case <-time.After(time.Minute):
+		case <-c.Done():
+			return nil, c.Err()
+		}
+	}
+}
+
+// broadcasterIDs picks user IDs of logins, which are never retried if they don't exist.
+func broadcasterIDs(logins []string, found map[string]string) ([]string, error) {
+	ids := make([]string, 0, len(logins))
+	for _, login := range logins {
+		id, exists := found[strings.ToLower(login)]
+		if !exists {
+			log.Printf("EventSub broadcaster %q doesn't exist, skipping", login)
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, errors.New("none of the EventSub broadcasters exist")
+	}
+
+	return ids, nil
+}
+
+// Notification is a payload of an EventSub notification message.
+type Notification struct {
+	Subscription Subscription    `json:"subscription"`
+	Event        json.RawMessage `json:"event"`
+}
+
+// Subscription describes an EventSub subscription.
+type Subscription struct {
+	ID        string            `json:"id,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+}
+
+// Transport describes how EventSub notifications are delivered.
+type Transport struct {
+	// Method is either "websocket" or "webhook".
+	Method string `json:"method"`
+
+	// SessionID is an ID of a WebSocket session, for the websocket method.
+	SessionID string `json:"session_id,omitempty"`
+
+	// Callback is a URL notifications are posted to, for the webhook method.
+	Callback string `json:"callback,omitempty"`
+
+	// Secret is used to sign notifications, for the webhook method.
+	Secret string `json:"secret,omitempty"`
+}
+
+// subscriptionTypes lists all the subscriptions required for every watched streamer.
+var subscriptionTypes = []struct {
+	Type    string
+	Version string
+}{
+	{"stream.online", "1"},
+	{"stream.offline", "1"},
+	{"channel.update", "2"},
+}
+
+type onlineEventT struct {
+	// ID of the stream that went online.
+	ID                string `json:"id"`
+	BroadcasterUserID string `json:"broadcaster_user_id"`
+}
+
+type offlineEventT struct {
+	BroadcasterUserID string `json:"broadcaster_user_id"`
+}
+
+type updateEventT struct {
+	BroadcasterUserID string `json:"broadcaster_user_id"`
+	Title             string `json:"title"`
+	CategoryID        string `json:"category_id"`
+	CategoryName      string `json:"category_name"`
+}
+
+// Client creates EventSub subscriptions.
+type Client struct {
+	// HelixURL overrides the base URL of the Helix API. Meant for testing.
+	HelixURL string
+
+	// ClientID of the application.
+	ClientID string
+
+	// TokenSource provides access tokens. WebSocket subscriptions require user access
+	// tokens, while webhook subscriptions require app access tokens.
+	TokenSource oauth2.TokenSource
+}
+
+// Subscribe subscribes to all the notifications required to track live streams
+// of given streamers. Subscriptions that already exist are left intact.
+func (cl *Client) Subscribe(c context.Context, broadcasters []string, t Transport) error {
+	for _, b := range broadcasters {
+		for _, st := range subscriptionTypes {
+			err := cl.subscribe(c, Subscription{
+				Type:      st.Type,
+				Version:   st.Version,
+				Condition: map[string]string{"broadcaster_user_id": b},
+				Transport: t,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to subscribe to %s of %s: %w", st.Type, b, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cl *Client) subscribe(c context.Context, s Subscription) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	u := cl.HelixURL
+	if u == "" {
+		u = helixURL
+	}
+
+	req, err := http.NewRequestWithContext(c, "POST", u+"/eventsub/subscriptions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	token, err := cl.TokenSource.Token()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Client-ID", cl.ClientID)
+	req.Header.Add("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusConflict:
+		return nil
+	}
+
+	return fmt.Errorf("failed to subscribe, server replied with status %s", resp.Status)
+}
+
+// Live keeps track of live streams of a set of streamers driven by EventSub notifications.
+// The state is periodically reconciled with Helix to catch up on missed notifications.
+// Every change of the state is published on Source as a full stream list, just like
+// watcher.Periodic does, so Live is meant to be embedded into watcher.Watcher implementations.
+type Live struct {
+	f             Fetcher
+	reconcile     time.Duration
+	broadcasters  []string
+	streams       map[string]stream.Stream
+	pending       map[string]time.Time
+	notifications chan Notification
+	out           chan []stream.Stream
+}
+
+// NewLive returns a Live which reconciles its state with Helix every reconcile interval
+// (DefaultReconcile if zero).
+func NewLive(f Fetcher, reconcile time.Duration) *Live {
+	if reconcile <= 0 {
+		reconcile = DefaultReconcile
+	}
+
+	return &Live{
+		f:             f,
+		reconcile:     reconcile,
+		streams:       make(map[string]stream.Stream),
+		pending:       make(map[string]time.Time),
+		notifications: make(chan Notification, 64),
+		out:           make(chan []stream.Stream),
+	}
+}
+
+// Run processes notifications about given streamers (by user ID) until c is cancelled.
+// The Source channel is closed once Run returns.
+func (l *Live) Run(c context.Context, broadcasters []string) {
+	defer close(l.out)
+
+	l.broadcasters = broadcasters
+	l.reconcileState(c)
+
+	reconcile := time.NewTicker(l.reconcile)
+	defer reconcile.Stop()
+
+	retry := time.NewTicker(pendingRetry)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-reconcile.C:
+			l.reconcileState(c)
+		case <-retry.C:
+			l.fetchPending(c)
+		case n := <-l.notifications:
+			l.handle(c, n)
+		case <-c.Done():
+			return
+		}
+	}
+}
+
+// Notify queues a notification to be applied to the state.
+func (l *Live) Notify(c context.Context, n Notification) {
+	select {
+	case l.notifications <- n:
+	case <-c.Done():
+	}
+}
+
+// Close closes the Source channel of a Live that is never going to run.
+func (l *Live) Close() {
+	close(l.out)
+}
+
+// Source returns a channel of stream lists, which receives a new value on every change.
+func (l *Live) Source() <-chan []stream.Stream {
+	return l.out
+}
+
+func (l *Live) handle(c context.Context, n Notification) {
+	switch n.Subscription.Type {
+	case "stream.online":
+		var e onlineEventT
+		if err := json.Unmarshal(n.Event, &e); err != nil {
+			log.Printf("Failed to decode stream.online event: %s", err)
+			return
+		}
+
+		l.pending[e.BroadcasterUserID] = clock.NowUTC()
+		l.fetchPending(c)
+
+	case "stream.offline":
+		var e offlineEventT
+		if err := json.Unmarshal(n.Event, &e); err != nil {
+			log.Printf("Failed to decode stream.offline event: %s", err)
+			return
+		}
+
+		delete(l.pending, e.BroadcasterUserID)
+		delete(l.streams, e.BroadcasterUserID)
+		l.emit(c)
+
+	case "channel.update":
+		var e updateEventT
+		if err := json.Unmarshal(n.Event, &e); err != nil {
+			log.Printf("Failed to decode channel.update event: %s", err)
+			return
+		}
+
+		s, live := l.streams[e.BroadcasterUserID]
+		if !live {
+			return
+		}
+
+		s.Title = e.Title
+		s.CategoryID = e.CategoryID
+		s.Category = e.CategoryName
+		l.streams[e.BroadcasterUserID] = s
+		l.emit(c)
+	}
+}
+
+// fetchPending fetches streams that went online, but weren't seen through Helix yet.
+func (l *Live) fetchPending(c context.Context) {
+	if len(l.pending) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(l.pending))
+	for id, since := range l.pending {
+		if clock.Since(since) > pendingTimeout {
+			delete(l.pending, id)
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	ss, err := l.f.FetchBroadcasters(c, ids)
+	if err != nil {
+		log.Printf("Failed to fetch streams: %s", err)
+		return
+	}
+
+	if len(ss) == 0 {
+		return
+	}
+
+	for _, s := range ss {
+		delete(l.pending, s.User.ID)
+		l.streams[s.User.ID] = s
+	}
+
+	l.emit(c)
+}
+
+func (l *Live) reconcileState(c context.Context) {
+	ss, err := l.f.FetchBroadcasters(c, l.broadcasters)
+	if err != nil {
+		log.Printf("Failed to reconcile live streams: %s", err)
+		return
+	}
+
+	l.streams = make(map[string]stream.Stream, len(ss))
+	for _, s := range ss {
+		delete(l.pending, s.User.ID)
+		l.streams[s.User.ID] = s
+	}
+
+	l.emit(c)
+}
+
+func (l *Live) emit(c context.Context) {
+	ss := make([]stream.Stream, 0, len(l.streams))
+	for _, s := range l.streams {
+		ss = append(ss, s)
+	}
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].User.ID < ss[j].User.ID })
+
+	select {
+	case l.out <- ss:
+	case <-c.Done():
+	}
+}
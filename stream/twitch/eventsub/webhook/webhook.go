@@ -0,0 +1,311 @@
+// Package webhook receives Twitch EventSub notifications over the webhook transport.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub"
+	"github.com/TeamTenuki/twiddler/watcher"
+)
+
+// MaxAge is how old a message may be before it is rejected as a replay.
+const MaxAge = 10 * time.Minute
+
+// maxBodySize limits the size of accepted messages.
+const maxBodySize = 1 << 20
+
+const (
+	// minBackoff and maxBackoff limit delays between attempts to subscribe.
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// resubscribe is an interval between renewals of subscriptions.
+	resubscribe = 30 * time.Minute
+)
+
+const (
+	headerMessageID   = "Twitch-Eventsub-Message-Id"
+	headerTimestamp   = "Twitch-Eventsub-Message-Timestamp"
+	headerSignature   = "Twitch-Eventsub-Message-Signature"
+	headerMessageType = "Twitch-Eventsub-Message-Type"
+)
+
+var (
+	ErrBadSignature = errors.New("message signature mismatch")
+	ErrStale        = errors.New("message is too old")
+)
+
+// Notifier consumes EventSub notifications. It is implemented by eventsub.Live.
+type Notifier interface {
+	Notify(c context.Context, n eventsub.Notification)
+}
+
+// Handler is an http.Handler that accepts EventSub webhook callbacks, verifies them
+// and passes notifications on to a Notifier. Every message is processed at most once.
+type Handler struct {
+	secret []byte
+	n      Notifier
+	seen   map[string]time.Time
+	seenMu sync.Mutex
+}
+
+// NewHandler returns a Handler verifying messages with a given secret,
+// which has to match the secret the subscriptions were created with.
+func NewHandler(secret string, n Notifier) *Handler {
+	return &Handler{
+		secret: []byte(secret),
+		n:      n,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	messageID := r.Header.Get(headerMessageID)
+
+	if err := h.verify(r.Header, body); err != nil {
+		log.Printf("Rejected EventSub message %q: %s", messageID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Twitch retries deliveries it considers failed, acknowledge duplicates without processing.
+	if !h.firstSeen(messageID) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch r.Header.Get(headerMessageType) {
+	case "webhook_callback_verification":
+		var v struct {
+			Challenge string `json:"challenge"`
+		}
+		if err := json.Unmarshal(body, &v); err != nil {
+			http.Error(w, "malformed challenge", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, v.Challenge)
+
+	case "notification":
+		var n eventsub.Notification
+		if err := json.Unmarshal(body, &n); err != nil {
+			http.Error(w, "malformed notification", http.StatusBadRequest)
+			return
+		}
+
+		h.n.Notify(r.Context(), n)
+		w.WriteHeader(http.StatusNoContent)
+
+	case "revocation":
+		var n eventsub.Notification
+		if err := json.Unmarshal(body, &n); err == nil {
+			log.Printf("EventSub subscription %s for %v revoked: %s",
+				n.Subscription.Type, n.Subscription.Condition, n.Subscription.Status)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verify checks the message signature and rejects messages older than MaxAge.
+func (h *Handler) verify(header http.Header, body []byte) error {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(header.Get(headerMessageID)))
+	mac.Write([]byte(header.Get(headerTimestamp)))
+	mac.Write(body)
+
+	signature, found := strings.CutPrefix(header.Get(headerSignature), "sha256=")
+	if !found {
+		return ErrBadSignature
+	}
+
+	decoded, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, mac.Sum(nil)) {
+		return ErrBadSignature
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, header.Get(headerTimestamp))
+	if err != nil {
+		return err
+	}
+
+	if clock.Since(timestamp) > MaxAge {
+		return ErrStale
+	}
+
+	return nil
+}
+
+// firstSeen records a message ID and reports whether it wasn't seen before.
+// IDs are forgotten after MaxAge, since older messages are rejected anyway.
+func (h *Handler) firstSeen(messageID string) bool {
+	h.seenMu.Lock()
+	defer h.seenMu.Unlock()
+
+	now := clock.NowUTC()
+	for id, at := range h.seen {
+		if now.Sub(at) > MaxAge {
+			delete(h.seen, id)
+		}
+	}
+
+	if _, seen := h.seen[messageID]; seen {
+		return false
+	}
+
+	h.seen[messageID] = now
+
+	return true
+}
+
+// Options control webhook Watcher.
+type Options struct {
+	// Broadcasters is a list of logins of streamers to watch.
+	Broadcasters []string
+
+	// Reconcile is an interval between reconciliations with Helix
+	// (eventsub.DefaultReconcile if zero).
+	Reconcile time.Duration
+
+	// Callback is a public HTTPS URL Twitch delivers notifications to.
+	Callback string
+
+	// Secret is used to sign notifications, 10 to 100 characters long.
+	Secret string
+
+	// Addr is a TCP address to serve the Handler at. When it is empty,
+	// serving Handler is left to the caller.
+	Addr string
+}
+
+var _ watcher.Watcher = &Watcher{}
+
+// Watcher is a watcher that receives EventSub notifications over the webhook transport.
+type Watcher struct {
+	r      eventsub.Resolver
+	client *eventsub.Client
+	opts   Options
+	live   *eventsub.Live
+	h      *Handler
+}
+
+// NewWatcher returns a webhook Watcher. The client has to be configured with
+// an app access token, as required by the webhook transport.
+func NewWatcher(r eventsub.Resolver, client *eventsub.Client, opts Options) *Watcher {
+	live := eventsub.NewLive(r, opts.Reconcile)
+
+	return &Watcher{
+		r:      r,
+		client: client,
+		opts:   opts,
+		live:   live,
+		h:      NewHandler(opts.Secret, live),
+	}
+}
+
+// Handler returns the http.Handler that has to receive callbacks at the Callback URL.
+func (w *Watcher) Handler() http.Handler {
+	return w.h
+}
+
+func (w *Watcher) Source() <-chan []stream.Stream {
+	return w.live.Source()
+}
+
+func (w *Watcher) Watch(c context.Context) error {
+	broadcasters, err := eventsub.ResolveBroadcasters(c, w.r, w.opts.Broadcasters)
+	if err != nil {
+		// Nothing is ever going to be watched, consumers mustn't wait for it.
+		w.live.Close()
+		return err
+	}
+
+	if w.opts.Addr != "" {
+		// Twitch verifies the callback right away while subscribing,
+		// so the port has to be bound before that.
+		l, err := net.Listen("tcp", w.opts.Addr)
+		if err != nil {
+			w.live.Close()
+			return err
+		}
+
+		server := &http.Server{Handler: w.h}
+
+		go func() {
+			<-c.Done()
+			server.Close()
+		}()
+
+		go func() {
+			if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("EventSub webhook server failed: %s", err)
+			}
+		}()
+	}
+
+	go w.live.Run(c, broadcasters)
+
+	w.subscribe(c, broadcasters)
+
+	return nil
+}
+
+// subscribe subscribes to notifications until c is cancelled, retrying failures with
+// exponential backoff. Subscriptions are renewed every resubscribe interval, as the ones
+// that failed verification or got revoked are dropped by Twitch, while existing ones are left intact.
+func (w *Watcher) subscribe(c context.Context, broadcasters []string) {
+	backoff := minBackoff
+	for {
+		err := w.client.Subscribe(c, broadcasters, eventsub.Transport{
+			Method:   "webhook",
+			Callback: w.opts.Callback,
+			Secret:   w.opts.Secret,
+		})
+
+		wait := resubscribe
+		if err != nil {
+			log.Printf("Failed to subscribe to EventSub notifications: %s", err)
+
+			wait = backoff
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			backoff = minBackoff
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-c.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub/webhook"
+)
+
+const secret = "s3cre7-s3cre7"
+
+const onlineFixture = `{
+	"subscription": {"id": "sub1", "type": "stream.online", "version": "1", "status": "enabled",
+		"condition": {"broadcaster_user_id": "1337"},
+		"transport": {"method": "webhook", "callback": "https://example.com/webhook"}},
+	"event": {"id": "9001", "broadcaster_user_id": "1337", "broadcaster_user_login": "cool_user",
+		"broadcaster_user_name": "Cool_User", "type": "live", "started_at": "2020-10-11T10:11:12.123Z"}
+}`
+
+func TestCallbackVerification(t *testing.T) {
+	server, _ := newServer(t)
+
+	resp := post(t, server, "msg1", "webhook_callback_verification", time.Now(),
+		`{"challenge": "pogchamp-kappa-360noscope-vohiyo", "subscription": {}}`, secret)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %s", resp.Status)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "pogchamp-kappa-360noscope-vohiyo" {
+		t.Errorf("Expected the challenge in response, got %q", body)
+	}
+}
+
+func TestNotificationIsForwarded(t *testing.T) {
+	server, n := newServer(t)
+
+	resp := post(t, server, "msg1", "notification", time.Now(), onlineFixture, secret)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %s", resp.Status)
+	}
+
+	n.expect(t, "stream.online")
+}
+
+func TestBadSignatureIsRejected(t *testing.T) {
+	server, n := newServer(t)
+
+	resp := post(t, server, "msg1", "notification", time.Now(), onlineFixture, "wrong-secret")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %s", resp.Status)
+	}
+
+	n.expect(t)
+}
+
+func TestTamperedBodyIsRejected(t *testing.T) {
+	server, n := newServer(t)
+
+	req := signedRequest(t, server.URL, "msg1", "notification", time.Now(), onlineFixture, secret)
+	req.Body = io.NopCloser(strings.NewReader(strings.Replace(onlineFixture, "1337", "1338", -1)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %s", resp.Status)
+	}
+
+	n.expect(t)
+}
+
+func TestReplayIsRejected(t *testing.T) {
+	server, n := newServer(t)
+
+	resp := post(t, server, "msg1", "notification", time.Now().Add(-11*time.Minute), onlineFixture, secret)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %s", resp.Status)
+	}
+
+	n.expect(t)
+}
+
+func TestDuplicateIsProcessedOnce(t *testing.T) {
+	server, n := newServer(t)
+
+	for i := 0; i < 3; i++ {
+		resp := post(t, server, "msg1", "notification", time.Now(), onlineFixture, secret)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %s", resp.Status)
+		}
+	}
+
+	n.expect(t, "stream.online")
+}
+
+func TestWatcherServesCallbackAndRetriesSubscriptions(t *testing.T) {
+	callback := "http://" + freeAddr(t)
+	helix := newFakeHelix(t, callback)
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := webhook.NewWatcher(fakeResolver{}, &eventsub.Client{
+		ClientID:    "client",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		HelixURL:    helix.URL,
+	}, webhook.Options{
+		Broadcasters: []string{"login1"},
+		Callback:     callback,
+		Secret:       secret,
+		Addr:         strings.TrimPrefix(callback, "http://"),
+	})
+	go w.Watch(c)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if helix.verified() == 3 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected 3 verified subscriptions, got %d", helix.verified())
+}
+
+//
+// HELPERS
+//
+
+type fakeResolver struct{}
+
+func (fakeResolver) FetchBroadcasters(c context.Context, userIDs []string) ([]stream.Stream, error) {
+	return nil, nil
+}
+
+func (fakeResolver) UserIDs(c context.Context, logins []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	for _, login := range logins {
+		ids[login] = "id-" + login
+	}
+
+	return ids, nil
+}
+
+// fakeHelix verifies the callback on every subscription like Twitch does,
+// failing the first subscription request.
+type fakeHelix struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests int
+	verifies int
+}
+
+func newFakeHelix(t *testing.T, callback string) *fakeHelix {
+	h := &fakeHelix{}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.requests++
+		first := h.requests == 1
+		h.mu.Unlock()
+
+		if first {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		id := fmt.Sprintf("verify%d", time.Now().UnixNano())
+		req := signedRequest(t, callback, id, "webhook_callback_verification", time.Now(),
+			`{"challenge": "challenge", "subscription": {}}`, secret)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			http.Error(w, "callback unreachable", http.StatusBadRequest)
+			return
+		}
+		defer resp.Body.Close()
+
+		if challenge, _ := io.ReadAll(resp.Body); string(challenge) != "challenge" {
+			http.Error(w, "verification failed", http.StatusBadRequest)
+			return
+		}
+
+		h.mu.Lock()
+		h.verifies++
+		h.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+func (h *fakeHelix) verified() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.verifies
+}
+
+// freeAddr yields a local address with a port that is free at the moment.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+type recorder struct {
+	mu            sync.Mutex
+	notifications []eventsub.Notification
+}
+
+func (r *recorder) Notify(c context.Context, n eventsub.Notification) {
+	r.mu.Lock()
+	r.notifications = append(r.notifications, n)
+	r.mu.Unlock()
+}
+
+func (r *recorder) expect(t *testing.T, types ...string) {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.notifications) != len(types) {
+		t.Fatalf("Expected %d notifications, got %d", len(types), len(r.notifications))
+	}
+
+	for i := range types {
+		if r.notifications[i].Subscription.Type != types[i] {
+			t.Errorf("Expected %q notification, got %q", types[i], r.notifications[i].Subscription.Type)
+		}
+	}
+}
+
+func newServer(t *testing.T) (*httptest.Server, *recorder) {
+	t.Helper()
+
+	r := &recorder{}
+	server := httptest.NewServer(webhook.NewHandler(secret, r))
+	t.Cleanup(server.Close)
+
+	return server, r
+}
+
+func signedRequest(
+	t *testing.T,
+	u string,
+	id, typ string,
+	at time.Time,
+	body, key string,
+) *http.Request {
+	t.Helper()
+
+	timestamp := at.UTC().Format(time.RFC3339Nano)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id + timestamp + body))
+
+	req, err := http.NewRequest("POST", u, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err)
+	}
+
+	req.Header.Set("Twitch-Eventsub-Message-Id", id)
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Twitch-Eventsub-Message-Type", typ)
+
+	return req
+}
+
+func post(t *testing.T, server *httptest.Server, id, typ string, at time.Time, body, key string) *http.Response {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(signedRequest(t, server.URL, id, typ, at, body, key))
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
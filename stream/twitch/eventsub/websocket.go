@@ -0,0 +1,250 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/watcher"
+)
+
+// DefaultWebSocketURL is an URL of the Twitch EventSub WebSocket server.
+const DefaultWebSocketURL = "wss://eventsub.wss.twitch.tv/ws"
+
+const (
+	// welcomeTimeout is how long the server is given to send a welcome message after connecting.
+	welcomeTimeout = 10 * time.Second
+
+	// maxBackoff limits delays between reconnection attempts.
+	maxBackoff = time.Minute
+)
+
+// WebSocketOptions control WebSocket watcher.
+type WebSocketOptions struct {
+	// Broadcasters is a list of logins of streamers to watch.
+	Broadcasters []string
+
+	// Reconcile is an interval between reconciliations with Helix (DefaultReconcile if zero).
+	Reconcile time.Duration
+
+	// URL overrides the EventSub WebSocket server URL. Meant for testing.
+	URL string
+}
+
+var _ watcher.Watcher = &WebSocket{}
+
+// WebSocket is a watcher that receives EventSub notifications over the WebSocket transport.
+type WebSocket struct {
+	r      Resolver
+	client *Client
+	opts   WebSocketOptions
+	live   *Live
+}
+
+// NewWebSocket returns a WebSocket watcher. The client has to be configured
+// with a user access token, as required by the WebSocket transport.
+func NewWebSocket(r Resolver, client *Client, opts WebSocketOptions) *WebSocket {
+	if opts.URL == "" {
+		opts.URL = DefaultWebSocketURL
+	}
+
+	return &WebSocket{
+		r:      r,
+		client: client,
+		opts:   opts,
+		live:   NewLive(r, opts.Reconcile),
+	}
+}
+
+func (w *WebSocket) Source() <-chan []stream.Stream {
+	return w.live.Source()
+}
+
+func (w *WebSocket) Watch(c context.Context) error {
+	broadcasters, err := ResolveBroadcasters(c, w.r, w.opts.Broadcasters)
+	if err != nil {
+		// Nothing is ever going to be watched, consumers mustn't wait for it.
+		w.live.Close()
+		return err
+	}
+
+	go w.live.Run(c, broadcasters)
+
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := w.session(c, broadcasters)
+		if c.Err() != nil {
+			return nil
+		}
+
+		log.Printf("EventSub session ended: %s", err)
+
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.Done():
+			return nil
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects to the server, subscribes to notifications and processes messages
+// until the connection fails. Reconnect requests from the server are followed
+// without resubscribing, as subscriptions carry over to the new connection.
+func (w *WebSocket) session(c context.Context, broadcasters []string) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// Closing a connection is the only way to interrupt a blocking read.
+	closeOnDone := func(conn *websocket.Conn) {
+		go func() {
+			select {
+			case <-c.Done():
+			case <-done:
+			}
+			conn.Close()
+		}()
+	}
+
+	conn, session, err := w.connect(c, w.opts.URL)
+	if err != nil {
+		return err
+	}
+	closeOnDone(conn)
+
+	err = w.client.Subscribe(c, broadcasters, Transport{
+		Method:    "websocket",
+		SessionID: session.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	keepalive := session.keepalive()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepalive))
+
+		var m messageT
+		if err := conn.ReadJSON(&m); err != nil {
+			return err
+		}
+
+		switch m.Metadata.MessageType {
+		case "session_keepalive":
+		case "notification":
+			var n Notification
+			if err := json.Unmarshal(m.Payload, &n); err != nil {
+				log.Printf("Failed to decode EventSub notification: %s", err)
+				continue
+			}
+
+			w.live.Notify(c, n)
+
+		case "session_reconnect":
+			var p sessionPayloadT
+			if err := json.Unmarshal(m.Payload, &p); err != nil {
+				return err
+			}
+
+			newConn, newSession, err := w.connect(c, p.Session.ReconnectURL)
+			if err != nil {
+				return err
+			}
+			closeOnDone(newConn)
+
+			conn.Close()
+			conn, keepalive = newConn, newSession.keepalive()
+
+		case "revocation":
+			var n Notification
+			if err := json.Unmarshal(m.Payload, &n); err == nil {
+				log.Printf("EventSub subscription %s for %v revoked: %s",
+					n.Subscription.Type, n.Subscription.Condition, n.Subscription.Status)
+			}
+		}
+	}
+}
+
+// connect dials the server and waits for a welcome message with the session info.
+func (w *WebSocket) connect(c context.Context, url string) (*websocket.Conn, *sessionT, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(c, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := readWelcome(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, session, nil
+}
+
+func readWelcome(conn *websocket.Conn) (*sessionT, error) {
+	conn.SetReadDeadline(time.Now().Add(welcomeTimeout))
+
+	var m messageT
+	if err := conn.ReadJSON(&m); err != nil {
+		return nil, err
+	}
+
+	if m.Metadata.MessageType != "session_welcome" {
+		return nil, fmt.Errorf("expected session_welcome, got %q", m.Metadata.MessageType)
+	}
+
+	var p sessionPayloadT
+	if err := json.Unmarshal(m.Payload, &p); err != nil {
+		return nil, err
+	}
+
+	if p.Session.ID == "" {
+		return nil, errors.New("session_welcome carries no session ID")
+	}
+
+	return &p.Session, nil
+}
+
+type messageT struct {
+	Metadata struct {
+		MessageID   string `json:"message_id"`
+		MessageType string `json:"message_type"`
+	} `json:"metadata"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type sessionPayloadT struct {
+	Session sessionT `json:"session"`
+}
+
+type sessionT struct {
+	ID                      string `json:"id"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+// keepalive returns how long to wait for a message before considering the connection lost.
+func (s *sessionT) keepalive() time.Duration {
+	timeout := time.Duration(s.KeepaliveTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = welcomeTimeout
+	}
+
+	// Give the server some slack on top of the advertised timeout.
+	return timeout + timeout/2
+}
@@ -0,0 +1,337 @@
+package eventsub_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/oauth2"
+
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub"
+)
+
+func TestWebSocketFollowsNotifications(t *testing.T) {
+	f := newFakeFetcher()
+	server := newFakeEventSub(t)
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := eventsub.NewWebSocket(f, server.client(), eventsub.WebSocketOptions{
+		Broadcasters: []string{"login1"},
+		URL:          server.wsURL(),
+	})
+	go w.Watch(c)
+
+	expectSnapshot(t, w.Source(), nil)
+
+	server.awaitSubscriptions(t, 3)
+
+	f.setLive(stream.Stream{ID: "stream1", Title: "First", User: stream.User{ID: "id-login1"}})
+	server.notify(t, "stream.online", map[string]string{"broadcaster_user_id": "id-login1", "id": "stream1"})
+	expectSnapshot(t, w.Source(), map[string]string{"stream1": "First"})
+
+	server.notify(t, "channel.update", map[string]string{"broadcaster_user_id": "id-login1", "title": "Second"})
+	expectSnapshot(t, w.Source(), map[string]string{"stream1": "Second"})
+
+	f.setLive()
+	server.notify(t, "stream.offline", map[string]string{"broadcaster_user_id": "id-login1"})
+	expectSnapshot(t, w.Source(), nil)
+}
+
+func TestWebSocketFollowsReconnect(t *testing.T) {
+	f := newFakeFetcher()
+	server := newFakeEventSub(t)
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := eventsub.NewWebSocket(f, server.client(), eventsub.WebSocketOptions{
+		Broadcasters: []string{"login1"},
+		URL:          server.wsURL(),
+	})
+	go w.Watch(c)
+
+	expectSnapshot(t, w.Source(), nil)
+	server.awaitSubscriptions(t, 3)
+
+	server.reconnect(t)
+
+	f.setLive(stream.Stream{ID: "stream1", Title: "First", User: stream.User{ID: "id-login1"}})
+	server.notify(t, "stream.online", map[string]string{"broadcaster_user_id": "id-login1", "id": "stream1"})
+	expectSnapshot(t, w.Source(), map[string]string{"stream1": "First"})
+
+	// Subscriptions carry over to the new connection.
+	server.awaitSubscriptions(t, 3)
+}
+
+func TestWebSocketSkipsUnknownBroadcasters(t *testing.T) {
+	f := newFakeFetcher()
+	server := newFakeEventSub(t)
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := eventsub.NewWebSocket(f, server.client(), eventsub.WebSocketOptions{
+		Broadcasters: []string{"unknown1", "login1"},
+		URL:          server.wsURL(),
+	})
+	go w.Watch(c)
+
+	expectSnapshot(t, w.Source(), nil)
+
+	// Only the existing broadcaster is subscribed to.
+	server.awaitSubscriptions(t, 3)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for _, sub := range server.subscriptions {
+		if id := sub.Condition["broadcaster_user_id"]; id != "id-login1" {
+			t.Errorf("Unexpected subscription to broadcaster %q", id)
+		}
+	}
+}
+
+func TestWebSocketFailsWithoutExistingBroadcasters(t *testing.T) {
+	server := newFakeEventSub(t)
+
+	w := eventsub.NewWebSocket(newFakeFetcher(), server.client(), eventsub.WebSocketOptions{
+		Broadcasters: []string{"unknown1"},
+		URL:          server.wsURL(),
+	})
+
+	if err := w.Watch(context.Background()); err == nil {
+		t.Errorf("Expected Watch to fail")
+	}
+
+	select {
+	case _, ok := <-w.Source():
+		if ok {
+			t.Errorf("Expected no snapshots")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected Source to be closed")
+	}
+}
+
+//
+// HELPERS
+//
+
+type fakeFetcher struct {
+	mu   sync.Mutex
+	live []stream.Stream
+}
+
+func newFakeFetcher() *fakeFetcher {
+	return &fakeFetcher{}
+}
+
+func (f *fakeFetcher) setLive(ss ...stream.Stream) {
+	f.mu.Lock()
+	f.live = ss
+	f.mu.Unlock()
+}
+
+func (f *fakeFetcher) FetchBroadcasters(c context.Context, userIDs []string) ([]stream.Stream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ss := make([]stream.Stream, 0)
+	for _, s := range f.live {
+		for _, id := range userIDs {
+			if s.User.ID == id {
+				ss = append(ss, s)
+			}
+		}
+	}
+
+	return ss, nil
+}
+
+// UserIDs resolves logins, except the ones starting with "unknown".
+func (f *fakeFetcher) UserIDs(c context.Context, logins []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	for _, login := range logins {
+		if !strings.HasPrefix(login, "unknown") {
+			ids[login] = "id-" + login
+		}
+	}
+
+	return ids, nil
+}
+
+type fakeEventSub struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	conn          *websocket.Conn
+	sessions      int
+	subscriptions []eventsub.Subscription
+	connected     chan struct{}
+}
+
+func newFakeEventSub(t *testing.T) *fakeEventSub {
+	t.Helper()
+
+	s := &fakeEventSub{connected: make(chan struct{}, 10)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ws)
+	mux.HandleFunc("/helix/eventsub/subscriptions", s.subscribe)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *fakeEventSub) wsURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+}
+
+func (s *fakeEventSub) client() *eventsub.Client {
+	return &eventsub.Client{
+		HelixURL:    s.URL + "/helix",
+		ClientID:    "client",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+	}
+}
+
+func (s *fakeEventSub) ws(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.sessions++
+	s.conn = conn
+	conn.WriteJSON(message("session_welcome", map[string]any{
+		"session": map[string]any{
+			"id":                        fmt.Sprintf("session%d", s.sessions),
+			"keepalive_timeout_seconds": 10,
+		},
+	}))
+	s.mu.Unlock()
+
+	s.connected <- struct{}{}
+
+	// Drain the connection until the client hangs up.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeEventSub) subscribe(w http.ResponseWriter, r *http.Request) {
+	var sub eventsub.Subscription
+	json.NewDecoder(r.Body).Decode(&sub)
+
+	s.mu.Lock()
+	s.subscriptions = append(s.subscriptions, sub)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *fakeEventSub) awaitSubscriptions(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		count := len(s.subscriptions)
+		s.mu.Unlock()
+
+		if count == n {
+			return
+		}
+		if count > n {
+			t.Fatalf("Expected %d subscriptions, got %d", n, count)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for %d subscriptions", n)
+}
+
+func (s *fakeEventSub) notify(t *testing.T, typ string, event map[string]string) {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.conn.WriteJSON(message("notification", map[string]any{
+		"subscription": map[string]any{"type": typ, "version": "1"},
+		"event":        event,
+	}))
+	if err != nil {
+		t.Fatalf("Failed to send notification: %s", err)
+	}
+}
+
+func (s *fakeEventSub) reconnect(t *testing.T) {
+	t.Helper()
+
+	<-s.connected
+
+	s.mu.Lock()
+	err := s.conn.WriteJSON(message("session_reconnect", map[string]any{
+		"session": map[string]any{"reconnect_url": s.wsURL()},
+	}))
+	s.mu.Unlock()
+
+	if err != nil {
+		t.Fatalf("Failed to send reconnect: %s", err)
+	}
+
+	select {
+	case <-s.connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for reconnect")
+	}
+
+	// Give the client a moment to switch over to the new connection.
+	time.Sleep(50 * time.Millisecond)
+}
+
+func message(typ string, payload any) map[string]any {
+	return map[string]any{
+		"metadata": map[string]any{"message_id": typ, "message_type": typ},
+		"payload":  payload,
+	}
+}
+
+// expectSnapshot receives a stream list and compares it to expected stream IDs and titles.
+func expectSnapshot(t *testing.T, source <-chan []stream.Stream, expected map[string]string) {
+	t.Helper()
+
+	var ss []stream.Stream
+	select {
+	case ss = <-source:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for a stream list")
+	}
+
+	if len(ss) != len(expected) {
+		t.Fatalf("Expected %d streams, got %d", len(expected), len(ss))
+	}
+
+	for _, s := range ss {
+		if title, exists := expected[s.ID]; !exists || title != s.Title {
+			t.Errorf("Unexpected stream %q with title %q", s.ID, s.Title)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -13,79 +14,327 @@ import (
 	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/oauth2/twitch"
 
-	"github.com/TeamTenuki/twiddler/clock"
 	"github.com/TeamTenuki/twiddler/stream"
 )
 
 var _ stream.Fetcher = &Fetcher{}
 
+// ServiceName is a name of the Twitch streaming service.
+const ServiceName = "twitch"
+
+// DefaultGameID is an ID of the Go category, which is tracked when no categories are configured.
+const DefaultGameID = "65360"
+
+// DefaultMaxPages is a default limit of stream pages to follow in a single Fetch.
+const DefaultMaxPages = 10
+
+const (
+	helixURL = "https://api.twitch.tv/helix"
+	pageSize = "100"
+)
+
+// Options control which streams Fetcher looks for and where it looks for them.
+type Options struct {
+	// GameIDs is a list of category IDs to track.
+	GameIDs []string
+
+	// GameNames is a list of category names to track. Names are resolved
+	// into IDs via helix/games on the first Fetch, unknown names are skipped.
+	GameNames []string
+
+	// MaxPages limits the number of stream pages followed per category batch
+	// in a single Fetch. DefaultMaxPages is used when it is zero.
+	MaxPages int
+
+	// HelixURL overrides the base URL of the Helix API. Meant for testing.
+	HelixURL string
+
+	// TokenURL overrides the OAuth2 token endpoint. Meant for testing.
+	TokenURL string
+
+	// UserCache stores profiles of streamers. An in-memory cache is used when it is nil.
+	UserCache stream.UserCache
+
+	// Followed yields logins of streamers, whose streams are fetched regardless
+	// of their category. Such streams are marked as Followed.
+	Followed func(c context.Context) ([]string, error)
+}
+
+// UserTTL is a duration for which streamer profiles are cached.
+const UserTTL = 48 * time.Hour
+
 type Fetcher struct {
 	oauth2Config clientcredentials.Config
 	tokenSource  oauth2.TokenSource
 	r            *strings.Replacer
-	userCache    map[string]entryT
+	userCache    stream.UserCache
+	opts         Options
+	gameIDs      []string
 }
 
-func NewFetcher(c context.Context, clientID string, clientSecret string) *Fetcher {
+func NewFetcher(c context.Context, clientID string, clientSecret string, opts Options) *Fetcher {
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = DefaultMaxPages
+	}
+	if opts.HelixURL == "" {
+		opts.HelixURL = helixURL
+	}
+	if opts.TokenURL == "" {
+		opts.TokenURL = twitch.Endpoint.TokenURL
+	}
+	if opts.UserCache == nil {
+		opts.UserCache = stream.NewMemoryUserCache(UserTTL)
+	}
+
 	oauth2Config := clientcredentials.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
-		TokenURL:     twitch.Endpoint.TokenURL,
+		TokenURL:     opts.TokenURL,
 	}
 
 	return &Fetcher{
 		oauth2Config: oauth2Config,
 		tokenSource:  oauth2Config.TokenSource(c),
 		r:            strings.NewReplacer("{width}", "1280", "{height}", "720"),
-		userCache:    make(map[string]entryT),
+		userCache:    opts.UserCache,
+		opts:         opts,
 	}
 }
 
+// TokenSource returns the source of app access tokens used by the fetcher.
+func (f *Fetcher) TokenSource() oauth2.TokenSource {
+	return f.tokenSource
+}
+
 func (f *Fetcher) Fetch(c context.Context) ([]stream.Stream, error) {
-	var streamContainer streamContainerT
+	gameIDs, err := f.resolveGameIDs(c)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	ss := make([]streamT, 0)
+
+	// Helix accepts at most 100 game_id parameters per request.
+	for _, batch := range stream.Batches(gameIDs, 100) {
+		pages, err := f.fetchStreams(c, url.Values{"game_id": batch}, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		ss = append(ss, pages...)
+	}
+
+	cs, err := f.constructStreamList(c, ss)
+	if err != nil || f.opts.Followed == nil {
+		return cs, err
+	}
+
+	// Streams in the tracked categories are fetched already, so they aren't marked as followed.
+	followed, err := f.fetchFollowed(c, seen)
+	if err != nil {
+		// Without the followed streams the snapshot isn't full, they would look ended.
+		return nil, fmt.Errorf("failed to fetch followed streams: %w", err)
+	}
+
+	return append(cs, followed...), nil
+}
+
+// fetchFollowed fetches live streams of the followed streamers, except for the streams in seen.
+func (f *Fetcher) fetchFollowed(c context.Context, seen map[string]struct{}) ([]stream.Stream, error) {
+	logins, err := f.opts.Followed(c)
+	if err != nil {
+		return nil, err
+	}
 
-	err := f.get(c, "https://api.twitch.tv/helix/streams?game_id=65360&first=100", &streamContainer)
+	ss := make([]streamT, 0)
+
+	// Helix accepts at most 100 user_login parameters per request.
+	for _, batch := range stream.Batches(logins, 100) {
+		pages, err := f.fetchStreams(c, url.Values{"user_login": batch}, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		ss = append(ss, pages...)
+	}
+
+	followed, err := f.constructStreamList(c, ss)
 	if err != nil {
 		return nil, err
 	}
 
-	return f.constructStreamList(c, &streamContainer)
+	for i := range followed {
+		followed[i].Followed = true
+	}
+
+	return followed, nil
 }
 
-func (f *Fetcher) constructStreamList(c context.Context, sc *streamContainerT) ([]stream.Stream, error) {
-	ss := make([]stream.Stream, len(sc.Data))
+// FetchBroadcasters fetches live streams of the given streamers, regardless of their category.
+func (f *Fetcher) FetchBroadcasters(c context.Context, userIDs []string) ([]stream.Stream, error) {
+	seen := make(map[string]struct{})
+	ss := make([]streamT, 0)
 
-	for i := range sc.Data {
-		s, err := f.constructStream(c, &sc.Data[i])
+	// Helix accepts at most 100 user_id parameters per request.
+	for _, batch := range stream.Batches(userIDs, 100) {
+		pages, err := f.fetchStreams(c, url.Values{"user_id": batch}, seen)
 		if err != nil {
 			return nil, err
 		}
-		ss[i] = s
+
+		ss = append(ss, pages...)
+	}
+
+	return f.constructStreamList(c, ss)
+}
+
+// fetchStreams follows the pagination cursor of helix/streams for a given query until
+// the pages are exhausted or the page limit is reached. Streams with IDs already present
+// in seen are skipped, because pages may shift while streams go live or offline.
+func (f *Fetcher) fetchStreams(c context.Context, q url.Values, seen map[string]struct{}) ([]streamT, error) {
+	ss := make([]streamT, 0)
+	q.Set("first", pageSize)
+
+	for page := 0; page < f.opts.MaxPages; page++ {
+		var streamContainer streamContainerT
+
+		err := f.get(c, f.opts.HelixURL+"/streams?"+q.Encode(), &streamContainer)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range streamContainer.Data {
+			if _, yes := seen[s.ID]; yes {
+				continue
+			}
+
+			seen[s.ID] = struct{}{}
+			ss = append(ss, s)
+		}
+
+		cursor := streamContainer.Pagination.Cursor
+		if cursor == "" || len(streamContainer.Data) == 0 {
+			break
+		}
+
+		q.Set("after", cursor)
 	}
 
 	return ss, nil
 }
 
-func (f *Fetcher) constructStream(c context.Context, s *streamT) (stream.Stream, error) {
-	startedAt, err := time.Parse(time.RFC3339, s.StartedAt)
+// resolveGameIDs yields IDs of all the configured categories, looking up
+// categories configured by name. Names unknown to Twitch are logged and skipped,
+// so that a single typo doesn't stop tracking of the rest. Successful lookups are remembered.
+func (f *Fetcher) resolveGameIDs(c context.Context) ([]string, error) {
+	if f.gameIDs != nil {
+		return f.gameIDs, nil
+	}
+
+	gameIDs := append([]string{}, f.opts.GameIDs...)
+	resolved := make(map[string]bool)
+
+	for _, batch := range stream.Batches(f.opts.GameNames, 100) {
+		var gameContainer gameContainerT
+
+		err := f.get(c, f.opts.HelixURL+"/games?"+url.Values{"name": batch}.Encode(), &gameContainer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve game names: %w", err)
+		}
+
+		for _, g := range gameContainer.Data {
+			resolved[strings.ToLower(g.Name)] = true
+			gameIDs = append(gameIDs, g.ID)
+		}
+	}
+
+	if len(f.opts.GameNames) > 0 && len(resolved) == 0 {
+		return nil, fmt.Errorf("failed to resolve game names: none of %q found", f.opts.GameNames)
+	}
+
+	for _, name := range f.opts.GameNames {
+		if !resolved[strings.ToLower(name)] {
+			log.Printf("Unknown Twitch category %q, skipping it", name)
+		}
+	}
+
+	if len(gameIDs) == 0 {
+		gameIDs = []string{DefaultGameID}
+	}
+
+	f.gameIDs = gameIDs
+
+	return f.gameIDs, nil
+}
+
+func (f *Fetcher) constructStreamList(c context.Context, raw []streamT) ([]stream.Stream, error) {
+	userIDs := make([]string, len(raw))
+	for i := range raw {
+		userIDs[i] = raw[i].UserID
+	}
+
+	users, err := f.users(c, userIDs)
 	if err != nil {
-		return stream.Stream{}, err
+		return nil, err
 	}
 
-	thumbnailURL, err := url.Parse(f.r.Replace(s.Thumbnail))
+	// Stream info carries up to date login and display name of a streamer,
+	// refresh profiles that went stale since they were cached.
+	stale := make([]string, 0)
+	for i := range raw {
+		u := users[raw[i].UserID]
+		if u.Name != raw[i].UserLogin || u.DisplayName != raw[i].UserName {
+			if err := f.InvalidateUser(c, u.ID); err != nil {
+				return nil, err
+			}
+			stale = append(stale, u.ID)
+		}
+	}
+
+	if len(stale) > 0 {
+		refreshed, err := f.users(c, stale)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, u := range refreshed {
+			users[id] = u
+		}
+	}
+
+	ss := make([]stream.Stream, len(raw))
+
+	for i := range raw {
+		s, err := f.constructStream(&raw[i], users[raw[i].UserID])
+		if err != nil {
+			return nil, err
+		}
+		ss[i] = s
+	}
+
+	return ss, nil
+}
+
+func (f *Fetcher) constructStream(s *streamT, user stream.User) (stream.Stream, error) {
+	startedAt, err := time.Parse(time.RFC3339, s.StartedAt)
 	if err != nil {
 		return stream.Stream{}, err
 	}
 
-	user, err := f.userInfo(c, s.UserID)
+	thumbnailURL, err := url.Parse(f.r.Replace(s.Thumbnail))
 	if err != nil {
 		return stream.Stream{}, err
 	}
 
 	cs := stream.Stream{
 		ID:           s.ID,
+		Service:      ServiceName,
 		User:         user,
 		Title:        s.Title,
+		CategoryID:   s.GameID,
+		Category:     s.GameName,
+		Language:     s.Language,
 		StartedAt:    startedAt.In(time.UTC),
 		ThumbnailURL: thumbnailURL,
 	}
@@ -93,24 +342,104 @@ func (f *Fetcher) constructStream(c context.Context, s *streamT) (stream.Stream,
 	return cs, nil
 }
 
-func (f *Fetcher) userInfo(c context.Context, userID string) (stream.User, error) {
-	if entry, exists := f.userCache[userID]; exists && !entry.expired() {
-		return entry.user, nil
+// users yields profiles of the given users. Users absent from the cache (or expired)
+// are looked up in batches and stored into the cache.
+func (f *Fetcher) users(c context.Context, userIDs []string) (map[string]stream.User, error) {
+	users := make(map[string]stream.User)
+	unknown := make([]string, 0)
+
+	for _, id := range userIDs {
+		if _, yes := users[id]; yes {
+			continue
+		}
+
+		u, exists, err := f.userCache.User(c, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			users[id] = u
+			continue
+		}
+
+		users[id] = stream.User{}
+		unknown = append(unknown, id)
 	}
 
-	var userContainer userContainerT
+	found, err := f.lookupUsers(c, "id", unknown)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range found {
+		users[u.ID] = u
+	}
 
-	err := f.get(c, "https://api.twitch.tv/helix/users?id="+userID, &userContainer)
+	for id, u := range users {
+		if u.ID == "" {
+			return nil, fmt.Errorf("failed to retrieve user with ID %s", id)
+		}
+	}
+
+	return users, nil
+}
+
+// UserIDs resolves logins of streamers into their user IDs, keyed by logins in lower case.
+// Logins of users that don't exist, e.g. renamed ones, are missing from the result.
+func (f *Fetcher) UserIDs(c context.Context, logins []string) (map[string]string, error) {
+	found, err := f.lookupUsers(c, "login", logins)
 	if err != nil {
-		return stream.User{}, err
+		return nil, err
 	}
 
-	if len(userContainer.Data) == 0 {
-		return stream.User{}, fmt.Errorf("failed to retrieve user with ID %s", userID)
+	ids := make(map[string]string, len(found))
+	for _, u := range found {
+		ids[strings.ToLower(u.Name)] = u.ID
 	}
 
-	uc := userContainer.Data[0]
+	return ids, nil
+}
+
+// lookupUsers looks up users by a given helix/users parameter (either "id" or "login")
+// in batches and stores them into the cache.
+func (f *Fetcher) lookupUsers(c context.Context, param string, values []string) ([]stream.User, error) {
+	users := make([]stream.User, 0, len(values))
+
+	// Helix accepts at most 100 id/login parameters per request.
+	for _, batch := range stream.Batches(values, 100) {
+		var userContainer userContainerT
+
+		err := f.get(c, f.opts.HelixURL+"/users?"+url.Values{param: batch}.Encode(), &userContainer)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range userContainer.Data {
+			u, err := constructUser(&userContainer.Data[i])
+			if err != nil {
+				return nil, err
+			}
 
+			if err := f.userCache.StoreUser(c, u); err != nil {
+				return nil, err
+			}
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+// InvalidateUser drops a cached profile of a streamer with a given ID,
+// so that it is looked up again on the next Fetch. This is done automatically
+// when a streamer's login or display name changes, but changes of pictures
+// are only noticed once the profile expires.
+func (f *Fetcher) InvalidateUser(c context.Context, userID string) error {
+	return f.userCache.InvalidateUser(c, userID)
+}
+
+func constructUser(uc *userT) (stream.User, error) {
 	channelURL, err := url.Parse(fmt.Sprintf("https://twitch.tv/%s", uc.Login))
 	if err != nil {
 		return stream.User{}, err
@@ -126,6 +455,7 @@ func (f *Fetcher) userInfo(c context.Context, userID string) (stream.User, error
 
 	u := stream.User{
 		ID:              uc.ID,
+		Service:         ServiceName,
 		Name:            uc.Login,
 		DisplayName:     uc.DisplayName,
 		ChannelURL:      channelURL,
@@ -134,8 +464,6 @@ func (f *Fetcher) userInfo(c context.Context, userID string) (stream.User, error
 		OfflineImageURL: offlineImageUrl,
 	}
 
-	f.userCache[userID] = newEntry(u)
-
 	return u, nil
 }
 
@@ -179,19 +507,6 @@ func (f *Fetcher) newRequest(c context.Context, u string) (*http.Request, error)
 	return req, nil
 }
 
-type entryT struct {
-	user      stream.User
-	expiresAt time.Time
-}
-
-func newEntry(u stream.User) entryT {
-	return entryT{user: u, expiresAt: clock.NowUTC().Add(48 * time.Hour)}
-}
-
-func (e *entryT) expired() bool {
-	return e.expiresAt.After(clock.NowUTC())
-}
-
 type userContainerT struct {
 	Data []userT `json:"data"`
 }
@@ -213,7 +528,10 @@ type streamT struct {
 	// Unique stream identifier.
 	ID string `json:"id"`
 
-	// Twitch username of the channel owner.
+	// Twitch login of the channel owner.
+	UserLogin string `json:"user_login"`
+
+	// Twitch display name of the channel owner.
 	UserName string `json:"user_name"`
 
 	// Twitch user ID.
@@ -222,6 +540,15 @@ type streamT struct {
 	// Channel title.
 	Title string `json:"title"`
 
+	// ID of the category (game) being streamed.
+	GameID string `json:"game_id"`
+
+	// Name of the category (game) being streamed.
+	GameName string `json:"game_name"`
+
+	// ISO 639-1 code of the stream language.
+	Language string `json:"language"`
+
 	// Live stream thumbnail URL.
 	Thumbnail string `json:"thumbnail_url"`
 
@@ -232,3 +559,12 @@ type streamT struct {
 type paginationT struct {
 	Cursor string `json:"cursor"`
 }
+
+type gameContainerT struct {
+	Data []gameT `json:"data"`
+}
+
+type gameT struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,459 @@
+package twitch_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/stream"
+	"github.com/TeamTenuki/twiddler/stream/twitch"
+)
+
+func TestFetchFollowsPagination(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 100), streamIDs(100, 200), streamIDs(200, 250))
+
+	ss, err := helix.fetcher(twitch.Options{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	expectStreams(t, ss, streamIDs(0, 250)...)
+	helix.expectRequests(t, "/helix/streams", 3)
+}
+
+func TestFetchDeduplicatesShiftedPages(t *testing.T) {
+	// A stream went live between requests, shifting stream99 onto the second page.
+	helix := newFakeHelix(t, streamIDs(0, 100), streamIDs(99, 150))
+
+	ss, err := helix.fetcher(twitch.Options{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	expectStreams(t, ss, streamIDs(0, 150)...)
+}
+
+func TestFetchStopsAtPageLimit(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 100), streamIDs(100, 200), streamIDs(200, 300))
+
+	ss, err := helix.fetcher(twitch.Options{MaxPages: 2}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	expectStreams(t, ss, streamIDs(0, 200)...)
+	helix.expectRequests(t, "/helix/streams", 2)
+}
+
+func TestFetchPassesCursor(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 100), streamIDs(100, 101))
+
+	if _, err := helix.fetcher(twitch.Options{}).Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	helix.mu.Lock()
+	defer helix.mu.Unlock()
+
+	if got := helix.cursors; len(got) != 2 || got[0] != "" || got[1] != "page1" {
+		t.Errorf("Unexpected cursors %q", got)
+	}
+}
+
+func TestFetchBatchesUserLookups(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 100), streamIDs(100, 200), streamIDs(200, 250))
+
+	ss, err := helix.fetcher(twitch.Options{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	helix.expectRequests(t, "/helix/users", 3)
+
+	helix.mu.Lock()
+	if helix.maxUserIDs > 100 {
+		t.Errorf("Expected at most 100 user IDs per request, got %d", helix.maxUserIDs)
+	}
+	helix.mu.Unlock()
+
+	for _, s := range ss {
+		if s.User.ID != "user-"+s.ID || s.User.Name != "login-user-"+s.ID {
+			t.Errorf("Stream %q has mismatched user %#v", s.ID, s.User)
+		}
+	}
+}
+
+func TestFetchReusesCachedUsers(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+	f := helix.fetcher(twitch.Options{})
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.Fetch(context.Background()); err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+	}
+
+	helix.expectRequests(t, "/helix/users", 1)
+}
+
+func TestFetchRefreshesRenamedUsers(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+	f := helix.fetcher(twitch.Options{})
+
+	if _, err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	helix.mu.Lock()
+	helix.renamed = map[string]bool{"user-stream3": true}
+	helix.mu.Unlock()
+
+	ss, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	helix.expectRequests(t, "/helix/users", 2)
+
+	for _, s := range ss {
+		if s.ID == "stream3" && s.User.DisplayName != "Renamed-user-stream3" {
+			t.Errorf("Expected renamed user, got %q", s.User.DisplayName)
+		}
+	}
+}
+
+func TestFetchMergesFollowedStreams(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+
+	// stream5 is in the category already, the rest are followed.
+	logins := []string{"login-user-stream5"}
+	for _, id := range streamIDs(100, 250) {
+		logins = append(logins, "login-user-"+id)
+	}
+
+	f := helix.fetcher(twitch.Options{
+		Followed: func(c context.Context) ([]string, error) { return logins, nil },
+	})
+
+	ss, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	expectStreams(t, ss, append(streamIDs(0, 10), streamIDs(100, 250)...)...)
+
+	category := make(map[string]bool)
+	for _, id := range streamIDs(0, 10) {
+		category[id] = true
+	}
+
+	for _, s := range ss {
+		if followed := !category[s.ID]; s.Followed != followed {
+			t.Errorf("Expected stream %q followed: %t, got %t", s.ID, followed, s.Followed)
+		}
+	}
+
+	helix.mu.Lock()
+	if helix.maxLogins > 100 {
+		t.Errorf("Expected at most 100 logins per request, got %d", helix.maxLogins)
+	}
+	helix.mu.Unlock()
+}
+
+func TestFetchFailsWithoutFollowedStreams(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+
+	f := helix.fetcher(twitch.Options{
+		Followed: func(c context.Context) ([]string, error) { return nil, errors.New("boom") },
+	})
+
+	ss, err := f.Fetch(context.Background())
+	if err == nil || ss != nil {
+		t.Errorf("Expected Fetch to fail without streams, got %d streams and %v", len(ss), err)
+	}
+}
+
+func TestFetchTagsStreamsWithCategories(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+
+	ss, err := helix.fetcher(twitch.Options{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	for _, s := range ss {
+		if s.CategoryID != twitch.DefaultGameID || s.Category != "Go" {
+			t.Errorf("Expected stream %q in category Go, got %q (%q)", s.ID, s.Category, s.CategoryID)
+		}
+	}
+
+	helix.expectGameIDs(t, twitch.DefaultGameID)
+}
+
+func TestFetchResolvesGameNames(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+	f := helix.fetcher(twitch.Options{GameIDs: []string{"1"}, GameNames: []string{"rust", "No Such Game"}})
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.Fetch(context.Background()); err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+	}
+
+	helix.expectGameIDs(t, "1", "2")
+	helix.expectRequests(t, "/helix/games", 1)
+}
+
+func TestFetchFailsWhenNoGameNameResolves(t *testing.T) {
+	helix := newFakeHelix(t, streamIDs(0, 10))
+	f := helix.fetcher(twitch.Options{GameNames: []string{"No Such Game"}})
+
+	if _, err := f.Fetch(context.Background()); err == nil {
+		t.Errorf("Expected Fetch to fail")
+	}
+
+	helix.expectRequests(t, "/helix/streams", 0)
+}
+
+//
+// HELPERS
+//
+
+type fakeHelix struct {
+	*httptest.Server
+
+	// pages of stream IDs, served in order by following the cursor.
+	pages [][]string
+
+	mu         sync.Mutex
+	requests   map[string]int
+	cursors    []string
+	gameIDs    []string
+	maxUserIDs int
+	maxLogins  int
+	renamed    map[string]bool
+}
+
+func newFakeHelix(t *testing.T, pages ...[]string) *fakeHelix {
+	t.Helper()
+
+	h := &fakeHelix{pages: pages, requests: make(map[string]int)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", h.token)
+	mux.HandleFunc("/helix/streams", h.streams)
+	mux.HandleFunc("/helix/users", h.users)
+	mux.HandleFunc("/helix/games", h.games)
+
+	h.Server = httptest.NewServer(h.count(mux))
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+func (h *fakeHelix) fetcher(opts twitch.Options) *twitch.Fetcher {
+	opts.HelixURL = h.URL + "/helix"
+	opts.TokenURL = h.URL + "/oauth2/token"
+
+	return twitch.NewFetcher(context.Background(), "client", "secret", opts)
+}
+
+func (h *fakeHelix) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.requests[r.URL.Path]++
+		h.mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *fakeHelix) expectRequests(t *testing.T, path string, n int) {
+	t.Helper()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.requests[path] != n {
+		t.Errorf("Expected %d requests to %s, got %d", n, path, h.requests[path])
+	}
+}
+
+func (h *fakeHelix) token(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"access_token": "token",
+		"token_type":   "bearer",
+		"expires_in":   3600,
+	})
+}
+
+func (h *fakeHelix) streams(w http.ResponseWriter, r *http.Request) {
+	// Followed streamers are always live, and fit into a single page.
+	if logins := r.URL.Query()["user_login"]; len(logins) > 0 {
+		h.mu.Lock()
+		if len(logins) > h.maxLogins {
+			h.maxLogins = len(logins)
+		}
+		h.mu.Unlock()
+
+		data := make([]map[string]string, 0)
+		for _, login := range logins {
+			data = append(data, streamData(strings.TrimPrefix(login, "login-user-"), nil))
+		}
+
+		writeJSON(w, map[string]any{"data": data, "pagination": map[string]string{}})
+		return
+	}
+
+	cursor := r.URL.Query().Get("after")
+
+	h.mu.Lock()
+	h.cursors = append(h.cursors, cursor)
+	h.gameIDs = r.URL.Query()["game_id"]
+	h.mu.Unlock()
+
+	h.mu.Lock()
+	renamed := h.renamed
+	h.mu.Unlock()
+
+	page := 0
+	if cursor != "" {
+		page, _ = strconv.Atoi(cursor[len("page"):])
+	}
+
+	data := make([]map[string]string, 0)
+	next := ""
+
+	if page < len(h.pages) {
+		for _, id := range h.pages[page] {
+			data = append(data, streamData(id, renamed))
+		}
+
+		if page+1 < len(h.pages) {
+			next = fmt.Sprintf("page%d", page+1)
+		}
+	}
+
+	writeJSON(w, map[string]any{
+		"data":       data,
+		"pagination": map[string]string{"cursor": next},
+	})
+}
+
+// games knows the Go category by its real ID, and Rust by a made up one.
+func (h *fakeHelix) games(w http.ResponseWriter, r *http.Request) {
+	known := map[string]map[string]string{
+		"go":   {"id": twitch.DefaultGameID, "name": "Go"},
+		"rust": {"id": "2", "name": "Rust"},
+	}
+
+	data := make([]map[string]string, 0)
+	for _, name := range r.URL.Query()["name"] {
+		if g, yes := known[strings.ToLower(name)]; yes {
+			data = append(data, g)
+		}
+	}
+
+	writeJSON(w, map[string]any{"data": data})
+}
+
+func (h *fakeHelix) expectGameIDs(t *testing.T, ids ...string) {
+	t.Helper()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if strings.Join(h.gameIDs, ",") != strings.Join(ids, ",") {
+		t.Errorf("Expected streams of categories %q, got %q", ids, h.gameIDs)
+	}
+}
+
+func (h *fakeHelix) users(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["id"]
+
+	h.mu.Lock()
+	if len(ids) > h.maxUserIDs {
+		h.maxUserIDs = len(ids)
+	}
+	renamed := h.renamed
+	h.mu.Unlock()
+
+	data := make([]map[string]string, 0)
+	for _, id := range ids {
+		displayName := "Login-" + id
+		if renamed[id] {
+			displayName = "Renamed-" + id
+		}
+
+		data = append(data, map[string]string{
+			"id":                id,
+			"login":             "login-" + id,
+			"display_name":      displayName,
+			"profile_image_url": "https://example.com/" + id + ".png",
+			"offline_image_url": "",
+		})
+	}
+
+	writeJSON(w, map[string]any{"data": data})
+}
+
+func streamData(id string, renamed map[string]bool) map[string]string {
+	displayName := "Login-user-" + id
+	if renamed["user-"+id] {
+		displayName = "Renamed-user-" + id
+	}
+
+	return map[string]string{
+		"id":            id,
+		"user_id":       "user-" + id,
+		"user_login":    "login-user-" + id,
+		"user_name":     displayName,
+		"game_id":       twitch.DefaultGameID,
+		"game_name":     "Go",
+		"language":      "en",
+		"title":         "Title of " + id,
+		"thumbnail_url": "https://example.com/{width}x{height}.jpg",
+		"started_at":    time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func streamIDs(from, to int) []string {
+	ids := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		ids = append(ids, fmt.Sprintf("stream%d", i))
+	}
+
+	return ids
+}
+
+func expectStreams(t *testing.T, ss []stream.Stream, ids ...string) {
+	t.Helper()
+
+	if len(ids) != len(ss) {
+		t.Errorf("Expected %d streams got %d", len(ids), len(ss))
+	}
+
+	seen := make(map[string]int)
+	for _, s := range ss {
+		seen[s.ID]++
+	}
+
+	for _, id := range ids {
+		if seen[id] != 1 {
+			t.Errorf("Expected stream %q exactly once, got %d", id, seen[id])
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Package youtube implements stream.Fetcher for YouTube Live via the YouTube Data API.
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+var _ stream.Fetcher = &Fetcher{}
+
+// ServiceName is a name of the YouTube streaming service.
+const ServiceName = "youtube"
+
+const (
+	apiURL = "https://www.googleapis.com/youtube/v3"
+
+	// DefaultDailyQuota is the default daily quota of the YouTube Data API in units.
+	DefaultDailyQuota = 10000
+
+	// searchCost is the cost of a single search.list request in quota units.
+	searchCost = 100
+
+	// listCost covers videos.list and channels.list requests, which cost a unit each.
+	listCost = 2
+
+	// UserTTL is a duration for which channel profiles are cached.
+	UserTTL = 48 * time.Hour
+
+	// maxIDs is the maximum number of IDs accepted by list endpoints in one request.
+	maxIDs = 50
+)
+
+// Options control which live broadcasts Fetcher looks for and where it looks for them.
+type Options struct {
+	// APIKey is a YouTube Data API key.
+	APIKey string
+
+	// ChannelIDs is a list of channels to look for live broadcasts on.
+	ChannelIDs []string
+
+	// Query is a search query to look for live broadcasts with, e.g. "baduk".
+	Query string
+
+	// Interval is a minimal interval between searches. Fetch returns the result
+	// of the latest search when called more often. Intervals that would exhaust
+	// DailyQuota are raised to MinInterval, which is also the default.
+	Interval time.Duration
+
+	// DailyQuota is the daily quota of the API key (DefaultDailyQuota if zero).
+	DailyQuota int
+
+	// UserCache stores channel profiles. An in-memory cache is used when it is nil.
+	UserCache stream.UserCache
+
+	// BaseURL overrides the base URL of the YouTube Data API. Meant for testing.
+	BaseURL string
+}
+
+// Fetcher fetches live broadcasts from YouTube.
+type Fetcher struct {
+	opts Options
+
+	mu          sync.Mutex
+	latest      []stream.Stream
+	latestAt    time.Time
+	latestValid bool
+}
+
+// NewFetcher returns a Fetcher that searches for live broadcasts on the configured
+// channels and by the configured query.
+func NewFetcher(opts Options) *Fetcher {
+	if opts.DailyQuota <= 0 {
+		opts.DailyQuota = DefaultDailyQuota
+	}
+
+	// Every channel is searched separately, and so is the query.
+	searches := len(opts.ChannelIDs)
+	if opts.Query != "" {
+		searches++
+	}
+
+	if min := MinInterval(searches, opts.DailyQuota); opts.Interval < min {
+		opts.Interval = min
+	}
+	if opts.UserCache == nil {
+		opts.UserCache = stream.NewMemoryUserCache(UserTTL)
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = apiURL
+	}
+
+	return &Fetcher{opts: opts}
+}
+
+// MinInterval returns the shortest interval between rounds of a given number of searches
+// that fits into a given daily quota. A search costs 100 units, so searching a single
+// channel fits into the default quota of 10000 units roughly once per 15 minutes.
+func MinInterval(searches int, dailyQuota int) time.Duration {
+	cost := searches*searchCost + listCost
+
+	return 24 * time.Hour * time.Duration(cost) / time.Duration(dailyQuota)
+}
+
+func (f *Fetcher) Fetch(c context.Context) ([]stream.Stream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.latestValid && clock.Since(f.latestAt) < f.opts.Interval {
+		return f.latest, nil
+	}
+
+	videoIDs, err := f.search(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ss, err := f.videos(c, videoIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	f.latest, f.latestAt, f.latestValid = ss, clock.NowUTC(), true
+
+	return ss, nil
+}
+
+// search yields IDs of live broadcasts on the configured channels and by the configured query.
+func (f *Fetcher) search(c context.Context) ([]string, error) {
+	queries := make([]url.Values, 0, len(f.opts.ChannelIDs)+1)
+	for _, id := range f.opts.ChannelIDs {
+		queries = append(queries, url.Values{"channelId": {id}})
+	}
+	if f.opts.Query != "" {
+		queries = append(queries, url.Values{"q": {f.opts.Query}})
+	}
+
+	ids := make([]string, 0)
+	seen := make(map[string]struct{})
+
+	for _, q := range queries {
+		q.Set("part", "id")
+		q.Set("eventType", "live")
+		q.Set("type", "video")
+		q.Set("maxResults", "50")
+
+		var sr searchResponseT
+		if err := f.get(c, "/search", q, &sr); err != nil {
+			return nil, err
+		}
+
+		for _, item := range sr.Items {
+			if _, yes := seen[item.ID.VideoID]; yes {
+				continue
+			}
+
+			seen[item.ID.VideoID] = struct{}{}
+			ids = append(ids, item.ID.VideoID)
+		}
+	}
+
+	return ids, nil
+}
+
+// videos looks up details of given videos and converts live ones into streams.
+func (f *Fetcher) videos(c context.Context, videoIDs []string) ([]stream.Stream, error) {
+	videos := make([]videoT, 0, len(videoIDs))
+
+	for _, batch := range stream.Batches(videoIDs, maxIDs) {
+		q := url.Values{
+			"part": {"snippet,liveStreamingDetails"},
+			"id":   {strings.Join(batch, ",")},
+		}
+
+		var vr videoResponseT
+		if err := f.get(c, "/videos", q, &vr); err != nil {
+			return nil, err
+		}
+
+		for _, v := range vr.Items {
+			// Search results may lag behind, skip broadcasts that are over or not yet started.
+			if v.Snippet.LiveBroadcastContent != "live" || v.LiveStreamingDetails.ActualStartTime == "" {
+				continue
+			}
+
+			videos = append(videos, v)
+		}
+	}
+
+	channelIDs := make([]string, len(videos))
+	for i := range videos {
+		channelIDs[i] = videos[i].Snippet.ChannelID
+	}
+
+	users, err := f.users(c, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := make([]stream.Stream, 0, len(videos))
+	for i := range videos {
+		s, err := constructStream(&videos[i], users[videos[i].Snippet.ChannelID])
+		if err != nil {
+			return nil, err
+		}
+
+		ss = append(ss, s)
+	}
+
+	return ss, nil
+}
+
+// users yields profiles of given channels, looking up the ones absent from the cache.
+func (f *Fetcher) users(c context.Context, channelIDs []string) (map[string]stream.User, error) {
+	users := make(map[string]stream.User)
+	unknown := make([]string, 0)
+
+	for _, id := range channelIDs {
+		if _, yes := users[id]; yes {
+			continue
+		}
+
+		u, exists, err := f.opts.UserCache.User(c, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			users[id] = u
+			continue
+		}
+
+		users[id] = stream.User{}
+		unknown = append(unknown, id)
+	}
+
+	for _, batch := range stream.Batches(unknown, maxIDs) {
+		q := url.Values{
+			"part": {"snippet"},
+			"id":   {strings.Join(batch, ",")},
+		}
+
+		var cr channelResponseT
+		if err := f.get(c, "/channels", q, &cr); err != nil {
+			return nil, err
+		}
+
+		for i := range cr.Items {
+			u, err := constructUser(&cr.Items[i])
+			if err != nil {
+				return nil, err
+			}
+
+			if err := f.opts.UserCache.StoreUser(c, u); err != nil {
+				return nil, err
+			}
+			users[u.ID] = u
+		}
+	}
+
+	for id, u := range users {
+		if u.ID == "" {
+			return nil, fmt.Errorf("failed to retrieve channel with ID %s", id)
+		}
+	}
+
+	return users, nil
+}
+
+func constructStream(v *videoT, user stream.User) (stream.Stream, error) {
+	startedAt, err := time.Parse(time.RFC3339, v.LiveStreamingDetails.ActualStartTime)
+	if err != nil {
+		return stream.Stream{}, err
+	}
+
+	thumbnailURL, err := url.Parse(v.Snippet.Thumbnails.best())
+	if err != nil {
+		return stream.Stream{}, err
+	}
+
+	s := stream.Stream{
+		ID:           v.ID,
+		Service:      ServiceName,
+		User:         user,
+		Title:        v.Snippet.Title,
+		CategoryID:   v.Snippet.CategoryID,
+		Language:     audioLanguage(v.Snippet.DefaultAudioLanguage),
+		StartedAt:    startedAt.In(time.UTC),
+		ThumbnailURL: thumbnailURL,
+	}
+
+	return s, nil
+}
+
+// audioLanguage reduces a BCP-47 language tag to an ISO 639-1 code, e.g. "en-US" to "en".
+func audioLanguage(tag string) string {
+	code, _, _ := strings.Cut(tag, "-")
+
+	return strings.ToLower(code)
+}
+
+func constructUser(ch *channelT) (stream.User, error) {
+	name := strings.TrimPrefix(ch.Snippet.CustomURL, "@")
+	if name == "" {
+		name = ch.ID
+	}
+
+	channelURL, err := url.Parse("https://www.youtube.com/channel/" + ch.ID)
+	if err != nil {
+		return stream.User{}, err
+	}
+	pictureURL, err := url.Parse(ch.Snippet.Thumbnails.best())
+	if err != nil {
+		return stream.User{}, err
+	}
+
+	u := stream.User{
+		ID:              ch.ID,
+		Service:         ServiceName,
+		Name:            name,
+		DisplayName:     ch.Snippet.Title,
+		ChannelURL:      channelURL,
+		ProfileURL:      channelURL,
+		PictureURL:      pictureURL,
+		OfflineImageURL: &url.URL{},
+	}
+
+	return u, nil
+}
+
+func (f *Fetcher) get(c context.Context, path string, q url.Values, d any) error {
+	q.Set("key", f.opts.APIKey)
+
+	req, err := http.NewRequestWithContext(c, "GET", f.opts.BaseURL+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 200 {
+		return json.NewDecoder(resp.Body).Decode(d)
+	}
+
+	var er errorResponseT
+	if err := json.NewDecoder(resp.Body).Decode(&er); err == nil && er.Error.Message != "" {
+		return fmt.Errorf("failed to fetch data, server replied with status %s: %s",
+			resp.Status, er.Error.Message)
+	}
+
+	return fmt.Errorf("failed to fetch data, server replied with status %s", resp.Status)
+}
+
+type errorResponseT struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type searchResponseT struct {
+	Items []struct {
+		ID struct {
+			VideoID string `json:"videoId"`
+		} `json:"id"`
+	} `json:"items"`
+}
+
+type videoResponseT struct {
+	Items []videoT `json:"items"`
+}
+
+type videoT struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		ChannelID            string      `json:"channelId"`
+		Title                string      `json:"title"`
+		CategoryID           string      `json:"categoryId"`
+		DefaultAudioLanguage string      `json:"defaultAudioLanguage"`
+		LiveBroadcastContent string      `json:"liveBroadcastContent"`
+		Thumbnails           thumbnailsT `json:"thumbnails"`
+	} `json:"snippet"`
+	LiveStreamingDetails struct {
+		// ISO-8601 date/time of the broadcast going live.
+		ActualStartTime string `json:"actualStartTime"`
+	} `json:"liveStreamingDetails"`
+}
+
+type channelResponseT struct {
+	Items []channelT `json:"items"`
+}
+
+type channelT struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title      string      `json:"title"`
+		CustomURL  string      `json:"customUrl"`
+		Thumbnails thumbnailsT `json:"thumbnails"`
+	} `json:"snippet"`
+}
+
+type thumbnailT struct {
+	URL string `json:"url"`
+}
+
+type thumbnailsT struct {
+	Default  *thumbnailT `json:"default"`
+	Medium   *thumbnailT `json:"medium"`
+	High     *thumbnailT `json:"high"`
+	Standard *thumbnailT `json:"standard"`
+	Maxres   *thumbnailT `json:"maxres"`
+}
+
+// best returns an URL of the largest available thumbnail.
+func (t *thumbnailsT) best() string {
+	for _, th := range []*thumbnailT{t.Maxres, t.Standard, t.High, t.Medium, t.Default} {
+		if th != nil {
+			return th.URL
+		}
+	}
+
+	return ""
+}
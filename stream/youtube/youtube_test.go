@@ -0,0 +1,265 @@
+package youtube_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeamTenuki/twiddler/clock"
+	"github.com/TeamTenuki/twiddler/stream/youtube"
+)
+
+func TestFetchMapsLiveBroadcasts(t *testing.T) {
+	api := newFakeAPI(t)
+
+	f := youtube.NewFetcher(youtube.Options{
+		APIKey:     "key",
+		ChannelIDs: []string{"UC1"},
+		BaseURL:    api.URL,
+	})
+
+	ss, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	if len(ss) != 1 {
+		t.Fatalf("Expected 1 stream, got %d", len(ss))
+	}
+
+	s := ss[0]
+	expect(t, "stream ID", s.ID, "video1")
+	expect(t, "title", s.Title, "Go lessons")
+	expect(t, "language", s.Language, "en")
+	expect(t, "thumbnail", s.ThumbnailURL.String(), "https://i.ytimg.com/vi/video1/maxresdefault_live.jpg")
+	expect(t, "started at", s.StartedAt.Format(time.RFC3339), "2024-05-01T10:00:00Z")
+	expect(t, "user ID", s.User.ID, "UC1")
+	expect(t, "user name", s.User.Name, "golessons")
+	expect(t, "display name", s.User.DisplayName, "Go Lessons")
+	expect(t, "channel URL", s.User.ChannelURL.String(), "https://www.youtube.com/channel/UC1")
+	expect(t, "picture URL", s.User.PictureURL.String(), "https://yt3.ggpht.com/UC1=s800")
+}
+
+func TestFetchSkipsFinishedBroadcasts(t *testing.T) {
+	api := newFakeAPI(t)
+
+	f := youtube.NewFetcher(youtube.Options{
+		APIKey:  "key",
+		Query:   "baduk",
+		BaseURL: api.URL,
+	})
+
+	ss, err := f.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	// Search yields video1 and video2, but the latter has already ended.
+	if len(ss) != 1 || ss[0].ID != "video1" {
+		t.Fatalf("Expected only video1, got %v", ss)
+	}
+}
+
+func TestFetchHonoursInterval(t *testing.T) {
+	api := newFakeAPI(t)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	defer clock.OverrideClock(nil)
+
+	f := youtube.NewFetcher(youtube.Options{
+		APIKey:     "key",
+		ChannelIDs: []string{"UC1"},
+		Interval:   time.Minute,
+		DailyQuota: 1000000,
+		BaseURL:    api.URL,
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := f.Fetch(context.Background()); err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+	}
+
+	fixedClock.Add(time.Minute)
+
+	if _, err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch failed: %s", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.searches != 2 {
+		t.Errorf("Expected 2 searches, got %d", api.searches)
+	}
+}
+
+func TestFetchFitsIntoDailyQuota(t *testing.T) {
+	api := newFakeAPI(t)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	defer clock.OverrideClock(nil)
+
+	f := youtube.NewFetcher(youtube.Options{
+		APIKey:     "key",
+		ChannelIDs: []string{"UC1", "UC2"},
+		Query:      "baduk",
+		Interval:   time.Minute,
+		BaseURL:    api.URL,
+	})
+
+	interval := youtube.MinInterval(3, youtube.DefaultDailyQuota)
+	if rounds := int(24 * time.Hour / interval); rounds*300 > youtube.DefaultDailyQuota {
+		t.Fatalf("Expected rounds of three searches to fit into the quota, got %d rounds a day", rounds)
+	}
+
+	for _, d := range []time.Duration{0, interval - time.Second, time.Second} {
+		fixedClock.Add(d)
+
+		if _, err := f.Fetch(context.Background()); err != nil {
+			t.Fatalf("Fetch failed: %s", err)
+		}
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.searches != 6 {
+		t.Errorf("Expected 2 rounds of 3 searches, got %d searches", api.searches)
+	}
+}
+
+func TestFetchReportsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]any{"error": map[string]any{"message": "quota exceeded"}})
+	}))
+	defer server.Close()
+
+	f := youtube.NewFetcher(youtube.Options{ChannelIDs: []string{"UC1"}, BaseURL: server.URL})
+
+	_, err := f.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("Expected quota error, got %v", err)
+	}
+}
+
+//
+// HELPERS
+//
+
+type fakeAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	searches int
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+
+	api := &fakeAPI{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", api.search)
+	mux.HandleFunc("/videos", api.videos)
+	mux.HandleFunc("/channels", api.channels)
+
+	api.Server = httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+func (a *fakeAPI) search(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.searches++
+	a.mu.Unlock()
+
+	q := r.URL.Query()
+	if q.Get("key") != "key" || q.Get("eventType") != "live" || q.Get("type") != "video" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	items := []any{video("video1")}
+	if q.Get("q") == "baduk" {
+		items = append(items, video("video2"))
+	}
+
+	writeJSON(w, map[string]any{"items": items})
+}
+
+func (a *fakeAPI) videos(w http.ResponseWriter, r *http.Request) {
+	items := make([]any, 0)
+
+	for _, id := range strings.Split(r.URL.Query().Get("id"), ",") {
+		switch id {
+		case "video1":
+			items = append(items, map[string]any{
+				"id": "video1",
+				"snippet": map[string]any{
+					"channelId":            "UC1",
+					"title":                "Go lessons",
+					"liveBroadcastContent": "live",
+					"defaultAudioLanguage": "en-US",
+					"thumbnails": map[string]any{
+						"default": map[string]string{"url": "https://i.ytimg.com/vi/video1/default_live.jpg"},
+						"maxres":  map[string]string{"url": "https://i.ytimg.com/vi/video1/maxresdefault_live.jpg"},
+					},
+				},
+				"liveStreamingDetails": map[string]any{"actualStartTime": "2024-05-01T10:00:00Z"},
+			})
+		case "video2":
+			items = append(items, map[string]any{
+				"id": "video2",
+				"snippet": map[string]any{
+					"channelId":            "UC2",
+					"title":                "Yesterday's game",
+					"liveBroadcastContent": "none",
+				},
+				"liveStreamingDetails": map[string]any{
+					"actualStartTime": "2024-04-30T10:00:00Z",
+					"actualEndTime":   "2024-04-30T12:00:00Z",
+				},
+			})
+		}
+	}
+
+	writeJSON(w, map[string]any{"items": items})
+}
+
+func (a *fakeAPI) channels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{"items": []any{
+		map[string]any{
+			"id": "UC1",
+			"snippet": map[string]any{
+				"title":     "Go Lessons",
+				"customUrl": "@golessons",
+				"thumbnails": map[string]any{
+					"high": map[string]string{"url": "https://yt3.ggpht.com/UC1=s800"},
+				},
+			},
+		},
+	}})
+}
+
+func video(id string) map[string]any {
+	return map[string]any{"id": map[string]string{"kind": "youtube#video", "videoId": id}}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func expect(t *testing.T, what, got, expected string) {
+	t.Helper()
+
+	if got != expected {
+		t.Errorf("Expected %s %q, got %q", what, expected, got)
+	}
+}
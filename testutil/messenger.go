@@ -2,6 +2,8 @@ package testutil
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/TeamTenuki/twiddler/messenger"
 	"github.com/TeamTenuki/twiddler/stream"
@@ -9,30 +11,81 @@ import (
 
 type MessengerStore struct {
 	Streams  []*stream.Stream
+	Updated  []UpdatedReport
+	Ended    []EndedReport
 	Messages []string
 }
 
+// UpdatedReport is a record of an UpdateStream call.
+type UpdatedReport struct {
+	MessageID string
+	Stream    stream.Stream
+}
+
+// EndedReport is a record of a MessageStreamEnded call.
+type EndedReport struct {
+	MessageID string
+	Stream    stream.Stream
+	EndedAt   time.Time
+}
+
 var _ messenger.Messenger = &Messenger{}
 
 type Messenger struct {
-	rooms   map[string]MessengerStore
-	awaiter chan struct{}
+	rooms    map[string]MessengerStore
+	failures map[string]error
+	awaiter  chan struct{}
 }
 
 func NewMessenger() *Messenger {
 	return &Messenger{
-		rooms:   make(map[string]MessengerStore),
-		awaiter: make(chan struct{}, 1000),
+		rooms:    make(map[string]MessengerStore),
+		failures: make(map[string]error),
+		awaiter:  make(chan struct{}, 1000),
 	}
 }
 
-func (r *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) error {
+// Fail makes announcements to a room fail with a given error.
+func (r *Messenger) Fail(roomID string, err error) {
+	r.failures[roomID] = err
+}
+
+func (r *Messenger) MessageStream(c context.Context, roomID string, s *stream.Stream) (string, error) {
+	if err := r.failures[roomID]; err != nil {
+		return "", err
+	}
+
 	store := r.rooms[roomID]
 	store.Streams = append(store.Streams, s)
 
 	r.rooms[roomID] = store
 	r.awaiter <- struct{}{}
 
+	return fmt.Sprintf("%s-%d", roomID, len(store.Streams)), nil
+}
+
+func (r *Messenger) UpdateStream(c context.Context, roomID, messageID string, s *stream.Stream) error {
+	store := r.rooms[roomID]
+	store.Updated = append(store.Updated, UpdatedReport{MessageID: messageID, Stream: *s})
+
+	r.rooms[roomID] = store
+	r.awaiter <- struct{}{}
+
+	return nil
+}
+
+func (r *Messenger) MessageStreamEnded(
+	c context.Context,
+	roomID, messageID string,
+	s *stream.Stream,
+	endedAt time.Time,
+) error {
+	store := r.rooms[roomID]
+	store.Ended = append(store.Ended, EndedReport{MessageID: messageID, Stream: *s, EndedAt: endedAt})
+
+	r.rooms[roomID] = store
+	r.awaiter <- struct{}{}
+
 	return nil
 }
 
@@ -63,6 +116,27 @@ func (r *Messenger) Close() error {
 	return nil
 }
 
+// Room yields what was sent to a room.
+func (r *Messenger) Room(roomID string) MessengerStore {
+	return r.rooms[roomID]
+}
+
 func (r *Messenger) AwaitReport() {
 	<-r.awaiter
 }
+
+var _ messenger.Handler = &Handler{}
+
+// Handler is a command handler that records the commands it handles as "sourceID: message".
+type Handler struct {
+	Handled chan string
+}
+
+func NewHandler() *Handler {
+	return &Handler{Handled: make(chan string, 10)}
+}
+
+func (h *Handler) Handle(c context.Context, sourceID, message string, m messenger.Messenger) error {
+	h.Handled <- sourceID + ": " + message
+	return nil
+}
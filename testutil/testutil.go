@@ -2,10 +2,12 @@ package testutil
 
 import (
 	"context"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream"
 )
 
 type report struct {
@@ -15,10 +17,10 @@ type report struct {
 	ObservedAt string `db:"observed_at"`
 }
 
-func VerifyObservedAt(t *testing.T, c context.Context, streamID string, startedAt time.Time, expectedTime time.Time) {
+func VerifyObservedAt(t *testing.T, c context.Context, service, streamID string, startedAt time.Time, expectedTime time.Time) {
 	t.Helper()
 
-	rep, err := db.ReportFor(c, streamID, startedAt)
+	rep, err := db.ReportFor(c, service, streamID, startedAt)
 	if err != nil {
 		t.Errorf("Failed to retrieve reports: %s", err)
 		return
@@ -42,3 +44,34 @@ func LogReports(t *testing.T, c context.Context) {
 		t.Logf("%#v", rep)
 	}
 }
+
+// SetupDB sets up an empty in-memory database, and returns a context with it.
+func SetupDB() context.Context {
+	db.MustInit(":memory:")
+
+	c := db.NewContext(context.Background())
+	db.SetupDB(c)
+
+	return c
+}
+
+// NewStream yields a sample Twitch stream with a given title, as announced by messengers.
+func NewStream(title string) *stream.Stream {
+	channelURL, _ := url.Parse("https://www.twitch.tv/sensei")
+	thumbnailURL, _ := url.Parse("https://example.com/thumbnail.jpg")
+
+	return &stream.Stream{
+		ID:           "stream1",
+		Service:      "twitch",
+		Title:        title,
+		Category:     "Go",
+		ThumbnailURL: thumbnailURL,
+		User: stream.User{
+			ID:          "user1",
+			Name:        "sensei",
+			DisplayName: "Go_Sensei",
+			ChannelURL:  channelURL,
+		},
+		StartedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 
 	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/events"
 	"github.com/TeamTenuki/twiddler/stream"
 	"github.com/TeamTenuki/twiddler/tracker"
 )
@@ -17,6 +18,10 @@ type Tracker struct {
 }
 
 func NewTracker() *Tracker {
+	return NewTrackerWithOptions(tracker.Options{})
+}
+
+func NewTrackerWithOptions(opts tracker.Options) *Tracker {
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
 
@@ -24,7 +29,7 @@ func NewTracker() *Tracker {
 	m := NewMessenger()
 	c := setupDB()
 
-	tracker := tracker.NewTracker(w, m)
+	tracker := tracker.NewTracker(events.NewFeed(w, events.Options{}), m, opts)
 	go func() {
 		tracker.Track(c)
 		wg.Done()
@@ -51,6 +56,11 @@ func (t *Tracker) CloseAndWait() {
 	t.wg.Wait()
 }
 
+// Fail makes announcements to a room fail with a given error.
+func (t *Tracker) Fail(roomID string, err error) {
+	t.m.Fail(roomID, err)
+}
+
 func (t *Tracker) Room(roomID string) MessengerStore {
 	return t.m.rooms[roomID]
 }
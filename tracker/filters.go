@@ -0,0 +1,48 @@
+package tracker
+
+import (
+	"strings"
+
+	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/stream"
+)
+
+// admits answers whether filters of a room let a stream be reported there.
+//
+// A stream is rejected by any matching blocked login or excluded keyword. Allowed logins,
+// included keywords and languages restrict reported streams to the ones matching
+// at least one filter of every such kind.
+func admits(filters []db.Filter, s *stream.Stream) bool {
+	login := strings.ToLower(s.User.Name)
+	title := strings.ToLower(s.Title)
+	language := strings.ToLower(s.Language)
+
+	restrictions := make(map[string]bool)
+
+	for _, f := range filters {
+		switch f.Kind {
+		case db.FilterBlock:
+			if login == f.Value {
+				return false
+			}
+		case db.FilterExclude:
+			if strings.Contains(title, f.Value) {
+				return false
+			}
+		case db.FilterAllow:
+			restrictions[f.Kind] = restrictions[f.Kind] || login == f.Value
+		case db.FilterInclude:
+			restrictions[f.Kind] = restrictions[f.Kind] || strings.Contains(title, f.Value)
+		case db.FilterLanguage:
+			restrictions[f.Kind] = restrictions[f.Kind] || language == f.Value
+		}
+	}
+
+	for _, satisfied := range restrictions {
+		if !satisfied {
+			return false
+		}
+	}
+
+	return true
+}
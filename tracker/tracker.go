@@ -3,171 +3,574 @@ package tracker
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/TeamTenuki/twiddler/clock"
 	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/events"
 	"github.com/TeamTenuki/twiddler/messenger"
 	"github.com/TeamTenuki/twiddler/stream"
-	"github.com/TeamTenuki/twiddler/watcher"
 )
 
+// DefaultRestartWindow is a default period within which a new stream of the same streamer
+// is considered a restart of the previous one.
+const DefaultRestartWindow = time.Hour
+
+// DefaultRefresh is a default period between edits of stream announcements
+// that keep their thumbnails fresh.
+const DefaultRefresh = 10 * time.Minute
+
+// EndedMode controls how streams that went offline are announced.
+type EndedMode int
+
+const (
+	// EndedPost posts a new message summarising an ended stream.
+	EndedPost EndedMode = iota
+
+	// EndedEdit edits the announcement of an ended stream into a summary.
+	EndedEdit
+
+	// EndedSilent doesn't announce ended streams.
+	EndedSilent
+)
+
+// Options control Tracker.
+type Options struct {
+	// Ended controls how streams that went offline are announced.
+	Ended EndedMode
+
+	// Refresh is a period between edits of announcements of live streams, which
+	// keep their thumbnails fresh (DefaultRefresh if zero, never if negative).
+	// Announcements are edited regardless whenever the title or category changes.
+	Refresh time.Duration
+
+	// RestartWindow is a period within which a new stream of the same streamer is considered
+	// a restart of the previous one, and isn't announced (DefaultRestartWindow if zero,
+	// none if negative). Rooms may override it with db.SettingRestartWindow.
+	RestartWindow time.Duration
+
+	// BackOnline makes suppressed restarts mentioned with a short message instead.
+	// Rooms may override it with db.SettingBackOnline.
+	BackOnline bool
+}
+
 type Tracker struct {
-	w      watcher.Watcher
-	m      messenger.Messenger
-	live   []stream.Stream
-	liveMu sync.RWMutex
+	w       events.Watcher
+	m       messenger.Messenger
+	opts    Options
+	live    []stream.Stream
+	liveMu  sync.RWMutex
+	tracked map[string]*trackedT
+	// pruned is set once announcements of streams that ended while twiddler was down are removed.
+	pruned bool
 	err    error
 }
 
-func NewTracker(w watcher.Watcher, m messenger.Messenger) *Tracker {
+// trackedT is a reported stream being tracked until it goes offline.
+type trackedT struct {
+	// s is the latest observed state of the stream. In case of a stream restart
+	// it's the restarted stream, but StartedAt is kept from the reported one.
+	s stream.Stream
+
+	// reportedID is an ID of the reported stream, which announcements are stored under.
+	reportedID string
+
+	// updatedAt is the time announcements of the stream were last posted or edited.
+	updatedAt time.Time
+
+	// dirty is set when the stream changed since its announcements were last edited.
+	dirty bool
+}
+
+func NewTracker(w events.Watcher, m messenger.Messenger, opts Options) *Tracker {
+	if opts.Refresh == 0 {
+		opts.Refresh = DefaultRefresh
+	}
+
+	switch {
+	case opts.RestartWindow == 0:
+		opts.RestartWindow = DefaultRestartWindow
+	case opts.RestartWindow < 0:
+		opts.RestartWindow = 0
+	}
+
 	return &Tracker{
-		w:    w,
-		m:    m,
-		live: make([]stream.Stream, 0),
+		w:       w,
+		m:       m,
+		opts:    opts,
+		live:    make([]stream.Stream, 0),
+		tracked: make(map[string]*trackedT),
 	}
 }
 
 func (t *Tracker) Track(c context.Context) {
 	go t.w.Watch(c)
 
-	for streams := range t.w.Source() {
-		// Update the info of the last time this stream was observed online.
-		t.updateObservedAt(c, streams)
+	for e := range t.w.Source() {
+		switch e := e.(type) {
+		case events.WentLive:
+			t.wentLive(c, &e.Stream, e.Parallel)
+		case events.Restarted:
+			t.restarted(c, &e.Stream, &e.Previous, e.LastSeen)
+		case events.TitleChanged:
+			t.changed(&e.Stream)
+		case events.CategoryChanged:
+			t.changed(&e.Stream)
+		case events.WentOffline:
+			if tr, exists := t.tracked[trackingKey(&e.Stream)]; exists {
+				t.end(c, tr, e.LastSeen)
+			}
+		case events.Snapshot:
+			t.snapshot(c, e)
+		}
+
+		if t.err != nil {
+			log.Printf("Failed to report the stream: %s", t.err)
+			t.err = nil
+		}
+	}
+}
+
+func (t *Tracker) wentLive(c context.Context, s *stream.Stream, parallel bool) {
+	// Streams announced before, e.g. by a previous run of twiddler, are tracked anew.
+	if t.adopt(c, s) {
+		return
+	}
+
+	p, ok := t.plan(c, s, parallel)
+	if !ok {
+		return
+	}
+
+	t.announce(c, s, p)
+	t.mentionBackOnline(c, s, p)
+}
+
+// mentionBackOnline tells the rooms of a plan which mention restarts that the streamer is back online.
+func (t *Tracker) mentionBackOnline(c context.Context, s *stream.Stream, p planT) {
+	for _, r := range p.backOnline {
+		if err := t.m.MessageText(c, r.ID, backOnlineText(s)); err != nil {
+			log.Printf("Failed to report the stream is back online: %s", err)
+		}
+	}
+}
+
+// restarted handles a stream of a tracked streamer that replaced their previous stream.
+// Unless it turns out to be a new stream, it's considered a continuation of the reported one.
+func (t *Tracker) restarted(c context.Context, s *stream.Stream, previous *stream.Stream, lastSeen time.Time) {
+	p, ok := t.plan(c, s, false)
+	if !ok {
+		return
+	}
+
+	tr, exists := t.tracked[trackingKey(previous)]
+
+	t.mentionBackOnline(c, s, p)
+
+	if len(p.rooms) > 0 {
+		// A new report supersedes a previous stream of the same streamer.
+		if exists {
+			t.handOver(c, tr, s, p.rooms, lastSeen)
+		}
+
+		t.announce(c, s, p)
+		return
+	}
+
+	t.store(c, s, p.restart)
 
-		reportable := t.excludeKnown(streams)
-		reportable = t.excludeReported(c, reportable)
-		reportable = t.excludeDuplicates(c, reportable)
+	if exists {
+		delete(t.tracked, trackingKey(previous))
+		t.tracked[trackingKey(s)] = tr
 
-		rooms, err := db.RoomsAll(c)
+		startedAt := tr.s.StartedAt
+		tr.s = *s
+		tr.s.StartedAt = startedAt
+		tr.dirty = true
+	}
+}
+
+// planT is a plan of reporting a stream that went live.
+type planT struct {
+	// rooms to announce the stream to.
+	rooms []db.Room
+
+	// backOnline are rooms to tell the streamer is back online, as the stream is a restart there.
+	backOnline []db.Room
+
+	// restart tells whether the stream is a restart according to the global restart window.
+	restart bool
+}
+
+// plan decides how to report a stream that went live. Rooms which filters reject
+// the stream aren't told about it at all.
+//
+// A stream parallel to other live streams of its streamer can't be a restart.
+//
+// It returns false if the stream shouldn't be considered at all, e.g. if it was reported before.
+func (t *Tracker) plan(c context.Context, s *stream.Stream, parallel bool) (planT, bool) {
+	// Do not report stream with the same stream ID twice.
+	if yes, _ := db.ReportWasReported(c, s.Service, s.ID); yes {
+		return planT{}, false
+	}
+
+	// If it is a stream restart (the stream ID has changed), check if
+	// this user's latest stream report has happened within a restart window.
+
+	dt, err := t.lastObservedTimeForUser(c, s)
+	if err != nil {
+		log.Printf("Failed to retrieve last report: %s", err)
+	}
+
+	all, err := db.RoomsAll(c)
+	if err != nil {
+		log.Printf("Failed to retrieve rooms: %s", err)
+		return planT{}, false
+	}
+
+	gap := clock.Since(dt)
+	if parallel {
+		gap = math.MaxInt64
+	}
+
+	p := planT{restart: gap <= t.opts.RestartWindow}
+
+	for _, r := range all {
+		filters, err := db.FiltersForRoom(c, r.ID)
 		if err != nil {
-			log.Printf("Failed to retrieve rooms: %s", err)
+			log.Printf("Failed to retrieve filters of room %s: %s", r.ID, err)
 			continue
 		}
 
-		for _, s := range reportable {
-			t.store(c, &s)
-			t.report(c, rooms, &s)
+		if !admits(filters, s) {
+			continue
+		}
 
-			if t.err != nil {
-				log.Printf("Failed to report the stream: %s", t.err)
-				t.err = nil
-			}
+		// Streams outside of the tracked categories only go to the rooms following their streamers.
+		if s.Followed && !t.follows(c, r.ID, s) {
+			continue
 		}
 
-		t.setLive(streams)
+		window, back := t.roomOptions(c, r.ID)
+
+		switch {
+		case gap > window:
+			p.rooms = append(p.rooms, r)
+		case back:
+			p.backOnline = append(p.backOnline, r)
+		}
 	}
+
+	return p, true
 }
 
-func (t *Tracker) Live() []stream.Stream {
-	t.liveMu.RLock()
-	defer t.liveMu.RUnlock()
+// follows answers whether a room follows the streamer of a stream.
+func (t *Tracker) follows(c context.Context, roomID string, s *stream.Stream) bool {
+	yes, err := db.FollowExists(c, db.Follow{
+		RoomID:  roomID,
+		Service: s.Service,
+		Login:   strings.ToLower(s.User.Name),
+	})
+	if err != nil {
+		log.Printf("Failed to retrieve follows of room %s: %s", roomID, err)
+	}
 
-	return t.live
+	return yes
 }
 
-func (t *Tracker) setLive(s []stream.Stream) {
-	t.liveMu.Lock()
-	t.live = s
-	t.liveMu.Unlock()
+// roomOptions yields the restart window and whether restarts are mentioned in a room.
+func (t *Tracker) roomOptions(c context.Context, roomID string) (time.Duration, bool) {
+	window, backOnline := t.opts.RestartWindow, t.opts.BackOnline
+
+	settings, err := db.RoomSettingsAll(c, roomID)
+	if err != nil {
+		log.Printf("Failed to retrieve settings of room %s: %s", roomID, err)
+		return window, backOnline
+	}
+
+	if value, exists := settings[db.SettingRestartWindow]; exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			window = d
+		} else {
+			log.Printf("Invalid restart window %q of room %s", value, roomID)
+		}
+	}
+
+	if value, exists := settings[db.SettingBackOnline]; exists {
+		backOnline = value == "on"
+	}
+
+	return window, backOnline
 }
 
-func (t *Tracker) store(c context.Context, s *stream.Stream) {
-	t.err = db.ReportStore(c, db.Report{
-		UserID:     s.User.ID,
-		StreamID:   s.ID,
-		StartedAt:  s.StartedAt,
-		ObservedAt: clock.NowUTC(),
-	})
+func (t *Tracker) changed(s *stream.Stream) {
+	if tr, exists := t.tracked[trackingKey(s)]; exists {
+		startedAt := tr.s.StartedAt
+		tr.s = *s
+		tr.s.StartedAt = startedAt
+		tr.dirty = true
+	}
 }
 
-func (t *Tracker) updateObservedAt(c context.Context, ss []stream.Stream) {
-	streamIDs := make([]string, len(ss))
-	for i := range ss {
-		streamIDs[i] = ss[i].ID
+// snapshot keeps the live streams up to date, and edits announcements of the streams
+// that changed, as well as the ones due for a refresh.
+func (t *Tracker) snapshot(c context.Context, e events.Snapshot) {
+	// Update the info of the last time this stream was observed online.
+	t.updateObservedAt(c, e.Streams, e.At)
+
+	for _, tr := range t.tracked {
+		stale := t.opts.Refresh > 0 && e.At.Sub(tr.updatedAt) >= t.opts.Refresh
+		if tr.dirty || stale {
+			t.update(c, tr)
+			tr.updatedAt = e.At
+			tr.dirty = false
+		}
+	}
+
+	if !t.pruned {
+		t.prune(c)
+		t.pruned = true
 	}
 
-	t.err = db.ReportObserveForStreams(c, streamIDs, clock.NowUTC())
+	t.setLive(e.Streams)
 }
 
-func (t *Tracker) report(c context.Context, rs []db.Room, s *stream.Stream) {
-	if t.err != nil {
+// prune removes announcements of the streams that aren't tracked, i.e. the ones that
+// ended while twiddler was down. Streams still live are adopted before the first snapshot.
+func (t *Tracker) prune(c context.Context) {
+	streams, err := db.MessageStreams(c)
+	if err != nil {
+		log.Printf("Failed to retrieve announcements: %s", err)
 		return
 	}
 
-	for _, r := range rs {
-		if err := t.m.MessageStream(c, r.ID, s); err != nil {
-			t.err = err
+	reported := make(map[db.StreamRef]bool, len(t.tracked))
+	for _, tr := range t.tracked {
+		reported[db.StreamRef{Service: tr.s.Service, StreamID: tr.reportedID}] = true
+	}
+
+	for _, s := range streams {
+		if reported[s] {
+			continue
+		}
 
-			break
+		if err := db.MessagesDeleteForStream(c, s.Service, s.StreamID); err != nil {
+			log.Printf("Failed to remove announcements: %s", err)
 		}
 	}
 }
 
-func (t *Tracker) excludeKnown(ss []stream.Stream) []stream.Stream {
-	unknown := make([]stream.Stream, 0)
+// adopt starts tracking a stream if it was announced before.
+func (t *Tracker) adopt(c context.Context, s *stream.Stream) bool {
+	messages, err := db.MessagesForStream(c, s.Service, s.ID)
+	if err != nil {
+		log.Printf("Failed to retrieve announcements: %s", err)
+		return false
+	}
 
-outer:
-	for _, s := range ss {
-		for _, known := range t.Live() {
-			if s.ID == known.ID {
-				continue outer
-			}
-		}
+	if len(messages) == 0 {
+		return false
+	}
+
+	t.tracked[trackingKey(s)] = &trackedT{
+		s:          *s,
+		reportedID: s.ID,
+		updatedAt:  clock.NowUTC(),
+	}
 
-		unknown = append(unknown, s)
+	return true
+}
+
+// announce stores a stream, reports it to the planned rooms, and starts tracking it
+// if there are any.
+func (t *Tracker) announce(c context.Context, s *stream.Stream, p planT) {
+	now := clock.NowUTC()
+
+	t.store(c, s, p.restart)
+	if len(p.rooms) == 0 {
+		return
 	}
 
-	return unknown
+	t.report(c, p.rooms, s)
+
+	t.tracked[trackingKey(s)] = &trackedT{
+		s:          *s,
+		reportedID: s.ID,
+		updatedAt:  now,
+	}
 }
 
-func (t *Tracker) excludeReported(c context.Context, ss []stream.Stream) []stream.Stream {
-	reportable := make([]stream.Stream, 0)
+// update edits announcements of a tracked stream to its latest state.
+func (t *Tracker) update(c context.Context, tr *trackedT) {
+	messages, err := db.MessagesForStream(c, tr.s.Service, tr.reportedID)
+	if err != nil {
+		log.Printf("Failed to retrieve announcements: %s", err)
+		return
+	}
 
-	for _, s := range ss {
-		// Do not report stream with the same stream ID twice.
-		if yes, _ := db.ReportWasReported(c, s.ID); yes {
+	for _, m := range messages {
+		// Messengers without a notion of message IDs can't edit anything.
+		if m.MessageID == "" {
 			continue
 		}
 
-		// If it is a stream restart (the stream ID has changed), check if
-		// this user's latest stream report has happened less than an hour ago.
+		if err := t.m.UpdateStream(c, m.RoomID, m.MessageID, &tr.s); err != nil {
+			log.Printf("Failed to update the announcement: %s", err)
+		}
+	}
+}
 
-		dt, err := t.lastObservedTimeForUser(c, &s)
-		if err != nil {
-			log.Printf("Failed to retrieve last report: %s", err)
+// end stops tracking a stream and announces it has ended at a given time.
+func (t *Tracker) end(c context.Context, tr *trackedT, endedAt time.Time) {
+	delete(t.tracked, trackingKey(&tr.s))
+
+	messages, err := db.MessagesForStream(c, tr.s.Service, tr.reportedID)
+	if err != nil {
+		log.Printf("Failed to retrieve announcements: %s", err)
+		return
+	}
+
+	for _, m := range messages {
+		t.endMessage(c, m, &tr.s, endedAt)
+	}
+
+	if err := db.MessagesDeleteForStream(c, tr.s.Service, tr.reportedID); err != nil {
+		log.Printf("Failed to remove announcements: %s", err)
+	}
+}
+
+// handOver stops tracking a stream superseded by a new one. The previous stream is ended
+// in the rooms the new one is announced to, while the rest of the rooms keep its
+// announcements for the new stream.
+func (t *Tracker) handOver(c context.Context, tr *trackedT, s *stream.Stream, rooms []db.Room, endedAt time.Time) {
+	delete(t.tracked, trackingKey(&tr.s))
+
+	messages, err := db.MessagesForStream(c, tr.s.Service, tr.reportedID)
+	if err != nil {
+		log.Printf("Failed to retrieve announcements: %s", err)
+		return
+	}
+
+outer:
+	for _, m := range messages {
+		for _, r := range rooms {
+			if m.RoomID == r.ID {
+				t.endMessage(c, m, &tr.s, endedAt)
+				continue outer
+			}
 		}
 
-		if clock.Since(dt) > time.Hour {
-			reportable = append(reportable, s)
-		} else {
-			// Even though it isn't reportable, store it anyway, so it won't get
-			// reported later.
-			t.store(c, &s)
+		m.StreamID = s.ID
+		if err := db.MessageStore(c, m); err != nil {
+			log.Printf("Failed to hand the announcement over: %s", err)
 		}
 	}
 
-	return reportable
+	if err := db.MessagesDeleteForStream(c, tr.s.Service, tr.reportedID); err != nil {
+		log.Printf("Failed to remove announcements: %s", err)
+	}
+}
+
+// endMessage announces a stream has ended in the room of its announcement.
+func (t *Tracker) endMessage(c context.Context, m db.Message, s *stream.Stream, endedAt time.Time) {
+	if t.opts.Ended == EndedSilent {
+		return
+	}
+
+	messageID := m.MessageID
+	if t.opts.Ended != EndedEdit {
+		messageID = ""
+	}
+
+	if err := t.m.MessageStreamEnded(c, m.RoomID, messageID, s, endedAt); err != nil {
+		log.Printf("Failed to report the stream end: %s", err)
+	}
+}
+
+func trackingKey(s *stream.Stream) string {
+	return s.Service + "/" + s.ID
+}
+
+// backOnlineText formats a low-key mention of a restarted stream.
+func backOnlineText(s *stream.Stream) string {
+	if s.User.ChannelURL == nil {
+		return fmt.Sprintf("%s is back online", s.User.DisplayName)
+	}
+
+	return fmt.Sprintf("%s is back online: <%s>", s.User.DisplayName, s.User.ChannelURL)
+}
+
+func (t *Tracker) Live() []stream.Stream {
+	t.liveMu.RLock()
+	defer t.liveMu.RUnlock()
+
+	return t.live
+}
+
+func (t *Tracker) setLive(s []stream.Stream) {
+	t.liveMu.Lock()
+	t.live = s
+	t.liveMu.Unlock()
+}
+
+func (t *Tracker) store(c context.Context, s *stream.Stream, restart bool) {
+	t.err = db.ReportStore(c, db.Report{
+		Service:    s.Service,
+		UserID:     s.User.ID,
+		StreamID:   s.ID,
+		StartedAt:  s.StartedAt,
+		ObservedAt: clock.NowUTC(),
+		Restart:    restart,
+	})
 }
 
-func (t *Tracker) excludeDuplicates(c context.Context, ss []stream.Stream) []stream.Stream {
-	reportable := make([]stream.Stream, 0)
-	seen := make(map[stream.Stream]struct{})
+func (t *Tracker) updateObservedAt(c context.Context, ss []stream.Stream, at time.Time) {
+	streams := make([]db.StreamRef, len(ss))
+	for i := range ss {
+		streams[i] = db.StreamRef{Service: ss[i].Service, StreamID: ss[i].ID}
+	}
+
+	t.err = db.ReportObserveForStreams(c, streams, at)
+}
 
-	for _, s := range ss {
-		if _, yes := seen[s]; !yes {
-			reportable = append(reportable, s)
-			seen[s] = struct{}{}
+func (t *Tracker) report(c context.Context, rs []db.Room, s *stream.Stream) {
+	if t.err != nil {
+		return
+	}
+
+	// A failing room, e.g. a deleted one, doesn't keep the rest from being told.
+	errs := make([]error, 0)
+
+	for _, r := range rs {
+		messageID, err := t.m.MessageStream(c, r.ID, s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("room %s: %w", r.ID, err))
+			continue
+		}
+
+		// Remember the announcement, so it can be edited later on.
+		err = db.MessageStore(c, db.Message{
+			RoomID:    r.ID,
+			Service:   s.Service,
+			StreamID:  s.ID,
+			MessageID: messageID,
+		})
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	return reportable
+	t.err = errors.Join(errs...)
 }
 
 func (t *Tracker) lastObservedTimeForUser(c context.Context, s *stream.Stream) (time.Time, error) {
-	report, err := db.ReportLatestByUser(c, s.User.ID)
+	report, err := db.ReportLatestByUser(c, s.Service, s.User.ID)
 
 	switch {
 	default:
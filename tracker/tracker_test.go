@@ -2,13 +2,16 @@ package tracker_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/TeamTenuki/twiddler/clock"
 	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/events"
 	"github.com/TeamTenuki/twiddler/stream"
 	"github.com/TeamTenuki/twiddler/testutil"
+	"github.com/TeamTenuki/twiddler/tracker"
 )
 
 func TestStreamIsReported(t *testing.T) {
@@ -245,29 +248,506 @@ func TestObservedTimeIsUpdatedWhenSeeingStreamAgain(t *testing.T) {
 		{
 			User:      stream.User{ID: "user1"},
 			ID:        streamID,
+			Service:   "twitch",
 			StartedAt: startedAt,
 		},
 	})
 
 	tr.AwaitReport()
-	testutil.VerifyObservedAt(t, tr.C, streamID, startedAt, observed1)
+	testutil.VerifyObservedAt(t, tr.C, "twitch", streamID, startedAt, observed1)
 	fixedClock.Add(time.Hour)
 
 	tr.Send([]stream.Stream{
 		{
 			User:      stream.User{ID: "user1"},
 			ID:        streamID,
+			Service:   "twitch",
 			StartedAt: startedAt,
 		},
 	})
 
 	tr.CloseAndWait()
-	testutil.VerifyObservedAt(t, tr.C, streamID, startedAt, observed2)
+	testutil.VerifyObservedAt(t, tr.C, "twitch", streamID, startedAt, observed2)
 
 	store := tr.Room("room1")
 	expectStreamReports(t, store.Streams, "stream1")
 }
 
+func TestStreamEndIsReportedAfterGracePeriod(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	startedAt := clock.NowUTC()
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: startedAt,
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(time.Minute)
+	tr.Send([]stream.Stream{})
+
+	fixedClock.Add(events.DefaultOfflineGrace)
+	tr.Send([]stream.Stream{})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1")
+	expectEndedReports(t, store.Ended, "stream1")
+
+	if len(store.Ended) == 1 {
+		ended := store.Ended[0]
+		if !ended.EndedAt.Equal(startedAt) {
+			t.Errorf("Expected stream to end when last seen at %s, got %s", startedAt, ended.EndedAt)
+		}
+		if ended.MessageID != "" {
+			t.Errorf("Expected a new message, got edit of %q", ended.MessageID)
+		}
+	}
+}
+
+func TestFailingRoomDoesNotKeepOthersFromReports(t *testing.T) {
+	tr := testutil.NewTracker()
+	db.FromContext(tr.C).MustExec(`INSERT INTO [rooms] ([room_id]) VALUES ('room0')`)
+	setupDB(tr.C)
+	tr.Fail("room0", errors.New("room is gone"))
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	tr.CloseAndWait()
+
+	expectStreamReports(t, tr.Room("room1").Streams, "stream1")
+	expectAnnouncedStreams(t, tr.C, "stream1")
+
+	messages, _ := db.MessagesForStream(tr.C, "", "stream1")
+	if len(messages) != 1 || messages[0].RoomID != "room1" {
+		t.Errorf("Expected an announcement in room1 only, got %+v", messages)
+	}
+}
+
+func TestAnnouncementsAreForgottenWhenStreamEnds(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(events.DefaultOfflineGrace + time.Minute)
+	tr.Send([]stream.Stream{})
+
+	tr.CloseAndWait()
+
+	expectAnnouncedStreams(t, tr.C)
+}
+
+func TestAnnouncementsOfStreamsEndedMeanwhileArePruned(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	db.MessageStore(tr.C, db.Message{RoomID: "room1", StreamID: "stream0", MessageID: "old"})
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	tr.CloseAndWait()
+
+	expectAnnouncedStreams(t, tr.C, "stream1")
+}
+
+func TestStreamMissingWithinGracePeriodIsNotEnded(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	startedAt := clock.NowUTC()
+
+	live := []stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: startedAt,
+		},
+	}
+
+	tr.Send(live)
+	tr.AwaitReport()
+
+	fixedClock.Add(time.Minute)
+	tr.Send([]stream.Stream{})
+	fixedClock.Add(time.Minute)
+	tr.Send(live)
+	fixedClock.Add(events.DefaultOfflineGrace)
+	tr.Send(live)
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1")
+	expectEndedReports(t, store.Ended)
+}
+
+func TestStreamEndEditsAnnouncement(t *testing.T) {
+	tr := testutil.NewTrackerWithOptions(tracker.Options{Ended: tracker.EndedEdit})
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(events.DefaultOfflineGrace + time.Minute)
+	tr.Send([]stream.Stream{})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectEndedReports(t, store.Ended, "stream1")
+
+	if len(store.Ended) == 1 && store.Ended[0].MessageID != "room1-1" {
+		t.Errorf("Expected edit of the announcement, got %q", store.Ended[0].MessageID)
+	}
+}
+
+func TestRestartedStreamIsEndedOnce(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+	startedAt := clock.NowUTC()
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			Title:     "First",
+			StartedAt: startedAt,
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(2 * time.Minute)
+
+	// The stream of user2 is reported after the restart is observed, which lets
+	// the clock be moved safely.
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream2",
+			Title:     "Second",
+			StartedAt: clock.NowUTC(),
+		},
+		{
+			User:      stream.User{ID: "user2"},
+			ID:        "stream3",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(events.DefaultOfflineGrace + time.Minute)
+	tr.Send([]stream.Stream{})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1", "stream3")
+	expectEndedReports(t, store.Ended, "stream2", "stream3")
+
+	for _, e := range store.Ended {
+		if e.Stream.ID == "stream2" && (e.Stream.Title != "Second" || !e.Stream.StartedAt.Equal(startedAt)) {
+			t.Errorf("Expected summary of the whole stream, got %q started at %s", e.Stream.Title, e.Stream.StartedAt)
+		}
+	}
+}
+
+func TestRestartWithoutGapIsMentioned(t *testing.T) {
+	tr := testutil.NewTrackerWithOptions(tracker.Options{BackOnline: true})
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(2 * time.Minute)
+
+	// The stream ID changes without the streamer going offline in between.
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream2",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1")
+
+	if len(store.Messages) != 1 || store.Messages[0] != "User1 is back online" {
+		t.Errorf("Expected a back online message, got %q", store.Messages)
+	}
+}
+
+func TestParallelStreamsAreReportedSeparately(t *testing.T) {
+	tr := testutil.NewTrackerWithOptions(tracker.Options{BackOnline: true})
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	// A streamer broadcasts twice at the same time, e.g. on two YouTube streams.
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream2",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	tr.AwaitReport()
+	fixedClock.Add(events.DefaultOfflineGrace + time.Minute)
+	tr.Send([]stream.Stream{})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1", "stream2")
+	expectEndedReports(t, store.Ended, "stream1", "stream2")
+
+	if len(store.Messages) != 0 {
+		t.Errorf("Expected no back online messages, got %q", store.Messages)
+	}
+}
+
+func TestAnnouncementIsEditedOnTitleChange(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	clock.OverrideByFixed(time.Now())
+
+	s := stream.Stream{
+		User:      stream.User{ID: "user1"},
+		ID:        "stream1",
+		Title:     "First",
+		StartedAt: clock.NowUTC(),
+	}
+
+	tr.Send([]stream.Stream{s})
+	tr.AwaitReport()
+
+	tr.Send([]stream.Stream{s})
+	s.Title = "Second"
+	tr.Send([]stream.Stream{s})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	if len(store.Updated) != 1 {
+		t.Fatalf("Expected 1 update, got %d", len(store.Updated))
+	}
+
+	if u := store.Updated[0]; u.MessageID != "room1-1" || u.Stream.Title != "Second" {
+		t.Errorf("Expected edit of %q with the new title, got edit of %q with %q", "room1-1", u.MessageID, u.Stream.Title)
+	}
+}
+
+func TestAnnouncementIsRefreshedPeriodically(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	live := []stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	}
+
+	tr.Send(live)
+	tr.AwaitReport()
+
+	fixedClock.Add(time.Minute)
+	tr.Send(live)
+
+	// The refresh is spread over several polls, so the stream isn't considered ended.
+	for i := time.Minute; i < tracker.DefaultRefresh; i += time.Minute {
+		fixedClock.Add(time.Minute)
+		tr.Send(live)
+	}
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	if len(store.Updated) != 1 {
+		t.Errorf("Expected 1 refresh, got %d", len(store.Updated))
+	}
+}
+
+func TestRestartWindowOfRoomOverridesDefault(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	db.FromContext(tr.C).MustExec(`INSERT INTO [rooms] ([room_id]) VALUES ('room2')`)
+	db.RoomSettingStore(tr.C, "room2", db.SettingRestartWindow, "10m")
+
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	tr.AwaitReport()
+	fixedClock.Add(30 * time.Minute)
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1"},
+			ID:        "stream2",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.CloseAndWait()
+
+	expectStreamReports(t, tr.Room("room1").Streams, "stream1")
+	expectStreamReports(t, tr.Room("room2").Streams, "stream1", "stream2")
+	expectEndedReports(t, tr.Room("room1").Ended)
+	expectEndedReports(t, tr.Room("room2").Ended, "stream1")
+}
+
+func TestSuppressedRestartIsStoredAndMentioned(t *testing.T) {
+	tr := testutil.NewTrackerWithOptions(tracker.Options{BackOnline: true})
+	setupDB(tr.C)
+	fixedClock := clock.OverrideByFixed(time.Now())
+
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream1",
+			StartedAt: clock.NowUTC(),
+		},
+	})
+
+	tr.AwaitReport()
+	fixedClock.Add(10 * time.Minute)
+	tr.Send([]stream.Stream{})
+
+	startedAt := clock.NowUTC()
+	tr.Send([]stream.Stream{
+		{
+			User:      stream.User{ID: "user1", DisplayName: "User1"},
+			ID:        "stream2",
+			StartedAt: startedAt,
+		},
+	})
+
+	tr.CloseAndWait()
+
+	store := tr.Room("room1")
+	expectStreamReports(t, store.Streams, "stream1")
+
+	if len(store.Messages) != 1 || store.Messages[0] != "User1 is back online" {
+		t.Errorf("Expected a back online message, got %q", store.Messages)
+	}
+
+	report, err := db.ReportFor(tr.C, "", "stream2", startedAt)
+	if err != nil {
+		t.Fatalf("Failed to retrieve the report: %s", err)
+	}
+
+	if !report.Restart {
+		t.Errorf("Expected the report to be a restart")
+	}
+}
+
+func TestFiltersOfRoomAreApplied(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+
+	filters := []db.Filter{
+		{RoomID: "room1", Kind: db.FilterBlock, Value: "troll"},
+		{RoomID: "room1", Kind: db.FilterExclude, Value: "rerun"},
+		{RoomID: "room1", Kind: db.FilterLanguage, Value: "en"},
+		{RoomID: "room1", Kind: db.FilterLanguage, Value: "ko"},
+	}
+
+	for _, f := range filters {
+		if err := db.FilterStore(tr.C, f); err != nil {
+			t.Fatalf("Failed to store filter: %s", err)
+		}
+	}
+
+	tr.Send([]stream.Stream{
+		{User: stream.User{ID: "user1", Name: "Troll"}, ID: "stream1", Language: "en"},
+		{User: stream.User{ID: "user2", Name: "player"}, ID: "stream2", Title: "[RERUN] Finals", Language: "en"},
+		{User: stream.User{ID: "user3", Name: "player"}, ID: "stream3", Title: "Finals", Language: "ja"},
+		{User: stream.User{ID: "user4", Name: "player"}, ID: "stream4", Title: "Finals", Language: "ko"},
+	})
+
+	tr.CloseAndWait()
+
+	expectStreamReports(t, tr.Room("room1").Streams, "stream4")
+}
+
+func TestFollowedStreamIsReportedToFollowingRoomsOnly(t *testing.T) {
+	tr := testutil.NewTracker()
+	setupDB(tr.C)
+	db.FromContext(tr.C).MustExec(`INSERT INTO [rooms] ([room_id]) VALUES ('room2')`)
+	db.FollowStore(tr.C, db.Follow{RoomID: "room2", Service: "twitch", Login: "player"})
+
+	tr.Send([]stream.Stream{
+		{Service: "twitch", User: stream.User{ID: "user1", Name: "Player"}, ID: "stream1", Followed: true},
+		{Service: "twitch", User: stream.User{ID: "user2", Name: "other"}, ID: "stream2"},
+	})
+
+	tr.CloseAndWait()
+
+	expectStreamReports(t, tr.Room("room1").Streams, "stream2")
+	expectStreamReports(t, tr.Room("room2").Streams, "stream1", "stream2")
+}
+
 //
 // HELPERS
 //
@@ -290,6 +770,44 @@ func expectStreamReports(t *testing.T, ss []*stream.Stream, ids ...string) {
 	}
 }
 
+func expectEndedReports(t *testing.T, es []testutil.EndedReport, ids ...string) {
+	t.Helper()
+
+	if len(ids) != len(es) {
+		t.Errorf("Expected %d ended reports got %d", len(ids), len(es))
+	}
+
+	for _, e := range es {
+		exists := false
+		for _, id := range ids {
+			exists = exists || e.Stream.ID == id
+		}
+
+		if !exists {
+			t.Errorf("Expected ended report for stream ID %q", e.Stream.ID)
+		}
+	}
+}
+
+func expectAnnouncedStreams(t *testing.T, c context.Context, ids ...string) {
+	t.Helper()
+
+	streams, err := db.MessageStreams(c)
+	if err != nil {
+		t.Fatalf("Failed to retrieve announcements: %s", err)
+	}
+
+	if len(streams) != len(ids) {
+		t.Fatalf("Expected announcements of %v, got %v", ids, streams)
+	}
+
+	for i := range ids {
+		if streams[i].StreamID != ids[i] {
+			t.Errorf("Expected announcements of %v, got %v", ids, streams)
+		}
+	}
+}
+
 //
 // DB
 //
@@ -2,13 +2,28 @@ package twiddler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"github.com/TeamTenuki/twiddler/commands"
 	"github.com/TeamTenuki/twiddler/config"
 	"github.com/TeamTenuki/twiddler/db"
+	"github.com/TeamTenuki/twiddler/events"
+	"github.com/TeamTenuki/twiddler/messenger"
 	"github.com/TeamTenuki/twiddler/messenger/discord"
+	"github.com/TeamTenuki/twiddler/messenger/irc"
+	"github.com/TeamTenuki/twiddler/messenger/matrix"
+	"github.com/TeamTenuki/twiddler/messenger/slack"
+	"github.com/TeamTenuki/twiddler/messenger/telegram"
+	msgwebhook "github.com/TeamTenuki/twiddler/messenger/webhook"
+	"github.com/TeamTenuki/twiddler/stream"
 	"github.com/TeamTenuki/twiddler/stream/twitch"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub"
+	"github.com/TeamTenuki/twiddler/stream/twitch/eventsub/webhook"
+	"github.com/TeamTenuki/twiddler/stream/youtube"
 	"github.com/TeamTenuki/twiddler/tracker"
 	"github.com/TeamTenuki/twiddler/watcher"
 )
@@ -19,21 +34,225 @@ import (
 func Run(c context.Context, config *config.Config) error {
 	db.SetupDB(c)
 
-	m, err := discord.NewMessenger(config.DiscordAPI)
+	m, err := newMessenger(config)
+	if err != nil {
+		return err
+	}
+
+	tf := twitch.NewFetcher(c, config.TwitchClientID, config.TwitchSecret, twitch.Options{
+		GameIDs:   config.TwitchGameIDs,
+		GameNames: config.TwitchGames,
+		MaxPages:  config.TwitchMaxPages,
+		UserCache: db.NewUserCache(twitch.ServiceName, twitch.UserTTL),
+		Followed: func(c context.Context) ([]string, error) {
+			return db.FollowedLogins(c, twitch.ServiceName)
+		},
+	})
+
+	var f stream.Fetcher = tf
+	if config.YouTubeAPIKey != "" {
+		yf := youtube.NewFetcher(youtube.Options{
+			APIKey:     config.YouTubeAPIKey,
+			ChannelIDs: config.YouTubeChannelIDs,
+			Query:      config.YouTubeQuery,
+			Interval:   time.Duration(config.YouTubeInterval),
+			DailyQuota: config.YouTubeDailyQuota,
+			UserCache:  db.NewUserCache(youtube.ServiceName, youtube.UserTTL),
+		})
+
+		f = stream.Multi(
+			stream.Service{Name: twitch.ServiceName, Fetcher: tf},
+			stream.Service{Name: youtube.ServiceName, Fetcher: yf},
+		)
+	}
+
+	w, err := newWatcher(config, tf, f)
 	if err != nil {
 		return err
 	}
 
-	f := twitch.NewFetcher(c, config.TwitchClientID, config.TwitchSecret)
-	w := watcher.Periodic(f, 8*time.Second)
-	t := tracker.NewTracker(w, m)
+	ended, err := endedMode(config.EndedMessages)
+	if err != nil {
+		return err
+	}
+
+	// Events of the watcher are published on the bus, which any number of consumers
+	// may subscribe to. The tracker can't afford to miss any.
+	bus := events.NewBus(events.NewFeed(w, events.Options{OfflineGrace: time.Duration(config.OfflineGrace)}))
+
+	t := tracker.NewTracker(bus.Subscribe(events.SubscribeOptions{Name: "tracker"}), m, tracker.Options{
+		Ended:         ended,
+		Refresh:       time.Duration(config.AnnouncementRefresh),
+		RestartWindow: time.Duration(config.RestartWindow),
+		BackOnline:    config.BackOnlineMessages,
+	})
 
-	m.AddCommandHandler(c, commands.NewHandler(t))
+	// Rooms of webhooks are configured, as they receive no commands to be added with.
+	if err := m.RegisterRooms(c); err != nil {
+		return err
+	}
+
+	m.AddCommandHandler(c, commands.NewHandler(t, commands.Options{
+		Templates:           config.Templates,
+		ConfiguredPlatforms: []string{discord.WebhookPlatformName, msgwebhook.PlatformName},
+	}))
 	if err := m.Run(); err != nil {
 		return err
 	}
 
+	go bus.Watch(c)
 	t.Track(c)
 
 	return m.Close()
 }
+
+// newMessenger creates messengers of all the configured platforms, and a router between them.
+func newMessenger(config *config.Config) (*messenger.Router, error) {
+	var backends []messenger.Backend
+	add := func(platform string, m messenger.Messenger, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to create %s messenger: %w", platform, err)
+		}
+
+		backends = append(backends, messenger.Backend{Platform: platform, Messenger: m})
+
+		return nil
+	}
+
+	if config.DiscordAPI != "" {
+		m, err := discord.NewMessenger(config.DiscordAPI, discord.Options{Templates: config.Templates})
+		if err := add(discord.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.DiscordWebhooks) > 0 {
+		m, err := discord.NewWebhookMessenger(discord.WebhookOptions{
+			URLs:      config.DiscordWebhooks,
+			Templates: config.Templates,
+		})
+		if err := add(discord.WebhookPlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.SlackBotToken != "" {
+		m, err := slack.NewMessenger(slack.Options{
+			BotToken:  config.SlackBotToken,
+			AppToken:  config.SlackAppToken,
+			Templates: config.Templates,
+		})
+		if err := add(slack.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.TelegramToken != "" {
+		m, err := telegram.NewMessenger(telegram.Options{
+			Token:     config.TelegramToken,
+			Templates: config.Templates,
+		})
+		if err := add(telegram.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MatrixHomeserver != "" {
+		m, err := matrix.NewMessenger(matrix.Options{
+			HomeserverURL: config.MatrixHomeserver,
+			AccessToken:   config.MatrixAccessToken,
+			UserID:        config.MatrixUserID,
+			Templates:     config.Templates,
+		})
+		if err := add(matrix.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.IRCAddr != "" {
+		m, err := irc.NewMessenger(irc.Options{
+			Addr:         config.IRCAddr,
+			TLS:          config.IRCTLS,
+			Nick:         config.IRCNick,
+			Password:     config.IRCPassword,
+			SASLUser:     config.IRCSASLUser,
+			SASLPassword: config.IRCSASLPassword,
+			Channels:     config.IRCChannels,
+			Templates:    config.Templates,
+		})
+		if err := add(irc.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Webhooks) > 0 {
+		m, err := msgwebhook.NewMessenger(msgwebhook.Options{
+			URLs:   config.Webhooks,
+			Secret: config.WebhookSecret,
+		})
+		if err := add(msgwebhook.PlatformName, m, err); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(backends) == 0 {
+		return nil, errors.New("no messengers configured")
+	}
+
+	fallback := config.DefaultPlatform
+	if fallback == "" {
+		fallback = backends[0].Platform
+	}
+
+	return messenger.NewRouter(fallback, backends...)
+}
+
+// newWatcher creates a watcher according to the configured Twitch watch mode.
+// Fetcher f is polled in the default mode, while EventSub modes rely on Twitch fetcher tf.
+func newWatcher(config *config.Config, tf *twitch.Fetcher, f stream.Fetcher) (watcher.Watcher, error) {
+	switch config.TwitchWatchMode {
+	case "", "poll":
+		return watcher.Periodic(f, 8*time.Second), nil
+
+	case "eventsub":
+		client := &eventsub.Client{
+			ClientID:    config.TwitchClientID,
+			TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.TwitchUserToken}),
+		}
+
+		return eventsub.NewWebSocket(tf, client, eventsub.WebSocketOptions{
+			Broadcasters: config.TwitchBroadcasters,
+			Reconcile:    time.Duration(config.TwitchReconcileInterval),
+		}), nil
+
+	case "webhook":
+		client := &eventsub.Client{
+			ClientID:    config.TwitchClientID,
+			TokenSource: tf.TokenSource(),
+		}
+
+		return webhook.NewWatcher(tf, client, webhook.Options{
+			Broadcasters: config.TwitchBroadcasters,
+			Reconcile:    time.Duration(config.TwitchReconcileInterval),
+			Callback:     config.TwitchWebhookCallback,
+			Secret:       config.TwitchWebhookSecret,
+			Addr:         config.TwitchWebhookAddr,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unknown Twitch watch mode %q", config.TwitchWatchMode)
+}
+
+// endedMode parses the configured way of announcing ended streams.
+func endedMode(mode string) (tracker.EndedMode, error) {
+	switch mode {
+	case "", "post":
+		return tracker.EndedPost, nil
+	case "edit":
+		return tracker.EndedEdit, nil
+	case "silent":
+		return tracker.EndedSilent, nil
+	}
+
+	return 0, fmt.Errorf("unknown ended messages mode %q", mode)
+}
@@ -68,10 +68,5 @@ func (p *periodicT) check(c context.Context) {
 }
 
 func (p *periodicT) fetch(c context.Context) ([]stream.Stream, error) {
-	ss, err := p.f.Fetch(c)
-	if err != nil {
-		return nil, err
-	}
-
-	return ss, nil
+	return p.f.Fetch(c)
 }